@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS authz_assignment;
+DROP TABLE IF EXISTS authz_role_action;
+DROP TABLE IF EXISTS authz_resource;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS authz_resource (id string not null primary key, parent_id string,
+    resource_type string not null);
+CREATE INDEX IF NOT EXISTS authz_resource_parent ON authz_resource (parent_id);
+CREATE TABLE IF NOT EXISTS authz_role_action (role_id string not null, action string not null,
+    PRIMARY KEY (role_id, action));
+CREATE TABLE IF NOT EXISTS authz_assignment (role_id string not null, user_id string not null,
+    resource_id string not null,
+    PRIMARY KEY (role_id, user_id, resource_id));
+CREATE INDEX IF NOT EXISTS authz_assignment_user ON authz_assignment (user_id);
@@ -0,0 +1,2 @@
+DELETE FROM authz_assignment;
+DELETE FROM authz_resource;
@@ -0,0 +1,12 @@
+-- Registers the resources and role assignments of databases that existed
+-- before the local policy engine was introduced
+INSERT OR IGNORE INTO authz_resource (id, parent_id, resource_type)
+    SELECT id, NULL, 'account' FROM account;
+INSERT OR IGNORE INTO authz_resource (id, parent_id, resource_type)
+    SELECT id, account_id, 'project' FROM project;
+INSERT OR IGNORE INTO authz_resource (id, parent_id, resource_type)
+    SELECT id, project_id, 'sprint' FROM sprint;
+INSERT OR IGNORE INTO authz_resource (id, parent_id, resource_type)
+    SELECT id, sprint_id, 'story' FROM story;
+INSERT OR IGNORE INTO authz_assignment (role_id, user_id, resource_id)
+    SELECT role_id, id, account_id FROM user WHERE role_id IS NOT NULL;
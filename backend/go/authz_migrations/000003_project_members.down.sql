@@ -0,0 +1 @@
+DELETE FROM authz_assignment WHERE role_id IN ('owner', 'maintainer', 'contributor', 'reporter');
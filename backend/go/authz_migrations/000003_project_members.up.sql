@@ -0,0 +1,3 @@
+-- Registers the project roles of members added before the local policy engine
+INSERT OR IGNORE INTO authz_assignment (role_id, user_id, resource_id)
+    SELECT role_id, user_id, project_id FROM project_member;
@@ -0,0 +1 @@
+-- The assignments of merged users are kept
@@ -0,0 +1,7 @@
+-- Users whose emails only differed in case were merged into one, which
+-- takes over the roles of their memberships
+INSERT OR IGNORE INTO authz_assignment (role_id, user_id, resource_id)
+    SELECT role_id, user_id, account_id FROM account_member;
+INSERT OR IGNORE INTO authz_assignment (role_id, user_id, resource_id)
+    SELECT role_id, user_id, project_id FROM project_member;
+DELETE FROM authz_assignment WHERE user_id NOT IN (SELECT id FROM user);
@@ -1,26 +1,45 @@
 package main
 
 import (
+	"cerberus-examples/internal/authz"
 	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/health"
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/mail"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/repositories"
 	"cerberus-examples/internal/routes"
 	"cerberus-examples/internal/server"
 	"cerberus-examples/internal/services"
+	"cerberus-examples/internal/services/jwtutils"
+	"cerberus-examples/internal/services/oidc"
+	"cerberus-examples/internal/tracing"
 	"cerberus-examples/internal/utils"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/golang-migrate/migrate/v4"
-	// Add cerberus imports here
+	migratedb "github.com/golang-migrate/migrate/v4/database"
 	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/urfave/cli/v2"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 
-	var appPort, jwtSecret string
+	var appPort, jwtAlgorithm, authzClientName, mailerName, mailDir, appUrl, mfaIssuer, logLevel, logFormat, tracingExporter, otlpEndpoint, serviceName string
+	var jwtKeyFiles, platformAdmins, trustedProxies cli.StringSlice
 	var saltRounds int
+	var accessTokenTtl, refreshTokenTtl, passwordResetTtl, emailVerificationTtl, inviteTtl, impersonationTtl, drainDelay, shutdownTimeout time.Duration
+	var loginLimits services.LoginLimits
+	var oidcConfig oidc.Config
+	var oidcProvisioning services.OidcProvisioning
 
 	app := &cli.App{
 		Flags: []cli.Flag{
@@ -31,12 +50,18 @@ func main() {
 				Destination: &appPort,
 				EnvVars:     []string{"APP_PORT"},
 			},
+			&cli.StringSliceFlag{
+				Name:        "jwtKeyFiles",
+				Usage:       "PKCS#8 PEM private keys for JWT tokens; the first one signs, the others only verify during rotation",
+				Destination: &jwtKeyFiles,
+				EnvVars:     []string{"JWT_KEY_FILES"},
+			},
 			&cli.StringFlag{
-				Name:        "jwtSecret",
-				Value:       "secret",
-				Usage:       "Secret for signing JWT tokens",
-				Destination: &jwtSecret,
-				EnvVars:     []string{"JWT_SECRET"},
+				Name:        "jwtAlgorithm",
+				Value:       jwtutils.AlgorithmEdDSA,
+				Usage:       "Algorithm of the signing key generated when no key files are given (RS256 or EdDSA)",
+				Destination: &jwtAlgorithm,
+				EnvVars:     []string{"JWT_ALGORITHM"},
 			},
 			&cli.IntFlag{
 				Name:        "saltRounds",
@@ -45,60 +70,453 @@ func main() {
 				Destination: &saltRounds,
 				EnvVars:     []string{"SALT_ROUNDS"},
 			},
-			// Add cerberus config code here
+			&cli.StringFlag{
+				Name:        "authzClient",
+				Value:       "local",
+				Usage:       "Authorization client to use (local)",
+				Destination: &authzClientName,
+				EnvVars:     []string{"AUTHZ_CLIENT"},
+			},
+			&cli.DurationFlag{
+				Name:        "accessTokenTtl",
+				Value:       15 * time.Minute,
+				Usage:       "Lifetime of issued access tokens",
+				Destination: &accessTokenTtl,
+				EnvVars:     []string{"ACCESS_TOKEN_TTL"},
+			},
+			&cli.DurationFlag{
+				Name:        "refreshTokenTtl",
+				Value:       30 * 24 * time.Hour,
+				Usage:       "Lifetime of issued refresh tokens",
+				Destination: &refreshTokenTtl,
+				EnvVars:     []string{"REFRESH_TOKEN_TTL"},
+			},
+			&cli.DurationFlag{
+				Name:        "passwordResetTtl",
+				Value:       time.Hour,
+				Usage:       "Lifetime of password reset tokens",
+				Destination: &passwordResetTtl,
+				EnvVars:     []string{"PASSWORD_RESET_TTL"},
+			},
+			&cli.DurationFlag{
+				Name:        "emailVerificationTtl",
+				Value:       24 * time.Hour,
+				Usage:       "Lifetime of email verification tokens",
+				Destination: &emailVerificationTtl,
+				EnvVars:     []string{"EMAIL_VERIFICATION_TTL"},
+			},
+			&cli.DurationFlag{
+				Name:        "inviteTtl",
+				Value:       7 * 24 * time.Hour,
+				Usage:       "Lifetime of invites",
+				Destination: &inviteTtl,
+				EnvVars:     []string{"INVITE_TTL"},
+			},
+			&cli.StringFlag{
+				Name:        "mailer",
+				Value:       "log",
+				Usage:       "Mailer to deliver mail with (log or file)",
+				Destination: &mailerName,
+				EnvVars:     []string{"MAILER"},
+			},
+			&cli.StringFlag{
+				Name:        "mailDir",
+				Value:       "mail",
+				Usage:       "Directory the file mailer writes mail to",
+				Destination: &mailDir,
+				EnvVars:     []string{"MAIL_DIR"},
+			},
+			&cli.StringFlag{
+				Name:        "appUrl",
+				Value:       "http://localhost:3000",
+				Usage:       "Base url of the frontend, used for links in mail",
+				Destination: &appUrl,
+				EnvVars:     []string{"APP_URL"},
+			},
+			&cli.StringFlag{
+				Name:        "mfaIssuer",
+				Value:       "Cerberus Example",
+				Usage:       "Issuer shown in authenticator apps",
+				Destination: &mfaIssuer,
+				EnvVars:     []string{"MFA_ISSUER"},
+			},
+			&cli.IntFlag{
+				Name:        "loginMaxFailures",
+				Value:       5,
+				Usage:       "Failed logins of an email within the failure window that lock it out",
+				Destination: &loginLimits.MaxFailures,
+				EnvVars:     []string{"LOGIN_MAX_FAILURES"},
+			},
+			&cli.IntFlag{
+				Name:        "loginMaxIpFailures",
+				Value:       50,
+				Usage:       "Failed logins from a client ip within the failure window that lock it out",
+				Destination: &loginLimits.MaxIpFailures,
+				EnvVars:     []string{"LOGIN_MAX_IP_FAILURES"},
+			},
+			&cli.DurationFlag{
+				Name:        "loginFailureWindow",
+				Value:       15 * time.Minute,
+				Usage:       "Period in which failed logins are counted",
+				Destination: &loginLimits.Window,
+				EnvVars:     []string{"LOGIN_FAILURE_WINDOW"},
+			},
+			&cli.DurationFlag{
+				Name:        "loginLockout",
+				Value:       15 * time.Minute,
+				Usage:       "Duration of a lockout after too many failed logins",
+				Destination: &loginLimits.Lockout,
+				EnvVars:     []string{"LOGIN_LOCKOUT"},
+			},
+			&cli.DurationFlag{
+				Name:        "loginDelay",
+				Value:       time.Second,
+				Usage:       "Delay after the first failed login of an email, doubled with every further failure",
+				Destination: &loginLimits.Delay,
+				EnvVars:     []string{"LOGIN_DELAY"},
+			},
+			&cli.DurationFlag{
+				Name:        "loginMaxDelay",
+				Value:       30 * time.Second,
+				Usage:       "Maximum delay between failed logins of an email",
+				Destination: &loginLimits.MaxDelay,
+				EnvVars:     []string{"LOGIN_MAX_DELAY"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcIssuer",
+				Usage:       "Issuer url of an OpenID Connect identity provider to log in with, disabled when empty",
+				Destination: &oidcConfig.Issuer,
+				EnvVars:     []string{"OIDC_ISSUER"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcClientId",
+				Usage:       "Client id at the identity provider",
+				Destination: &oidcConfig.ClientId,
+				EnvVars:     []string{"OIDC_CLIENT_ID"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcClientSecret",
+				Usage:       "Client secret at the identity provider",
+				Destination: &oidcConfig.ClientSecret,
+				EnvVars:     []string{"OIDC_CLIENT_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcRedirectUrl",
+				Value:       "http://localhost:8081/auth/oidc/callback",
+				Usage:       "Url the identity provider redirects to after a login",
+				Destination: &oidcConfig.RedirectUrl,
+				EnvVars:     []string{"OIDC_REDIRECT_URL"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcAccountId",
+				Usage:       "Account that users logging in with the identity provider for the first time are added to, disabled when empty",
+				Destination: &oidcProvisioning.AccountId,
+				EnvVars:     []string{"OIDC_ACCOUNT_ID"},
+			},
+			&cli.StringFlag{
+				Name:        "oidcRoleId",
+				Value:       services.RoleMember,
+				Usage:       "Account role of users added by a login with the identity provider",
+				Destination: &oidcProvisioning.RoleId,
+				EnvVars:     []string{"OIDC_ROLE_ID"},
+			},
+			&cli.StringSliceFlag{
+				Name:        "platformAdmins",
+				Usage:       "User ids of the platform admins, who can impersonate users of all accounts",
+				Destination: &platformAdmins,
+				EnvVars:     []string{"PLATFORM_ADMINS"},
+			},
+			&cli.DurationFlag{
+				Name:        "impersonationTtl",
+				Value:       15 * time.Minute,
+				Usage:       "Lifetime of the access tokens with which platform admins impersonate users",
+				Destination: &impersonationTtl,
+				EnvVars:     []string{"IMPERSONATION_TTL"},
+			},
+			&cli.StringSliceFlag{
+				Name:        "trustedProxies",
+				Aliases:     []string{"trusted-proxies"},
+				Usage:       "Ips or CIDR ranges of the proxies whose X-Forwarded-For and X-Real-IP headers name the client ip, none when empty",
+				Destination: &trustedProxies,
+				EnvVars:     []string{"TRUSTED_PROXIES"},
+			},
+			&cli.DurationFlag{
+				Name:        "drainDelay",
+				Value:       5 * time.Second,
+				Usage:       "Time that the app keeps taking requests after SIGTERM or SIGINT while reporting that it is not ready",
+				Destination: &drainDelay,
+				EnvVars:     []string{"DRAIN_DELAY"},
+			},
+			&cli.DurationFlag{
+				Name:        "shutdownTimeout",
+				Value:       20 * time.Second,
+				Usage:       "Time that requests in flight get to complete after SIGTERM or SIGINT",
+				Destination: &shutdownTimeout,
+				EnvVars:     []string{"SHUTDOWN_TIMEOUT"},
+			},
+			&cli.StringFlag{
+				Name:        "logLevel",
+				Value:       "info",
+				Usage:       "Lowest level of the entries that are logged (debug, info, warn or error)",
+				Destination: &logLevel,
+				EnvVars:     []string{"LOG_LEVEL"},
+			},
+			&cli.StringFlag{
+				Name:        "logFormat",
+				Value:       logging.FormatJSON,
+				Usage:       "Format of the log entries (json or text)",
+				Destination: &logFormat,
+				EnvVars:     []string{"LOG_FORMAT"},
+			},
+			&cli.StringFlag{
+				Name:        "tracingExporter",
+				Value:       tracing.ExporterNone,
+				Usage:       "Exporter of the spans of requests (none, stdout or otlp)",
+				Destination: &tracingExporter,
+				EnvVars:     []string{"TRACING_EXPORTER"},
+			},
+			&cli.StringFlag{
+				Name:        "otlpEndpoint",
+				Value:       "http://localhost:4318",
+				Usage:       "Base url of the OpenTelemetry collector that the otlp exporter posts spans to",
+				Destination: &otlpEndpoint,
+				EnvVars:     []string{"OTEL_EXPORTER_OTLP_ENDPOINT"},
+			},
+			&cli.StringFlag{
+				Name:        "serviceName",
+				Value:       "cerberus-examples",
+				Usage:       "Name of the app in the exported spans",
+				Destination: &serviceName,
+				EnvVars:     []string{"OTEL_SERVICE_NAME"},
+			},
 		},
 		Action: func(cCtx *cli.Context) error {
 
-			// App context
-			ctx := context.Background()
+			level, err := logging.ParseLevel(logLevel)
+			if err != nil {
+				return err
+			}
+			format, err := logging.ParseFormat(logFormat)
+			if err != nil {
+				return err
+			}
+			logger := logging.New(os.Stderr, level, format)
+			logging.SetDefault(logger)
+			// Entries of libraries that use the standard logger are structured too
+			log.SetFlags(0)
+			log.SetOutput(logging.StdWriter(logger, logging.LevelInfo))
 
-			// Add cerberus client and migration code here
+			if tracingExporter != tracing.ExporterNone {
+				exporter, err := tracing.NewExporter(tracingExporter, serviceName, otlpEndpoint, os.Stdout)
+				if err != nil {
+					return err
+				}
+				provider := tracing.NewProvider(exporter)
+				tracing.SetProvider(provider)
+				// The spans of the last requests are exported once the web server has stopped
+				defer func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					if err := provider.Shutdown(ctx); err != nil {
+						logger.Warn("exporting the last spans failed", "error", err)
+					}
+				}()
+				logger.Info("tracing", "exporter", tracingExporter)
+			}
+
+			// App context, which is done on SIGTERM or SIGINT. A second signal
+			// stops the app without waiting for requests in flight.
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				stop()
+			}()
 
 			db, err := database.NewDB()
 			utils.PanicOnError(err)
+			// The database is closed after the web server has stopped
 			defer func() {
 				utils.PanicOnError(db.Close())
+				logger.Info("database closed")
 			}()
-			_, err = db.Exec("PRAGMA foreign_keys=ON")
-			utils.PanicOnError(err)
+			metrics.RegisterDB(db, "sqlite")
 
 			// migrate
-			driver, err := sqlite3.WithInstance(db, &sqlite3.Config{})
-			m, err := migrate.NewWithDatabaseInstance(
-				"file://migrations", "sqlite3", driver)
-			if err != nil {
-				log.Println(err)
-			} else {
-				if err := m.Up(); err != nil {
-					log.Println(err)
-				}
-				log.Println("sqlite migration done")
+			migrations, err := migrateUp(db, "file://migrations", sqlite3.DefaultMigrationsTable)
+			utils.PanicOnError(err)
+
+			authzClient, authzCheckers, err := newAuthzClient(authzClientName, db)
+			utils.PanicOnError(err)
+
+			// Dependencies that must be up for the app to be ready
+			checkers := append([]health.Checker{
+				health.NewDatabaseChecker(db),
+				health.NewMigrationChecker("migrations", migrations),
+			}, authzCheckers...)
+
+			keys, err := jwtutils.LoadKeySet(jwtKeyFiles.Value(), jwtAlgorithm)
+			utils.PanicOnError(err)
+			if len(jwtKeyFiles.Value()) == 0 {
+				logger.Warn("no JWT key files configured, generated a signing key", "algorithm", jwtAlgorithm)
 			}
 
+			mailer, err := mail.NewMailer(mailerName, mailDir)
+			utils.PanicOnError(err)
+
 			txProvider := database.NewTxProvider(db)
 
 			userRepo := repositories.NewUserRepo(db)
 			accountRepo := repositories.NewAccountRepo(db)
+			roleRepo := repositories.NewRoleRepo(db)
 			projectRepo := repositories.NewProjectRepo(db)
 			sprintRepo := repositories.NewSprintRepo(db)
 			storyRepo := repositories.NewStoryRepo(db)
+			workflowRepo := repositories.NewWorkflowRepo(db)
+			ownershipRepo := repositories.NewOwnershipRepo(db)
+			projectMemberRepo := repositories.NewProjectMemberRepo(db)
+			sessionRepo := repositories.NewSessionRepo(db)
+			auditRepo := repositories.NewAuditRepo(db)
+
+			mfaRepo := repositories.NewMfaRepo(db)
+
+			sessionService := services.NewSessionService(
+				sessionRepo,
+				userRepo,
+				accountRepo,
+				mfaRepo,
+				keys, accessTokenTtl, refreshTokenTtl)
+
+			loginGuardService := services.NewLoginGuardService(
+				repositories.NewLoginAttemptRepo(db),
+				userRepo,
+				auditRepo,
+				ownershipRepo,
+				loginLimits)
+
+			mfaService := services.NewMfaService(
+				mfaRepo,
+				userRepo,
+				accountRepo,
+				sessionService,
+				loginGuardService,
+				mfaIssuer)
+
+			verificationService := services.NewEmailVerificationService(
+				txProvider,
+				repositories.NewEmailVerificationRepo(db),
+				userRepo,
+				sessionService,
+				mailer,
+				appUrl, emailVerificationTtl)
 
 			userService := services.NewUserService(
 				txProvider,
 				userRepo,
 				accountRepo,
-				jwtSecret, saltRounds)
+				roleRepo,
+				authzClient,
+				mfaService,
+				verificationService,
+				loginGuardService,
+				sessionService, saltRounds)
 
-			publicRoutes := publicRoutes(userService)
+			roleService := services.NewRoleService(txProvider, roleRepo, authzClient)
+			utils.PanicOnError(roleService.Sync(ctx))
+
+			passwordResetService := services.NewPasswordResetService(
+				txProvider,
+				repositories.NewPasswordResetRepo(db),
+				userRepo,
+				sessionService,
+				mailer,
+				appUrl, passwordResetTtl)
+
+			inviteService := services.NewInviteService(
+				txProvider,
+				repositories.NewInviteRepo(db),
+				userRepo,
+				roleRepo,
+				authzClient,
+				mfaService,
+				mailer,
+				appUrl, inviteTtl)
+
+			apiKeyService := services.NewApiKeyService(
+				txProvider,
+				repositories.NewApiKeyRepo(db),
+				userRepo,
+				roleRepo,
+				authzClient)
+
+			var oidcService services.OidcService
+			if oidcConfig.Issuer != "" {
+				oidcService = services.NewOidcService(
+					txProvider,
+					repositories.NewOidcLoginRepo(db),
+					oidc.NewProvider(oidcConfig),
+					userRepo,
+					roleRepo,
+					authzClient,
+					mfaService,
+					oidcProvisioning)
+			}
+
+			publicRoutes := publicRoutes(
+				userService,
+				sessionService,
+				passwordResetService,
+				verificationService,
+				inviteService,
+				mfaService,
+				oidcService,
+				keys)
+
+			impersonationService := services.NewImpersonationService(
+				userRepo,
+				auditRepo,
+				sessionService,
+				platformAdmins.Value(),
+				impersonationTtl)
 
 			privateRoutes := privateRoutes(
 				userService,
-				services.NewProjectService(txProvider, projectRepo),
-				services.NewSprintService(txProvider, sprintRepo),
-				services.NewStoryService(txProvider, storyRepo))
+				loginGuardService,
+				roleService,
+				inviteService,
+				mfaService,
+				apiKeyService,
+				services.NewAccountService(
+					accountRepo,
+					userRepo,
+					roleRepo,
+					projectRepo,
+					sprintRepo,
+					storyRepo,
+					auditRepo,
+					sessionService,
+					authzClient,
+					ownershipRepo),
+				services.NewProjectService(txProvider, projectRepo, projectMemberRepo, accountRepo, workflowRepo, ownershipRepo, authzClient),
+				services.NewProjectMemberService(projectMemberRepo, roleRepo, ownershipRepo, authzClient),
+				services.NewSprintService(txProvider, sprintRepo, ownershipRepo, authzClient),
+				services.NewStoryService(txProvider, storyRepo, workflowRepo, ownershipRepo, authzClient),
+				impersonationService)
+
+			scimRoutes := []routes.Routable{
+				routes.NewScimRoutes(services.NewScimService(
+					txProvider,
+					userRepo,
+					roleRepo,
+					authzClient,
+					userService,
+					roleService,
+					sessionService)),
+			}
 
 			// Run server with context
-			webserver := server.NewWebServer(ctx, appPort, jwtSecret, publicRoutes, privateRoutes)
+			webserver := server.NewWebServer(ctx, appPort, drainDelay, shutdownTimeout, trustedProxies.Value(), keys, roleService, sessionService, apiKeyService,
+				impersonationService, checkers, publicRoutes, privateRoutes, scimRoutes)
 			webserver.Start()
 
 			return nil
@@ -110,22 +528,94 @@ func main() {
 	}
 }
 
+// migrateUp applies the migrations of the source, and returns the driver that
+// reports their version. The app can't run on a schema that failed to migrate.
+func migrateUp(db *sql.DB, sourceUrl, migrationsTable string) (migratedb.Driver, error) {
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		return nil, fmt.Errorf("creating the migration driver for %s failed: %w", sourceUrl, err)
+	}
+	m, err := migrate.NewWithDatabaseInstance(
+		sourceUrl, "sqlite3", driver)
+	if err != nil {
+		return nil, fmt.Errorf("reading the migrations of %s failed: %w", sourceUrl, err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return nil, fmt.Errorf("migrating %s failed: %w", sourceUrl, err)
+	}
+	logging.Default().Info("sqlite migration done", "source", sourceUrl)
+	return driver, nil
+}
+
+// newAuthzClient returns the authorization client selected on the command line,
+// with the checkers of its health. The local client keeps its policies in the
+// application database.
+func newAuthzClient(name string, db *sql.DB) (authz.Client, []health.Checker, error) {
+	switch name {
+	case "local":
+		migrations, err := migrateUp(db, "file://authz_migrations", "authz_schema_migrations")
+		if err != nil {
+			return nil, nil, err
+		}
+		client := authz.NewLocalClient(db)
+		return client, []health.Checker{
+			authzChecker(client),
+			health.NewMigrationChecker("authzMigrations", migrations),
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported authorization client: %s", name)
+	}
+}
+
+func authzChecker(client authz.Client) health.Checker {
+	return health.NewChecker("authz", func(ctx context.Context) (interface{}, error) {
+		return nil, client.Ping(ctx)
+	})
+}
+
 func publicRoutes(
-	authService services.UserService) []routes.Routable {
-	return []routes.Routable{
-		routes.NewAuthRoutes(authService),
+	authService services.UserService,
+	sessionService services.SessionService,
+	passwordResetService services.PasswordResetService,
+	verificationService services.EmailVerificationService,
+	inviteService services.InviteService,
+	mfaService services.MfaService,
+	oidcService services.OidcService,
+	keys *jwtutils.KeySet) []routes.Routable {
+	publicRoutes := []routes.Routable{
+		routes.NewAuthRoutes(authService, sessionService, passwordResetService, verificationService, inviteService, mfaService),
+		routes.NewKeyRoutes(keys),
+	}
+	if oidcService != nil {
+		publicRoutes = append(publicRoutes, routes.NewOidcRoutes(oidcService))
 	}
+	return publicRoutes
 }
 
 func privateRoutes(
 	userService services.UserService,
+	loginGuardService services.LoginGuardService,
+	roleService services.RoleService,
+	inviteService services.InviteService,
+	mfaService services.MfaService,
+	apiKeyService services.ApiKeyService,
+	accountService services.AccountService,
 	projectService services.ProjectService,
+	projectMemberService services.ProjectMemberService,
 	sprintService services.SprintService,
-	storyService services.StoryService) []routes.Routable {
+	storyService services.StoryService,
+	impersonationService services.ImpersonationService) []routes.Routable {
 	return []routes.Routable{
-		routes.NewUserRoutes(userService),
+		routes.NewUserRoutes(userService, loginGuardService),
+		routes.NewRoleRoutes(roleService),
+		routes.NewInviteRoutes(inviteService),
+		routes.NewMfaRoutes(mfaService),
+		routes.NewTokenRoutes(apiKeyService),
+		routes.NewAccountRoutes(accountService),
 		routes.NewProjectRoutes(projectService),
+		routes.NewProjectMemberRoutes(projectMemberService),
 		routes.NewSprintRoutes(sprintService),
 		routes.NewStoryRoutes(storyService),
+		routes.NewAdminRoutes(impersonationService),
 	}
 }
@@ -0,0 +1,36 @@
+package authz
+
+import "context"
+
+const (
+	ResourceAccount = "account"
+	ResourceProject = "project"
+	ResourceSprint  = "sprint"
+	ResourceStory   = "story"
+)
+
+// Client is the interface to an authorization service.
+//
+// Resources form a hierarchy through their parents, and a role assigned to a
+// user on a resource applies to that resource and all of its descendants.
+type Client interface {
+	// CreateResource registers a resource below its parent, or as a root
+	// resource when parentId is empty
+	CreateResource(ctx context.Context, resourceId, parentId, resourceType string) error
+	// DeleteResource removes a resource, its descendants and their role assignments
+	DeleteResource(ctx context.Context, resourceId string) error
+	// CreateRole defines a role, or replaces the actions of an existing one
+	CreateRole(ctx context.Context, roleId string, actions []string) error
+	// DeleteRole removes a role and its assignments
+	DeleteRole(ctx context.Context, roleId string) error
+	AssignRole(ctx context.Context, roleId, userId, resourceId string) error
+	UnassignRole(ctx context.Context, roleId, userId, resourceId string) error
+	// HasPermission checks whether any role of the user on the resource or one
+	// of its ancestors grants the action
+	HasPermission(ctx context.Context, userId, resourceId, action string) (bool, error)
+	// ListPermittedResources returns the ids of the resources of the type on
+	// which the user may perform the action
+	ListPermittedResources(ctx context.Context, userId, resourceType, action string) ([]string, error)
+	// Ping checks that the authorization service can answer requests
+	Ping(ctx context.Context) error
+}
@@ -0,0 +1,226 @@
+package authz
+
+import (
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// localClient is an in-process policy engine that keeps resources, roles and
+// role assignments in the application's SQLite database. Its schema lives in
+// the authz_migrations directory.
+type localClient struct {
+	db *sql.DB
+}
+
+func NewLocalClient(db *sql.DB) Client {
+	return &localClient{
+		db: db,
+	}
+}
+
+func (c *localClient) CreateResource(ctx context.Context, resourceId, parentId, resourceType string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, c.db,
+		"insert into authz_resource(id, parent_id, resource_type) values(?, ?, ?) "+
+			"on conflict(id) do update set parent_id = excluded.parent_id, resource_type = excluded.resource_type")
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+	defer stmt.Close()
+	var parent sql.NullString
+	if parentId != "" {
+		parent = sql.NullString{String: parentId, Valid: true}
+	}
+	_, err = stmt.ExecContext(ctx, resourceId, parent, resourceType)
+
+	return
+}
+
+func (c *localClient) DeleteResource(ctx context.Context, resourceId string) (err error) {
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+
+	descendants := "with recursive descendants(id) as (" +
+		"select ? union select r.id from authz_resource r join descendants d on r.parent_id = d.id) "
+
+	_, err = tx.ExecContext(ctx,
+		descendants+"delete from authz_assignment where resource_id in (select id from descendants)", resourceId)
+	if err == nil {
+		_, err = tx.ExecContext(ctx,
+			descendants+"delete from authz_resource where id in (select id from descendants)", resourceId)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (c *localClient) CreateRole(ctx context.Context, roleId string, actions []string) (err error) {
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+
+	err = c.replaceActions(ctx, roleId, actions, tx)
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (c *localClient) replaceActions(ctx context.Context, roleId string, actions []string, tx *sql.Tx) (err error) {
+
+	_, err = tx.ExecContext(ctx, "delete from authz_role_action where role_id = ?", roleId)
+	if err != nil {
+		return
+	}
+
+	stmt, err := tracing.Prepare(ctx, tx, "insert into authz_role_action(role_id, action) values(?, ?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for _, action := range actions {
+		_, err = stmt.ExecContext(ctx, roleId, action)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (c *localClient) DeleteRole(ctx context.Context, roleId string) (err error) {
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from authz_assignment where role_id = ?", roleId)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "delete from authz_role_action where role_id = ?", roleId)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return
+	}
+
+	return tx.Commit()
+}
+
+func (c *localClient) AssignRole(ctx context.Context, roleId, userId, resourceId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, c.db,
+		"insert or ignore into authz_assignment(role_id, user_id, resource_id) values(?, ?, ?)")
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, roleId, userId, resourceId)
+
+	return
+}
+
+func (c *localClient) UnassignRole(ctx context.Context, roleId, userId, resourceId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, c.db,
+		"delete from authz_assignment where role_id = ? and user_id = ? and resource_id = ?")
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, roleId, userId, resourceId)
+
+	return
+}
+
+func (c *localClient) HasPermission(ctx context.Context, userId, resourceId, action string) (hasPermission bool, err error) {
+
+	stmt, err := tracing.Prepare(ctx, c.db,
+		"with recursive ancestors(id, parent_id) as ("+
+			"select id, parent_id from authz_resource where id = ? "+
+			"union select r.id, r.parent_id from authz_resource r join ancestors a on r.id = a.parent_id) "+
+			"select count(*) from authz_assignment asg "+
+			"join ancestors a on a.id = asg.resource_id "+
+			"join authz_role_action ra on ra.role_id = asg.role_id "+
+			"where asg.user_id = ? and ra.action = ?")
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+	defer stmt.Close()
+	var count int
+	err = stmt.QueryRowContext(ctx, resourceId, userId, action).Scan(&count)
+	if err != nil {
+		return
+	}
+
+	hasPermission = count > 0
+	return
+}
+
+func (c *localClient) ListPermittedResources(ctx context.Context, userId, resourceType, action string) (resourceIds []string, err error) {
+
+	stmt, err := tracing.Prepare(ctx, c.db,
+		"with recursive granted(id) as ("+
+			"select asg.resource_id from authz_assignment asg "+
+			"join authz_role_action ra on ra.role_id = asg.role_id "+
+			"where asg.user_id = ? and ra.action = ? "+
+			"union select r.id from authz_resource r join granted g on r.parent_id = g.id) "+
+			"select r.id from authz_resource r join granted g on g.id = r.id "+
+			"where r.resource_type = ?")
+	if err != nil {
+		logging.FromContext(ctx).Error("authorization error", "error", err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, userId, action, resourceType)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	resourceIds = []string{}
+	for rows.Next() {
+		var id string
+		err = rows.Scan(&id)
+		if err != nil {
+			return
+		}
+		resourceIds = append(resourceIds, id)
+	}
+
+	return resourceIds, rows.Err()
+}
+
+func (c *localClient) Ping(ctx context.Context) error {
+	var roles int
+	return c.db.QueryRowContext(ctx, "select count(*) from authz_role_action").Scan(&roles)
+}
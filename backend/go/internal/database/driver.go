@@ -7,10 +7,16 @@ import (
 )
 
 func NewDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./dbdata/example.db")
+	db, err := Open("./dbdata/example.db")
 	if err != nil {
 		log.Fatal(err)
 		return nil, err
 	}
 	return db, err
 }
+
+// Open opens the SQLite database in the file. Foreign keys are enforced on
+// every connection of the pool, as deletions cascade through them.
+func Open(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on")
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"cerberus-examples/internal/utils"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+// TranslateError returns a domain error for a violated constraint, which the
+// request rather than the app is to blame for: a conflict for a value that
+// must be unique, and a validation error for a value that is missing or
+// refers to nothing. Other errors are returned as they are.
+func TranslateError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		return err
+	}
+
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return utils.NewConflictError("the resource already exists", constraintFields(sqliteErr))
+	case sqlite3.ErrConstraintForeignKey:
+		return utils.NewValidationError("a referenced resource does not exist", nil)
+	case sqlite3.ErrConstraintNotNull:
+		return utils.NewValidationError("a required value is missing", constraintFields(sqliteErr))
+	default:
+		return utils.NewValidationError("a value is invalid", nil)
+	}
+}
+
+// constraintFields returns the columns named in the message of a violated
+// constraint, like "UNIQUE constraint failed: account.slug"
+func constraintFields(err sqlite3.Error) map[string]interface{} {
+	message := err.Error()
+	i := strings.LastIndex(message, ": ")
+	if i < 0 {
+		return nil
+	}
+
+	var fields []string
+	for _, column := range strings.Split(message[i+2:], ", ") {
+		fields = append(fields, column[strings.LastIndex(column, ".")+1:])
+	}
+	return map[string]interface{}{"fields": fields}
+}
@@ -0,0 +1,150 @@
+// Package health checks the dependencies of the app for the readiness probe
+// of the orchestrator.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4/database"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker checks a dependency of the app. The details, when not nil, are
+// part of the report.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) (details interface{}, err error)
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type CheckResult struct {
+	Status     string      `json:"status"`
+	Error      string      `json:"error,omitempty"`
+	Details    interface{} `json:"details,omitempty"`
+	DurationMs int64       `json:"durationMs"`
+}
+
+// Run runs the checks concurrently, each for at most the timeout. The report
+// is up when every check is.
+func Run(ctx context.Context, checkers []Checker, timeout time.Duration) Report {
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(checkers))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, checker := range checkers {
+		wg.Add(1)
+		go func(checker Checker) {
+			defer wg.Done()
+			result := check(ctx, checker)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[checker.Name()] = result
+			if result.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(checker)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func check(ctx context.Context, checker Checker) CheckResult {
+	start := time.Now()
+	done := make(chan CheckResult, 1)
+	go func() {
+		details, err := checker.Check(ctx)
+		result := CheckResult{Status: StatusUp, Details: details}
+		if err != nil {
+			result.Status = StatusDown
+			result.Error = err.Error()
+		}
+		done <- result
+	}()
+
+	var result CheckResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = CheckResult{Status: StatusDown, Error: ctx.Err().Error()}
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+type checkerFunc struct {
+	name  string
+	check func(ctx context.Context) (interface{}, error)
+}
+
+// NewChecker returns a checker that calls the function
+func NewChecker(name string, check func(ctx context.Context) (interface{}, error)) Checker {
+	return &checkerFunc{name: name, check: check}
+}
+
+func (c *checkerFunc) Name() string {
+	return c.name
+}
+
+func (c *checkerFunc) Check(ctx context.Context) (interface{}, error) {
+	return c.check(ctx)
+}
+
+// NewDatabaseChecker checks that the database answers a ping and a trivial
+// query
+func NewDatabaseChecker(db *sql.DB) Checker {
+	return NewChecker("database", func(ctx context.Context) (interface{}, error) {
+		if err := db.PingContext(ctx); err != nil {
+			return nil, err
+		}
+		var one int
+		if err := db.QueryRowContext(ctx, "select 1").Scan(&one); err != nil {
+			return nil, err
+		}
+		stats := db.Stats()
+		return map[string]interface{}{
+			"openConnections": stats.OpenConnections,
+			"inUse":           stats.InUse,
+		}, nil
+	})
+}
+
+// NewMigrationChecker reports the version of the migrations that golang-migrate
+// applied with the driver. It fails when no migration was applied, or when the
+// last one failed and left the database dirty. A nil driver means that the
+// migrations could not be run at all.
+func NewMigrationChecker(name string, driver database.Driver) Checker {
+	return NewChecker(name, func(ctx context.Context) (interface{}, error) {
+		if driver == nil {
+			return nil, fmt.Errorf("migrations were not run")
+		}
+		version, dirty, err := driver.Version()
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{"version": version, "dirty": dirty}
+		if version == database.NilVersion {
+			return details, fmt.Errorf("no migrations applied")
+		}
+		if dirty {
+			return details, fmt.Errorf("migration %d failed, the database is dirty", version)
+		}
+		return details, nil
+	})
+}
@@ -0,0 +1,301 @@
+// Package logging writes structured log entries as JSON or as key=value text.
+// Loggers are taken from the context, so that entries carry the request id,
+// user and account of the request that they were written for.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the values of fields that hold secrets
+const Redacted = "[REDACTED]"
+
+// contextFields are the values of the context that are added to every entry,
+// with the names of their fields
+var contextFields = []struct{ key, field string }{
+	{"requestId", "requestId"},
+	{"traceId", "traceId"},
+	{"userId", "userId"},
+	{"accountId", "accountId"},
+	{"actorId", "actorId"},
+	{"apiKeyId", "apiKeyId"},
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel returns the level with the name, which is case-insensitive
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+	}
+}
+
+// ParseFormat returns the format with the name, which is json or text
+func ParseFormat(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case FormatJSON:
+		return FormatJSON, nil
+	case FormatText:
+		return FormatText, nil
+	default:
+		return "", fmt.Errorf("unknown log format: %s", name)
+	}
+}
+
+// output is shared by a logger and the loggers derived from it, so that
+// entries are not interleaved
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format string
+}
+
+// Logger writes entries with the fields it was given
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo, FormatJSON)
+
+// New returns a logger that writes entries of at least the level to w in the
+// format
+func New(w io.Writer, level Level, format string) *Logger {
+	if format != FormatText {
+		format = FormatJSON
+	}
+	return &Logger{out: &output{w: w, level: level, format: format}}
+}
+
+// SetDefault replaces the logger that FromContext starts from
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+func Default() *Logger {
+	return defaultLogger
+}
+
+// FromContext returns the logger of the context, or the default logger, with
+// the request id, user and account of the context
+func FromContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		return defaultLogger
+	}
+	logger, ok := ctx.Value("logger").(*Logger)
+	if !ok {
+		logger = defaultLogger
+	}
+
+	var args []interface{}
+	for _, f := range contextFields {
+		if value, ok := ctx.Value(f.key).(string); ok && value != "" {
+			args = append(args, f.field, value)
+		}
+	}
+	return logger.With(args...)
+}
+
+// With returns a logger that adds the fields to every entry. The arguments
+// alternate between keys and values.
+func (l *Logger) With(args ...interface{}) *Logger {
+	if len(args) == 0 {
+		return l
+	}
+	fields := make([]field, len(l.fields), len(l.fields)+len(args)/2)
+	copy(fields, l.fields)
+	return &Logger{out: l.out, fields: append(fields, toFields(args)...)}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args)
+}
+
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args)
+}
+
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args)
+}
+
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args)
+}
+
+func (l *Logger) log(level Level, msg string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append([]field{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level.String()},
+		{"msg", msg},
+	}, l.fields...)
+	fields = append(fields, toFields(args)...)
+
+	var line []byte
+	if l.out.format == FormatText {
+		line = formatText(fields)
+	} else {
+		line = formatJSON(fields)
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(line)
+}
+
+func toFields(args []interface{}) []field {
+	fields := make([]field, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+		if i+1 == len(args) {
+			fields = append(fields, field{"!BADKEY", key})
+			break
+		}
+		fields = append(fields, field{key, redact(key, args[i+1])})
+	}
+	return fields
+}
+
+// redact hides the values of fields whose names suggest secrets, and values
+// that are bearer credentials
+func redact(key string, value interface{}) interface{} {
+	if isSecret(key) {
+		return Redacted
+	}
+	if s, ok := value.(string); ok && strings.HasPrefix(strings.ToLower(s), "bearer ") {
+		return Redacted
+	}
+	if err, ok := value.(error); ok && err != nil {
+		return err.Error()
+	}
+	return value
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range []string{"password", "secret", "authorization", "cookie", "otp", "recoverycode"} {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	// ids of tokens are not secret, tokens are
+	return strings.HasSuffix(key, "token") || strings.HasSuffix(key, "key")
+}
+
+func formatJSON(fields []field) []byte {
+	var b strings.Builder
+	b.WriteByte('{')
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if seen[f.key] {
+			continue
+		}
+		seen[f.key] = true
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		b.Write(key)
+		b.WriteByte(':')
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(f.value))
+		}
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func formatText(fields []field) []byte {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		value := fmt.Sprint(f.value)
+		if value == "" || strings.ContainsAny(value, " \"=\n\t") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// StdWriter returns a writer for the log package of the standard library
+// that writes each line as an entry of the level
+func StdWriter(logger *Logger, level Level) io.Writer {
+	return stdWriter{logger: logger, level: level}
+}
+
+type stdWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.logger.log(w.level, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIsSecret(t *testing.T) {
+	tests := []struct {
+		key    string
+		secret bool
+	}{
+		{"password", true},
+		{"newPassword", true},
+		{"currentPassword", true},
+		{"clientSecret", true},
+		{"Authorization", true},
+		{"cookie", true},
+		{"Set-Cookie", true},
+		{"otp", true},
+		{"recoveryCode", true},
+		{"token", true},
+		{"refreshToken", true},
+		{"mfaToken", true},
+		{"apiKey", true},
+		{"tokenId", false},
+		{"apiKeyId", false},
+		{"userId", false},
+		{"email", false},
+		{"route", false},
+		{"clientIp", false},
+	}
+	for _, test := range tests {
+		if secret := isSecret(test.key); secret != test.secret {
+			t.Errorf("expected isSecret(%q) to be %v, got %v", test.key, test.secret, secret)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		value    interface{}
+		expected interface{}
+	}{
+		{"password", "password", "Passw0rd1", Redacted},
+		{"token", "refreshToken", "opaque", Redacted},
+		{"cookie", "cookie", "oidc_state=abc", Redacted},
+		{"bearer value", "header", "Bearer eyJhbGciOi", Redacted},
+		{"bearer value of any case", "header", "bearer eyJhbGciOi", Redacted},
+		{"error", "error", errors.New("failed"), "failed"},
+		{"plain value", "route", "/api/users", "/api/users"},
+		{"number", "status", 200, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if value := redact(test.key, test.value); value != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, value)
+			}
+		})
+	}
+}
+
+// TestLoggerRedacts checks that secrets don't reach the output in either
+// format, also when they are fields of a derived logger
+func TestLoggerRedacts(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatText} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := New(&buf, LevelDebug, format).With("authorization", "Bearer access")
+
+			logger.Info("login", "email", "user@example.com", "password", "Passw0rd1", "token", "refresh",
+				"cookie", "session", "header", "Bearer other")
+
+			out := buf.String()
+			for _, secret := range []string{"Passw0rd1", "refresh", "session", "access", "other"} {
+				if strings.Contains(out, secret) {
+					t.Errorf("expected %q to be redacted, got %s", secret, out)
+				}
+			}
+			if !strings.Contains(out, "user@example.com") || !strings.Contains(out, Redacted) {
+				t.Errorf("expected the entry with redacted values, got %s", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type fileMailer struct {
+	dir string
+}
+
+// NewFileMailer returns a mailer that stores every message as a file in dir,
+// so that local development and tests can pick up the mail that was sent.
+func NewFileMailer(dir string) (Mailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (m *fileMailer) Send(ctx context.Context, message Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), message.To)
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", message.To, message.Subject, message.Body)
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o600)
+}
@@ -0,0 +1,18 @@
+package mail
+
+import (
+	"cerberus-examples/internal/logging"
+	"context"
+)
+
+type logMailer struct{}
+
+// NewLogMailer returns a mailer that writes messages to the log, for local development.
+func NewLogMailer() Mailer {
+	return &logMailer{}
+}
+
+func (m *logMailer) Send(ctx context.Context, message Message) error {
+	logging.FromContext(ctx).Info("mail", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
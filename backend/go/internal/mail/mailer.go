@@ -0,0 +1,30 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+)
+
+// Mailer delivers messages to users. Implementations for a real mail provider
+// can be plugged in without touching the services that send mail.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// NewMailer returns the mailer selected on the command line.
+func NewMailer(name, dir string) (Mailer, error) {
+	switch name {
+	case "log":
+		return NewLogMailer(), nil
+	case "file":
+		return NewFileMailer(dir)
+	default:
+		return nil, fmt.Errorf("unsupported mailer: %s", name)
+	}
+}
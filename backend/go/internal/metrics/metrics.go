@@ -0,0 +1,147 @@
+// Package metrics registers the Prometheus metrics of the app. The web server
+// records requests, and the services count the events of the business.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "cerberus"
+
+// Outcomes of a login
+const (
+	LoginSucceeded = "succeeded"
+	LoginFailed    = "failed"
+	LoginThrottled = "throttled"
+)
+
+// Operations on passwords
+const (
+	PasswordHash    = "hash"
+	PasswordCompare = "compare"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer requests by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	passwordDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_duration_seconds",
+		Help:      "Time taken to hash passwords and to compare them with their hashes.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	loginDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "login_duration_seconds",
+		Help:      "Time taken by logins with a password by outcome.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"outcome"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Logins with a password by outcome.",
+	}, []string{"outcome"})
+
+	// UsersRegistered counts the users that registered a new account
+	UsersRegistered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "users_registered_total",
+		Help:      "Users that registered a new account.",
+	})
+
+	// ProjectsCreated counts the projects created in all accounts
+	ProjectsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "projects_created_total",
+		Help:      "Projects created.",
+	})
+
+	// SprintsStarted counts the sprints started in all projects
+	SprintsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sprints_started_total",
+		Help:      "Sprints started.",
+	})
+
+	// SprintsEnded counts the sprints ended in all projects
+	SprintsEnded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sprints_ended_total",
+		Help:      "Sprints ended.",
+	})
+
+	// StoriesCreated counts the stories created in all sprints
+	StoriesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stories_created_total",
+		Help:      "Stories created.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		passwordDuration,
+		loginDuration,
+		logins,
+		UsersRegistered,
+		ProjectsCreated,
+		SprintsStarted,
+		SprintsEnded,
+		StoriesCreated,
+	)
+}
+
+// RegisterDB adds gauges and counters of the connection pool of the database
+func RegisterDB(db *sql.DB, name string) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an answered request. The route is the template that
+// matched the request, so that paths with ids don't each get their own series.
+func ObserveRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// ObservePassword records the time taken by an operation on a password since
+// start
+func ObservePassword(operation string, start time.Time) {
+	passwordDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveLogin counts a login with a password and records the time it took
+// since start
+func ObserveLogin(outcome string, start time.Time) {
+	logins.WithLabelValues(outcome).Inc()
+	loginDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
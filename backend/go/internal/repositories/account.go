@@ -1,20 +1,59 @@
 package repositories
 
 import (
+	"cerberus-examples/internal/tracing"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"github.com/google/uuid"
-	"log"
+	"time"
 )
 
 type AccountRepo interface {
-	Create(tx *sql.Tx) (Account, error)
-	FindAll() ([]Account, error)
+	Create(ctx context.Context, name, slug string, tx *sql.Tx) (Account, error)
+	Get(ctx context.Context, accountId string) (Account, error)
+	FindBySlug(ctx context.Context, slug string) (Account, error)
+	FindByUser(ctx context.Context, userId string) ([]AccountMembership, error)
+	Update(ctx context.Context, account Account) error
+	SetOwner(ctx context.Context, accountId, userId string, tx *sql.Tx) error
+	Delete(ctx context.Context, accountId string) error
 }
 
 type Account struct {
-	Id string `json:"id"`
+	Id         string          `json:"id"`
+	Name       string          `json:"name"`
+	Slug       string          `json:"slug"`
+	OwnerId    string          `json:"ownerId"`
+	CreatedAt  int64           `json:"createdAt"`
+	RequireMfa bool            `json:"requireMfa"`
+	Settings   AccountSettings `json:"settings"`
 }
 
+// AccountSettings are the defaults of the projects of an account
+type AccountSettings struct {
+	StoryStatuses    []string `json:"storyStatuses"`
+	SprintLengthDays int      `json:"sprintLengthDays"`
+}
+
+// AccountMembership is an account of a user, with the role of the user in it
+type AccountMembership struct {
+	Account
+	RoleId   string `json:"roleId"`
+	JoinedAt int64  `json:"joinedAt"`
+	Home     bool   `json:"home"`
+	Current  bool   `json:"current"`
+}
+
+// DefaultStoryStatuses are the story statuses of a new account, which the
+// workflows of its new projects start out with
+var DefaultStoryStatuses = []string{"todo", "in progress", "done"}
+
+// DefaultSprintLengthDays is the sprint length of a new account
+const DefaultSprintLengthDays = 14
+
+const accountColumns = "a.id, a.name, a.slug, a.owner_id, a.created_at, a.require_mfa, " +
+	"a.story_statuses, a.sprint_length_days"
+
 type accountRepo struct {
 	db *sql.DB
 }
@@ -25,68 +64,268 @@ func NewAccountRepo(db *sql.DB) AccountRepo {
 	}
 }
 
-func (r *accountRepo) Create(tx *sql.Tx) (account Account, err error) {
+func (r *accountRepo) Create(ctx context.Context, name, slug string, tx *sql.Tx) (account Account, err error) {
 	if tx != nil {
-		return r.create(tx)
+		return r.create(ctx, name, slug, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	account, err = r.create(tx)
+	account, err = r.create(ctx, name, slug, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *accountRepo) create(tx *sql.Tx) (account Account, err error) {
-	stmt, err := tx.Prepare("insert into account(id) values(?)")
+func (r *accountRepo) create(ctx context.Context, name, slug string, tx *sql.Tx) (account Account, err error) {
+
+	statuses, err := json.Marshal(DefaultStoryStatuses)
+	if err != nil {
+		return
+	}
+
+	stmt, err := tracing.Prepare(ctx, tx, "insert into account(id, name, slug, created_at, story_statuses, sprint_length_days) "+
+		"values(?, ?, ?, ?, ?, ?)")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	id := uuid.New().String()
-	_, err = stmt.Exec(id)
+	createdAt := time.Now().Unix()
+	_, err = stmt.ExecContext(ctx, id, name, slug, createdAt, string(statuses), DefaultSprintLengthDays)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	account = Account{
-		Id: id,
+		Id:        id,
+		Name:      name,
+		Slug:      slug,
+		CreatedAt: createdAt,
+		Settings: AccountSettings{
+			StoryStatuses:    DefaultStoryStatuses,
+			SprintLengthDays: DefaultSprintLengthDays,
+		},
+	}
+	return
+}
+
+func (r *accountRepo) Get(ctx context.Context, accountId string) (Account, error) {
+	return r.findOne(ctx, "select "+accountColumns+" from account a where a.id = ?", accountId)
+}
+
+func (r *accountRepo) FindBySlug(ctx context.Context, slug string) (Account, error) {
+	return r.findOne(ctx, "select "+accountColumns+" from account a where a.slug = ?", slug)
+}
+
+func (r *accountRepo) findOne(ctx context.Context, query, arg string) (account Account, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, query)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = scanAccount(stmt.QueryRowContext(ctx, arg).Scan, &account)
+
+	return
+}
+
+// Update stores the name, slug, mfa requirement and settings of the account
+func (r *accountRepo) Update(ctx context.Context, account Account) (err error) {
+
+	statuses, err := json.Marshal(account.Settings.StoryStatuses)
+	if err != nil {
+		return
+	}
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update account set name = ?, slug = ?, require_mfa = ?, "+
+		"story_statuses = ?, sprint_length_days = ? where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, account.Name, account.Slug, account.RequireMfa,
+		string(statuses), account.Settings.SprintLengthDays, account.Id)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (r *accountRepo) SetOwner(ctx context.Context, accountId, userId string, tx *sql.Tx) (err error) {
+	if tx != nil {
+		return r.setOwner(ctx, accountId, userId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
 	}
+
+	err = r.setOwner(ctx, accountId, userId, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
 	return
 }
 
-func (r *accountRepo) FindAll() (accounts []Account, err error) {
+func (r *accountRepo) setOwner(ctx context.Context, accountId, userId string, tx *sql.Tx) (err error) {
 
-	stmt, err := r.db.Prepare("select id from account")
+	stmt, err := tracing.Prepare(ctx, tx, "update account set owner_id = ? where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
 	defer stmt.Close()
-	rows, err := stmt.Query()
+	res, err := stmt.ExecContext(ctx, userId, accountId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return
 	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// Delete deletes the account with its users, roles, projects, sprints and
+// stories. Users that were created by the account but are members of other
+// accounts are kept, and move to the oldest of those.
+func (r *accountRepo) Delete(ctx context.Context, accountId string) (err error) {
 
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	_, err = tracing.Exec(ctx, tx, "update user set account_id = (select m.account_id from account_member m "+
+		"where m.user_id = user.id and m.account_id != ? order by m.created_at asc limit 1) "+
+		"where account_id = ? and exists (select 1 from account_member m "+
+		"where m.user_id = user.id and m.account_id != ?)", accountId, accountId, accountId)
+	var res sql.Result
+	if err == nil {
+		res, err = tracing.Exec(ctx, tx, "delete from account where id = ?", accountId)
+	}
+	var affected int64
+	if err == nil {
+		affected, err = res.RowsAffected()
+	}
+	if err == nil && affected == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// FindByUser returns the accounts the user is a member of, starting with the
+// account that created the user
+func (r *accountRepo) FindByUser(ctx context.Context, userId string) (memberships []AccountMembership, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select "+accountColumns+", m.role_id, m.created_at, a.id = u.account_id "+
+		"from account_member m "+
+		"join account a on a.id = m.account_id "+
+		"join user u on u.id = m.user_id "+
+		"where m.user_id = ? order by a.id = u.account_id desc, m.created_at asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, userId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	memberships = []AccountMembership{}
 	for rows.Next() {
-		var id string
-		err = rows.Scan(&id)
+		var membership AccountMembership
+		err = scanAccount(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &membership.RoleId, &membership.JoinedAt, &membership.Home)...)
+		}, &membership.Account)
 		if err != nil {
 			return
 		}
-
-		accounts = append(accounts, Account{
-			Id: id,
-		})
+		memberships = append(memberships, membership)
 	}
+	err = rows.Err()
 
 	return
 }
+
+// scanAccount scans the accountColumns of a row, and any columns that follow
+// them when scan appends their destinations
+func scanAccount(scan func(dest ...interface{}) error, account *Account) error {
+
+	var slug, ownerId sql.NullString
+	var statuses string
+	err := scan(&account.Id, &account.Name, &slug, &ownerId, &account.CreatedAt, &account.RequireMfa,
+		&statuses, &account.Settings.SprintLengthDays)
+	if err != nil {
+		return err
+	}
+
+	account.Slug = slug.String
+	account.OwnerId = ownerId.String
+	return json.Unmarshal([]byte(statuses), &account.Settings.StoryStatuses)
+}
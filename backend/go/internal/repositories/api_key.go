@@ -0,0 +1,221 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"strings"
+	"time"
+)
+
+type ApiKeyRepo interface {
+	Create(ctx context.Context, key ApiKey, tx *sql.Tx) (ApiKey, error)
+	Get(ctx context.Context, keyId string) (ApiKey, error)
+	FindPersonal(ctx context.Context, userId string) ([]ApiKey, error)
+	FindService(ctx context.Context, accountId string) ([]ApiKey, error)
+	FindByTokenHash(ctx context.Context, tokenHash string) (ApiKey, error)
+	Revoke(ctx context.Context, keyId string) error
+	Touch(ctx context.Context, keyId string, usedAt int64) error
+}
+
+// ApiKey lets scripts call the api without an interactive login. A personal
+// key acts as the user who created it, a service key as a service user of its
+// own. Either can only use the permissions in its scopes. Only the hash of the
+// key is stored, the prefix is kept so that keys can be recognized in a list.
+type ApiKey struct {
+	Id         string   `json:"id"`
+	AccountId  string   `json:"accountId"`
+	UserId     string   `json:"userId"`
+	Name       string   `json:"name"`
+	Service    bool     `json:"service"`
+	Prefix     string   `json:"prefix"`
+	TokenHash  string   `json:"-"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  string   `json:"createdBy"`
+	CreatedAt  int64    `json:"createdAt"`
+	ExpiresAt  int64    `json:"expiresAt,omitempty"`
+	LastUsedAt int64    `json:"lastUsedAt,omitempty"`
+	RevokedAt  int64    `json:"revokedAt,omitempty"`
+	// Only set when the key is created
+	Token string `json:"token,omitempty"`
+}
+
+type apiKeyRepo struct {
+	db *sql.DB
+}
+
+func NewApiKeyRepo(db *sql.DB) ApiKeyRepo {
+	return &apiKeyRepo{
+		db: db,
+	}
+}
+
+const apiKeyColumns = "id, account_id, user_id, name, service, prefix, token_hash, scopes, " +
+	"created_by, created_at, expires_at, last_used_at, revoked_at"
+
+func (r *apiKeyRepo) Create(ctx context.Context, key ApiKey, tx *sql.Tx) (_ ApiKey, err error) {
+
+	if tx != nil {
+		return r.create(ctx, key, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	key, err = r.create(ctx, key, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return key, nil
+}
+
+func (r *apiKeyRepo) create(ctx context.Context, key ApiKey, tx *sql.Tx) (_ ApiKey, err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "insert into api_key("+apiKeyColumns+") "+
+		"values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, null, null)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	key.Id = uuid.New().String()
+	key.CreatedAt = time.Now().Unix()
+	var expiresAt sql.NullInt64
+	if key.ExpiresAt != 0 {
+		expiresAt = sql.NullInt64{Int64: key.ExpiresAt, Valid: true}
+	}
+	_, err = stmt.ExecContext(ctx, key.Id, key.AccountId, key.UserId, key.Name, key.Service, key.Prefix, key.TokenHash,
+		strings.Join(key.Scopes, " "), key.CreatedBy, key.CreatedAt, expiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return key, nil
+}
+
+func (r *apiKeyRepo) Get(ctx context.Context, keyId string) (ApiKey, error) {
+	return r.findOne(ctx, "where id = ?", keyId)
+}
+
+func (r *apiKeyRepo) FindPersonal(ctx context.Context, userId string) ([]ApiKey, error) {
+	return r.find(ctx, "where user_id = ? and service = 0 order by created_at desc, name asc", userId)
+}
+
+func (r *apiKeyRepo) FindService(ctx context.Context, accountId string) ([]ApiKey, error) {
+	return r.find(ctx, "where account_id = ? and service = 1 order by created_at desc, name asc", accountId)
+}
+
+func (r *apiKeyRepo) FindByTokenHash(ctx context.Context, tokenHash string) (ApiKey, error) {
+	return r.findOne(ctx, "where token_hash = ?", tokenHash)
+}
+
+func (r *apiKeyRepo) findOne(ctx context.Context, condition string, args ...interface{}) (key ApiKey, err error) {
+
+	keys, err := r.find(ctx, condition, args...)
+	if err != nil {
+		return
+	}
+	if len(keys) == 0 {
+		err = sql.ErrNoRows
+		return
+	}
+
+	return keys[0], nil
+}
+
+func (r *apiKeyRepo) find(ctx context.Context, condition string, args ...interface{}) (keys []ApiKey, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select "+apiKeyColumns+" from api_key "+condition)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key ApiKey
+		var scopes string
+		var expiresAt, lastUsedAt, revokedAt sql.NullInt64
+		err = rows.Scan(&key.Id, &key.AccountId, &key.UserId, &key.Name, &key.Service, &key.Prefix,
+			&key.TokenHash, &scopes, &key.CreatedBy, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+		if err != nil {
+			return
+		}
+		key.Scopes = strings.Fields(scopes)
+		key.ExpiresAt = expiresAt.Int64
+		key.LastUsedAt = lastUsedAt.Int64
+		key.RevokedAt = revokedAt.Int64
+
+		keys = append(keys, key)
+	}
+
+	return
+}
+
+// Revoke revokes a key that is not revoked yet. It returns sql.ErrNoRows
+// otherwise.
+func (r *apiKeyRepo) Revoke(ctx context.Context, keyId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update api_key set revoked_at = ? where id = ? and revoked_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, time.Now().Unix(), keyId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// Touch records when the key was last used. It writes at most once a minute
+// per key, as keys of scripts can be used for many requests in a row.
+func (r *apiKeyRepo) Touch(ctx context.Context, keyId string, usedAt int64) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update api_key set last_used_at = ? "+
+		"where id = ? and (last_used_at is null or last_used_at < ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, usedAt, keyId, usedAt-60)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuditRepo interface {
+	Create(ctx context.Context, entry AuditEntry) error
+}
+
+// AuditEntry records a security relevant action. AccountId and ActorId are
+// empty when the action can't be attributed, like a lockout of an unknown email.
+type AuditEntry struct {
+	Id        string                 `json:"id"`
+	AccountId string                 `json:"accountId"`
+	ActorId   string                 `json:"actorId"`
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"`
+	Details   map[string]interface{} `json:"details"`
+	Ip        string                 `json:"ip"`
+	CreatedAt int64                  `json:"createdAt"`
+}
+
+type auditRepo struct {
+	db *sql.DB
+}
+
+func NewAuditRepo(db *sql.DB) AuditRepo {
+	return &auditRepo{
+		db: db,
+	}
+}
+
+func (r *auditRepo) Create(ctx context.Context, entry AuditEntry) (err error) {
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return
+	}
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into audit_log(id, account_id, actor_id, action, target, details, ip, created_at) "+
+		"values(?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, uuid.New().String(), nullString(entry.AccountId), nullString(entry.ActorId),
+		entry.Action, entry.Target, string(details), entry.Ip, time.Now().Unix())
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
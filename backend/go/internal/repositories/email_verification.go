@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+type EmailVerificationRepo interface {
+	Create(ctx context.Context, userId, tokenHash string, expiresAt int64) (EmailVerification, error)
+	FindByTokenHash(ctx context.Context, tokenHash string) (EmailVerification, error)
+	Consume(ctx context.Context, verification EmailVerification, tx *sql.Tx) error
+}
+
+// EmailVerification is a single-use token mailed to a user to prove that they
+// own their email address. Only the hash of the token is stored.
+type EmailVerification struct {
+	Id        string
+	UserId    string
+	TokenHash string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+type emailVerificationRepo struct {
+	db *sql.DB
+}
+
+func NewEmailVerificationRepo(db *sql.DB) EmailVerificationRepo {
+	return &emailVerificationRepo{
+		db: db,
+	}
+}
+
+func (r *emailVerificationRepo) Create(ctx context.Context, userId, tokenHash string, expiresAt int64) (verification EmailVerification, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into email_verification(id, user_id, token_hash, created_at, expires_at) values(?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	verification = EmailVerification{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	_, err = stmt.ExecContext(ctx, verification.Id, verification.UserId, verification.TokenHash, verification.CreatedAt, verification.ExpiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return EmailVerification{}, err
+	}
+
+	return
+}
+
+func (r *emailVerificationRepo) FindByTokenHash(ctx context.Context, tokenHash string) (verification EmailVerification, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select id, user_id, created_at, expires_at, used_at from email_verification where token_hash = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var usedAt sql.NullInt64
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&verification.Id, &verification.UserId, &verification.CreatedAt, &verification.ExpiresAt, &usedAt)
+	if err != nil {
+		return
+	}
+
+	verification.TokenHash = tokenHash
+	verification.UsedAt = usedAt.Int64
+
+	return
+}
+
+// Consume marks the verification as used together with any other open
+// verifications of the same user. It returns sql.ErrNoRows when the
+// verification was used in the meantime.
+func (r *emailVerificationRepo) Consume(ctx context.Context, verification EmailVerification, tx *sql.Tx) (err error) {
+
+	now := time.Now().Unix()
+	res, err := tracing.Exec(ctx, tx, "update email_verification set used_at = ? where id = ? and used_at is null", now, verification.Id)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tracing.Exec(ctx, tx, "update email_verification set used_at = ? where user_id = ? and used_at is null", now, verification.UserId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,194 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusRevoked  = "revoked"
+	InviteStatusExpired  = "expired"
+)
+
+type InviteRepo interface {
+	Create(ctx context.Context, invite Invite) (Invite, error)
+	FindByAccount(ctx context.Context, accountId string) ([]Invite, error)
+	FindPendingByEmail(ctx context.Context, accountId, email string) ([]Invite, error)
+	FindByTokenHash(ctx context.Context, tokenHash string) (Invite, error)
+	Revoke(ctx context.Context, accountId, inviteId string) error
+	Accept(ctx context.Context, inviteId string, tx *sql.Tx) error
+}
+
+// Invite lets someone join an account with a role. Only the hash of the
+// invite token is stored.
+type Invite struct {
+	Id         string `json:"id"`
+	AccountId  string `json:"accountId"`
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	RoleId     string `json:"roleId"`
+	InvitedBy  string `json:"invitedBy"`
+	TokenHash  string `json:"-"`
+	CreatedAt  int64  `json:"createdAt"`
+	ExpiresAt  int64  `json:"expiresAt"`
+	AcceptedAt int64  `json:"acceptedAt,omitempty"`
+	RevokedAt  int64  `json:"revokedAt,omitempty"`
+	Status     string `json:"status"`
+}
+
+type inviteRepo struct {
+	db *sql.DB
+}
+
+func NewInviteRepo(db *sql.DB) InviteRepo {
+	return &inviteRepo{
+		db: db,
+	}
+}
+
+const inviteColumns = "id, account_id, email, name, role_id, invited_by, token_hash, " +
+	"created_at, expires_at, accepted_at, revoked_at"
+
+func (r *inviteRepo) Create(ctx context.Context, invite Invite) (_ Invite, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into invite("+inviteColumns+") values(?, ?, ?, ?, ?, ?, ?, ?, ?, null, null)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	invite.Id = uuid.New().String()
+	invite.CreatedAt = time.Now().Unix()
+	invite.Email = NormalizeEmail(invite.Email)
+	_, err = stmt.ExecContext(ctx, invite.Id, invite.AccountId, invite.Email, invite.Name, invite.RoleId,
+		invite.InvitedBy, invite.TokenHash, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	invite.Status = InviteStatusPending
+	return invite, nil
+}
+
+func (r *inviteRepo) FindByAccount(ctx context.Context, accountId string) (invites []Invite, err error) {
+	return r.find(ctx, "where account_id = ? order by created_at desc, email asc", accountId)
+}
+
+func (r *inviteRepo) FindPendingByEmail(ctx context.Context, accountId, email string) (invites []Invite, err error) {
+	return r.find(ctx, "where account_id = ? and email = ? collate nocase and accepted_at is null and revoked_at is null and expires_at > ?",
+		accountId, NormalizeEmail(email), time.Now().Unix())
+}
+
+func (r *inviteRepo) FindByTokenHash(ctx context.Context, tokenHash string) (invite Invite, err error) {
+
+	invites, err := r.find(ctx, "where token_hash = ?", tokenHash)
+	if err != nil {
+		return
+	}
+	if len(invites) == 0 {
+		err = sql.ErrNoRows
+		return
+	}
+
+	return invites[0], nil
+}
+
+func (r *inviteRepo) find(ctx context.Context, condition string, args ...interface{}) (invites []Invite, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select "+inviteColumns+" from invite "+condition)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().Unix()
+	for rows.Next() {
+		var invite Invite
+		var acceptedAt, revokedAt sql.NullInt64
+		err = rows.Scan(&invite.Id, &invite.AccountId, &invite.Email, &invite.Name, &invite.RoleId,
+			&invite.InvitedBy, &invite.TokenHash, &invite.CreatedAt, &invite.ExpiresAt, &acceptedAt, &revokedAt)
+		if err != nil {
+			return
+		}
+		invite.AcceptedAt = acceptedAt.Int64
+		invite.RevokedAt = revokedAt.Int64
+
+		switch {
+		case acceptedAt.Valid:
+			invite.Status = InviteStatusAccepted
+		case revokedAt.Valid:
+			invite.Status = InviteStatusRevoked
+		case invite.ExpiresAt <= now:
+			invite.Status = InviteStatusExpired
+		default:
+			invite.Status = InviteStatusPending
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return
+}
+
+// Revoke withdraws a pending invite. It returns sql.ErrNoRows when the invite
+// does not exist in the account or was already accepted or revoked.
+func (r *inviteRepo) Revoke(ctx context.Context, accountId, inviteId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update invite set revoked_at = ? "+
+		"where id = ? and account_id = ? and accepted_at is null and revoked_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, time.Now().Unix(), inviteId, accountId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// Accept marks a pending invite as accepted. It returns sql.ErrNoRows when the
+// invite was accepted, revoked or expired in the meantime.
+func (r *inviteRepo) Accept(ctx context.Context, inviteId string, tx *sql.Tx) (err error) {
+
+	now := time.Now().Unix()
+	res, err := tracing.Exec(ctx, tx, "update invite set accepted_at = ? "+
+		"where id = ? and accepted_at is null and revoked_at is null and expires_at > ?", now, inviteId, now)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
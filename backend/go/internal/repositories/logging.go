@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/logging"
+	"context"
+	"runtime"
+	"strings"
+)
+
+// logError logs an error of the database with the function that ran into it
+// and the request that the context belongs to
+func logError(ctx context.Context, err error) {
+	source := "unknown"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		name := runtime.FuncForPC(pc).Name()
+		source = name[strings.LastIndex(name, "/")+1:]
+	}
+	logging.FromContext(ctx).Error("database error", "error", err, "source", source)
+}
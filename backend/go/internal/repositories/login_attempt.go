@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Subjects that failed logins are tracked and locked out by
+const (
+	LoginSubjectEmail = "email"
+	LoginSubjectIp    = "ip"
+)
+
+type LoginAttemptRepo interface {
+	AddFailure(ctx context.Context, email, ip string) error
+	CountFailures(ctx context.Context, subjectType, subject string, since int64) (count int, last int64, err error)
+	ClearFailures(ctx context.Context, subjectType, subject string) error
+	PurgeFailures(ctx context.Context, before int64) error
+	Lock(ctx context.Context, subjectType, subject string, until int64) error
+	LockedUntil(ctx context.Context, subjectType, subject string) (int64, error)
+	Unlock(ctx context.Context, subjectType, subject string) error
+}
+
+type loginAttemptRepo struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepo(db *sql.DB) LoginAttemptRepo {
+	return &loginAttemptRepo{
+		db: db,
+	}
+}
+
+// AddFailure records a failed login for the email, and for the ip when it is
+// known. Each is counted and cleared on its own.
+func (r *loginAttemptRepo) AddFailure(ctx context.Context, email, ip string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into login_failure(id, subject_type, subject, created_at) values(?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	now := time.Now().Unix()
+	_, err = stmt.ExecContext(ctx, uuid.New().String(), LoginSubjectEmail, email, now)
+	if err == nil && ip != "" {
+		_, err = stmt.ExecContext(ctx, uuid.New().String(), LoginSubjectIp, ip, now)
+	}
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// CountFailures returns the number of failures of the email or ip since the
+// given time, and when the last one happened
+func (r *loginAttemptRepo) CountFailures(ctx context.Context, subjectType, subject string, since int64) (count int, last int64, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select count(*), coalesce(max(created_at), 0) from login_failure "+
+		"where subject_type = ? and subject = ? and created_at >= ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, subjectType, subject, since).Scan(&count, &last)
+
+	return
+}
+
+// ClearFailures removes the failures of the email or ip, and leaves those of
+// the other kind
+func (r *loginAttemptRepo) ClearFailures(ctx context.Context, subjectType, subject string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from login_failure where subject_type = ? and subject = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, subjectType, subject)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *loginAttemptRepo) PurgeFailures(ctx context.Context, before int64) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from login_failure where created_at < ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, before)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *loginAttemptRepo) Lock(ctx context.Context, subjectType, subject string, until int64) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert or replace into login_lockout(subject_type, subject, locked_until, created_at) values(?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, subjectType, subject, until, time.Now().Unix())
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// LockedUntil returns until when the email or ip is locked out, or 0
+func (r *loginAttemptRepo) LockedUntil(ctx context.Context, subjectType, subject string) (until int64, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select locked_until from login_lockout where subject_type = ? and subject = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, subjectType, subject).Scan(&until)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return
+}
+
+func (r *loginAttemptRepo) Unlock(ctx context.Context, subjectType, subject string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from login_lockout where subject_type = ? and subject = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, subjectType, subject)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,321 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+type MfaRepo interface {
+	SaveTotp(ctx context.Context, userId, secret string, recoveryCodeHashes []string) error
+	GetTotp(ctx context.Context, userId string) (Totp, error)
+	ConfirmTotp(ctx context.Context, userId string, step int64) error
+	UseTotpStep(ctx context.Context, userId string, step int64) error
+	DeleteTotp(ctx context.Context, userId string) error
+	UseRecoveryCode(ctx context.Context, userId, codeHash string) error
+	CreateChallenge(ctx context.Context, userId, accountId, tokenHash string, expiresAt int64) (MfaChallenge, error)
+	FindChallenge(ctx context.Context, tokenHash string) (MfaChallenge, error)
+	FailChallenge(ctx context.Context, challengeId string) error
+	CompleteChallenge(ctx context.Context, challengeId string) error
+}
+
+// Totp is the authenticator app enrollment of a user. It only counts as a
+// second factor once it is confirmed with a valid code.
+type Totp struct {
+	UserId      string
+	Secret      string
+	CreatedAt   int64
+	ConfirmedAt int64
+	LastStep    int64
+}
+
+// MfaChallenge is handed out after a correct password when a second factor
+// is needed. Only the hash of its token is stored.
+type MfaChallenge struct {
+	Id          string
+	UserId      string
+	AccountId   string
+	TokenHash   string
+	CreatedAt   int64
+	ExpiresAt   int64
+	Attempts    int
+	CompletedAt int64
+}
+
+type mfaRepo struct {
+	db *sql.DB
+}
+
+func NewMfaRepo(db *sql.DB) MfaRepo {
+	return &mfaRepo{
+		db: db,
+	}
+}
+
+// SaveTotp starts a new enrollment, replacing an earlier one together with
+// its recovery codes
+func (r *mfaRepo) SaveTotp(ctx context.Context, userId, secret string, recoveryCodeHashes []string) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.saveTotp(ctx, userId, secret, recoveryCodeHashes, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *mfaRepo) saveTotp(ctx context.Context, userId, secret string, recoveryCodeHashes []string, tx *sql.Tx) (err error) {
+
+	_, err = tracing.Exec(ctx, tx, "insert or replace into mfa_totp(user_id, secret, created_at, confirmed_at, last_step) "+
+		"values(?, ?, ?, null, 0)", userId, secret, time.Now().Unix())
+	if err != nil {
+		return
+	}
+
+	_, err = tracing.Exec(ctx, tx, "delete from mfa_recovery_code where user_id = ?", userId)
+	if err != nil {
+		return
+	}
+
+	stmt, err := tracing.Prepare(ctx, tx, "insert into mfa_recovery_code(id, user_id, code_hash) values(?, ?, ?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err = stmt.ExecContext(ctx, uuid.New().String(), userId, codeHash); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (r *mfaRepo) GetTotp(ctx context.Context, userId string) (totp Totp, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select secret, created_at, confirmed_at, last_step from mfa_totp where user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var confirmedAt sql.NullInt64
+	err = stmt.QueryRowContext(ctx, userId).Scan(&totp.Secret, &totp.CreatedAt, &confirmedAt, &totp.LastStep)
+	if err != nil {
+		return
+	}
+
+	totp.UserId = userId
+	totp.ConfirmedAt = confirmedAt.Int64
+
+	return
+}
+
+// ConfirmTotp activates the enrollment with the step of the code it was
+// confirmed with. It returns sql.ErrNoRows when the step was used before.
+func (r *mfaRepo) ConfirmTotp(ctx context.Context, userId string, step int64) error {
+	return r.updateTotp(ctx, "update mfa_totp set confirmed_at = ?, last_step = ? "+
+		"where user_id = ? and last_step < ?", time.Now().Unix(), step, userId, step)
+}
+
+// UseTotpStep records the step of a code that was used to log in. It returns
+// sql.ErrNoRows when the step was used before.
+func (r *mfaRepo) UseTotpStep(ctx context.Context, userId string, step int64) error {
+	return r.updateTotp(ctx, "update mfa_totp set last_step = ? "+
+		"where user_id = ? and last_step < ? and confirmed_at is not null", step, userId, step)
+}
+
+func (r *mfaRepo) updateTotp(ctx context.Context, query string, args ...interface{}) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, query)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (r *mfaRepo) DeleteTotp(ctx context.Context, userId string) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	_, err = tracing.Exec(ctx, tx, "delete from mfa_recovery_code where user_id = ?", userId)
+	if err == nil {
+		_, err = tracing.Exec(ctx, tx, "delete from mfa_totp where user_id = ?", userId)
+	}
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It returns
+// sql.ErrNoRows when the user has no such code.
+func (r *mfaRepo) UseRecoveryCode(ctx context.Context, userId, codeHash string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update mfa_recovery_code set used_at = ? "+
+		"where user_id = ? and code_hash = ? and used_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, time.Now().Unix(), userId, codeHash)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// CreateChallenge starts a login to the account that waits for a second factor
+func (r *mfaRepo) CreateChallenge(ctx context.Context, userId, accountId, tokenHash string, expiresAt int64) (challenge MfaChallenge, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into mfa_challenge(id, user_id, account_id, token_hash, created_at, expires_at) "+
+		"values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	challenge = MfaChallenge{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		AccountId: accountId,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	_, err = stmt.ExecContext(ctx, challenge.Id, challenge.UserId, challenge.AccountId, challenge.TokenHash,
+		challenge.CreatedAt, challenge.ExpiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return MfaChallenge{}, err
+	}
+
+	return
+}
+
+func (r *mfaRepo) FindChallenge(ctx context.Context, tokenHash string) (challenge MfaChallenge, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select c.id, c.user_id, coalesce(c.account_id, u.account_id), c.created_at, "+
+		"c.expires_at, c.attempts, c.completed_at "+
+		"from mfa_challenge c join user u on u.id = c.user_id where c.token_hash = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var completedAt sql.NullInt64
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&challenge.Id, &challenge.UserId, &challenge.AccountId, &challenge.CreatedAt,
+		&challenge.ExpiresAt, &challenge.Attempts, &completedAt)
+	if err != nil {
+		return
+	}
+
+	challenge.TokenHash = tokenHash
+	challenge.CompletedAt = completedAt.Int64
+
+	return
+}
+
+func (r *mfaRepo) FailChallenge(ctx context.Context, challengeId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update mfa_challenge set attempts = attempts + 1 where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, challengeId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// CompleteChallenge marks the challenge as passed. It returns sql.ErrNoRows
+// when it was completed before.
+func (r *mfaRepo) CompleteChallenge(ctx context.Context, challengeId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update mfa_challenge set completed_at = ? where id = ? and completed_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, time.Now().Unix(), challengeId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
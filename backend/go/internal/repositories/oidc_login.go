@@ -0,0 +1,93 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+type OidcLoginRepo interface {
+	Create(ctx context.Context, login OidcLogin) (OidcLogin, error)
+	Consume(ctx context.Context, stateHash string) (OidcLogin, error)
+}
+
+// OidcLogin keeps what is needed to complete a login at the identity
+// provider: the nonce expected in the ID token and the PKCE code verifier.
+// Only the hash of the state handed to the provider is stored.
+type OidcLogin struct {
+	Id           string
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    int64
+	ExpiresAt    int64
+	UsedAt       int64
+}
+
+type oidcLoginRepo struct {
+	db *sql.DB
+}
+
+func NewOidcLoginRepo(db *sql.DB) OidcLoginRepo {
+	return &oidcLoginRepo{
+		db: db,
+	}
+}
+
+func (r *oidcLoginRepo) Create(ctx context.Context, login OidcLogin) (_ OidcLogin, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into oidc_login(id, state_hash, nonce, code_verifier, created_at, expires_at) "+
+		"values(?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	login.Id = uuid.New().String()
+	login.CreatedAt = time.Now().Unix()
+	_, err = stmt.ExecContext(ctx, login.Id, login.StateHash, login.Nonce, login.CodeVerifier, login.CreatedAt, login.ExpiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return login, nil
+}
+
+// Consume marks the login with the state as used and returns it. It returns
+// sql.ErrNoRows when there is no such login or it was used before.
+func (r *oidcLoginRepo) Consume(ctx context.Context, stateHash string) (login OidcLogin, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select id, nonce, code_verifier, created_at, expires_at from oidc_login "+
+		"where state_hash = ? and used_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, stateHash).Scan(&login.Id, &login.Nonce, &login.CodeVerifier, &login.CreatedAt, &login.ExpiresAt)
+	if err != nil {
+		return
+	}
+
+	login.StateHash = stateHash
+	login.UsedAt = time.Now().Unix()
+
+	res, err := tracing.Exec(ctx, r.db, "update oidc_login set used_at = ? where id = ? and used_at is null", login.UsedAt, login.Id)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+)
+
+// OwnershipRepo resolves the account that owns a resource by walking up the
+// story -> sprint -> project -> account hierarchy. Users are not owned by one
+// account, but can be members of several.
+type OwnershipRepo interface {
+	ProjectAccount(ctx context.Context, projectId string) (string, error)
+	SprintAccount(ctx context.Context, sprintId string) (string, error)
+	StoryAccount(ctx context.Context, storyId string) (string, error)
+	AccountMember(ctx context.Context, accountId, userId string) error
+}
+
+type ownershipRepo struct {
+	db *sql.DB
+}
+
+func NewOwnershipRepo(db *sql.DB) OwnershipRepo {
+	return &ownershipRepo{
+		db: db,
+	}
+}
+
+func (r *ownershipRepo) ProjectAccount(ctx context.Context, projectId string) (string, error) {
+	return r.resolve(ctx, "select account_id from project where id = ?", projectId)
+}
+
+func (r *ownershipRepo) SprintAccount(ctx context.Context, sprintId string) (string, error) {
+	return r.resolve(ctx,
+		"select p.account_id from sprint s "+
+			"join project p on p.id = s.project_id "+
+			"where s.id = ?", sprintId)
+}
+
+func (r *ownershipRepo) StoryAccount(ctx context.Context, storyId string) (string, error) {
+	return r.resolve(ctx,
+		"select p.account_id from story st "+
+			"join sprint s on s.id = st.sprint_id "+
+			"join project p on p.id = s.project_id "+
+			"where st.id = ?", storyId)
+}
+
+// AccountMember returns sql.ErrNoRows when the user is not a member of the account
+func (r *ownershipRepo) AccountMember(ctx context.Context, accountId, userId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select 1 from account_member where account_id = ? and user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var member int
+	err = stmt.QueryRowContext(ctx, accountId, userId).Scan(&member)
+
+	return
+}
+
+func (r *ownershipRepo) resolve(ctx context.Context, query, id string) (accountId string, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, query)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, id).Scan(&accountId)
+
+	return
+}
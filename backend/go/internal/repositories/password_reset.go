@@ -0,0 +1,108 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+type PasswordResetRepo interface {
+	Create(ctx context.Context, userId, tokenHash string, expiresAt int64) (PasswordReset, error)
+	FindByTokenHash(ctx context.Context, tokenHash string) (PasswordReset, error)
+	Consume(ctx context.Context, reset PasswordReset, tx *sql.Tx) error
+}
+
+// PasswordReset is a single-use token that lets a user choose a new password.
+// Only the hash of the token is stored.
+type PasswordReset struct {
+	Id        string
+	UserId    string
+	TokenHash string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+type passwordResetRepo struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepo(db *sql.DB) PasswordResetRepo {
+	return &passwordResetRepo{
+		db: db,
+	}
+}
+
+func (r *passwordResetRepo) Create(ctx context.Context, userId, tokenHash string, expiresAt int64) (reset PasswordReset, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert into password_reset(id, user_id, token_hash, created_at, expires_at) values(?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	reset = PasswordReset{
+		Id:        uuid.New().String(),
+		UserId:    userId,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now().Unix(),
+		ExpiresAt: expiresAt,
+	}
+	_, err = stmt.ExecContext(ctx, reset.Id, reset.UserId, reset.TokenHash, reset.CreatedAt, reset.ExpiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return PasswordReset{}, err
+	}
+
+	return
+}
+
+func (r *passwordResetRepo) FindByTokenHash(ctx context.Context, tokenHash string) (reset PasswordReset, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select id, user_id, created_at, expires_at, used_at from password_reset where token_hash = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var usedAt sql.NullInt64
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&reset.Id, &reset.UserId, &reset.CreatedAt, &reset.ExpiresAt, &usedAt)
+	if err != nil {
+		return
+	}
+
+	reset.TokenHash = tokenHash
+	reset.UsedAt = usedAt.Int64
+
+	return
+}
+
+// Consume marks the reset as used together with any other open resets of the
+// same user. It returns sql.ErrNoRows when the reset was used in the meantime.
+func (r *passwordResetRepo) Consume(ctx context.Context, reset PasswordReset, tx *sql.Tx) (err error) {
+
+	now := time.Now().Unix()
+	res, err := tracing.Exec(ctx, tx, "update password_reset set used_at = ? where id = ? and used_at is null", now, reset.Id)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tracing.Exec(ctx, tx, "update password_reset set used_at = ? where user_id = ? and used_at is null", now, reset.UserId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
@@ -1,8 +1,13 @@
 package repositories
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"cerberus-examples/internal/metrics"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
 
 func encryptPassword(password string) (string, error) {
+	defer metrics.ObservePassword(metrics.PasswordHash, time.Now())
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
@@ -11,6 +16,7 @@ func encryptPassword(password string) (string, error) {
 }
 
 func verifyPassword(password, hash string) bool {
+	defer metrics.ObservePassword(metrics.PasswordCompare, time.Now())
 	err := bcrypt.CompareHashAndPassword(
 		[]byte(hash),
 		[]byte(password),
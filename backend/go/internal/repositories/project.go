@@ -1,16 +1,17 @@
 package repositories
 
 import (
+	"cerberus-examples/internal/tracing"
+	"context"
 	"database/sql"
 	"github.com/google/uuid"
-	"log"
 )
 
 type ProjectRepo interface {
-	Create(accountId, name, description string, tx *sql.Tx) (Project, error)
-	FindByAccount(accountId string) ([]Project, error)
-	Get(projectId string) (Project, error)
-	Delete(projectId string) error
+	Create(ctx context.Context, accountId, name, description string, tx *sql.Tx) (Project, error)
+	FindByAccount(ctx context.Context, accountId string) ([]Project, error)
+	Get(ctx context.Context, projectId string) (Project, error)
+	Delete(ctx context.Context, projectId string) error
 }
 
 type Project struct {
@@ -30,44 +31,44 @@ func NewProjectRepo(db *sql.DB) ProjectRepo {
 	}
 }
 
-func (r *projectRepo) Create(accountId, name, description string, tx *sql.Tx) (project Project, err error) {
+func (r *projectRepo) Create(ctx context.Context, accountId, name, description string, tx *sql.Tx) (project Project, err error) {
 
 	if tx != nil {
-		return r.create(accountId, name, description, tx)
+		return r.create(ctx, accountId, name, description, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	project, err = r.create(accountId, name, description, tx)
+	project, err = r.create(ctx, accountId, name, description, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *projectRepo) create(accountId, name, description string, tx *sql.Tx) (project Project, err error) {
-	stmt, err := tx.Prepare("insert into project(id, account_id, name, description) values(?, ?, ?, ?)")
+func (r *projectRepo) create(ctx context.Context, accountId, name, description string, tx *sql.Tx) (project Project, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into project(id, account_id, name, description) values(?, ?, ?, ?)")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	id := uuid.New().String()
-	_, err = stmt.Exec(id, accountId, name, description)
+	_, err = stmt.ExecContext(ctx, id, accountId, name, description)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -80,15 +81,15 @@ func (r *projectRepo) create(accountId, name, description string, tx *sql.Tx) (p
 	return
 }
 
-func (r *projectRepo) FindByAccount(accountId string) (projects []Project, err error) {
+func (r *projectRepo) FindByAccount(ctx context.Context, accountId string) (projects []Project, err error) {
 
-	stmt, err := r.db.Prepare("select id, name, description from project where account_id = ? order by name asc")
+	stmt, err := tracing.Prepare(ctx, r.db, "select id, name, description from project where account_id = ? order by name asc")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(accountId)
+	rows, err := stmt.QueryContext(ctx, accountId)
 	if err != nil {
 		return
 	}
@@ -111,16 +112,16 @@ func (r *projectRepo) FindByAccount(accountId string) (projects []Project, err e
 	return
 }
 
-func (r *projectRepo) Get(projectId string) (project Project, err error) {
+func (r *projectRepo) Get(ctx context.Context, projectId string) (project Project, err error) {
 
-	stmt, err := r.db.Prepare("select account_id, name, description from project where id = ?")
+	stmt, err := tracing.Prepare(ctx, r.db, "select account_id, name, description from project where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	var name, description, accountId string
-	err = stmt.QueryRow(projectId).Scan(&accountId, &name, &description)
+	err = stmt.QueryRowContext(ctx, projectId).Scan(&accountId, &name, &description)
 	if err != nil {
 		return
 	}
@@ -135,15 +136,15 @@ func (r *projectRepo) Get(projectId string) (project Project, err error) {
 	return
 }
 
-func (r *projectRepo) Delete(projectId string) (err error) {
+func (r *projectRepo) Delete(ctx context.Context, projectId string) (err error) {
 
-	stmt, err := r.db.Prepare("delete from project where id = ?")
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from project where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(projectId)
+	_, err = stmt.ExecContext(ctx, projectId)
 
 	return
 }
@@ -0,0 +1,196 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type ProjectMemberRepo interface {
+	Add(ctx context.Context, projectId, userId, roleId string, tx *sql.Tx) (ProjectMember, error)
+	FindByProject(ctx context.Context, projectId string) ([]ProjectMember, error)
+	Get(ctx context.Context, projectId, userId string) (ProjectMember, error)
+	ChangeRole(ctx context.Context, projectId, userId, roleId string) error
+	Remove(ctx context.Context, projectId, userId string) error
+	CountByRole(ctx context.Context, projectId, roleId string) (int, error)
+}
+
+type ProjectMember struct {
+	ProjectId string `json:"projectId"`
+	UserId    string `json:"userId"`
+	RoleId    string `json:"roleId"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+type projectMemberRepo struct {
+	db *sql.DB
+}
+
+func NewProjectMemberRepo(db *sql.DB) ProjectMemberRepo {
+	return &projectMemberRepo{
+		db: db,
+	}
+}
+
+func (r *projectMemberRepo) Add(ctx context.Context, projectId, userId, roleId string, tx *sql.Tx) (member ProjectMember, err error) {
+
+	if tx != nil {
+		return r.add(ctx, projectId, userId, roleId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	member, err = r.add(ctx, projectId, userId, roleId, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *projectMemberRepo) add(ctx context.Context, projectId, userId, roleId string, tx *sql.Tx) (member ProjectMember, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into project_member(project_id, user_id, role_id, created_at) values(?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	createdAt := time.Now().Unix()
+	_, err = stmt.ExecContext(ctx, projectId, userId, roleId, createdAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	member = ProjectMember{
+		ProjectId: projectId,
+		UserId:    userId,
+		RoleId:    roleId,
+		CreatedAt: createdAt,
+	}
+	return
+}
+
+func (r *projectMemberRepo) FindByProject(ctx context.Context, projectId string) (members []ProjectMember, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select m.user_id, m.role_id, m.created_at, u.name, u.email from project_member m "+
+			"join user u on u.id = m.user_id "+
+			"where m.project_id = ? order by u.name asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, projectId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userId, roleId, name, email string
+		var createdAt int64
+		err = rows.Scan(&userId, &roleId, &createdAt, &name, &email)
+		if err != nil {
+			return
+		}
+
+		members = append(members, ProjectMember{
+			ProjectId: projectId,
+			UserId:    userId,
+			RoleId:    roleId,
+			Name:      name,
+			Email:     email,
+			CreatedAt: createdAt,
+		})
+	}
+
+	return
+}
+
+func (r *projectMemberRepo) Get(ctx context.Context, projectId, userId string) (member ProjectMember, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select m.role_id, m.created_at, u.name, u.email from project_member m "+
+			"join user u on u.id = m.user_id "+
+			"where m.project_id = ? and m.user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var roleId, name, email string
+	var createdAt int64
+	err = stmt.QueryRowContext(ctx, projectId, userId).Scan(&roleId, &createdAt, &name, &email)
+	if err != nil {
+		return
+	}
+
+	member = ProjectMember{
+		ProjectId: projectId,
+		UserId:    userId,
+		RoleId:    roleId,
+		Name:      name,
+		Email:     email,
+		CreatedAt: createdAt,
+	}
+
+	return
+}
+
+func (r *projectMemberRepo) ChangeRole(ctx context.Context, projectId, userId, roleId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update project_member set role_id = ? where project_id = ? and user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, roleId, projectId, userId)
+
+	return
+}
+
+func (r *projectMemberRepo) Remove(ctx context.Context, projectId, userId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from project_member where project_id = ? and user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, projectId, userId)
+
+	return
+}
+
+func (r *projectMemberRepo) CountByRole(ctx context.Context, projectId, roleId string) (count int, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select count(*) from project_member where project_id = ? and role_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, projectId, roleId).Scan(&count)
+
+	return
+}
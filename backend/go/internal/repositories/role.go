@@ -0,0 +1,268 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"strings"
+)
+
+type RoleRepo interface {
+	Create(ctx context.Context, accountId, name string, permissions []string, tx *sql.Tx) (Role, error)
+	FindByAccount(ctx context.Context, accountId string) ([]Role, error)
+	FindAll(ctx context.Context) ([]Role, error)
+	Get(ctx context.Context, roleId string) (Role, error)
+	Rename(ctx context.Context, roleId, name string) error
+	Delete(ctx context.Context, roleId string) error
+	CountUsers(ctx context.Context, roleId string) (int, error)
+}
+
+const (
+	RoleScopeAccount = "account"
+	RoleScopeProject = "project"
+)
+
+type Role struct {
+	Id          string   `json:"id"`
+	AccountId   string   `json:"accountId"`
+	Name        string   `json:"name"`
+	BuiltIn     bool     `json:"builtIn"`
+	Scope       string   `json:"scope"`
+	Permissions []string `json:"permissions"`
+}
+
+type roleRepo struct {
+	db *sql.DB
+}
+
+func NewRoleRepo(db *sql.DB) RoleRepo {
+	return &roleRepo{
+		db: db,
+	}
+}
+
+func (r *roleRepo) Create(ctx context.Context, accountId, name string, permissions []string, tx *sql.Tx) (role Role, err error) {
+
+	if tx != nil {
+		return r.create(ctx, accountId, name, permissions, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	role, err = r.create(ctx, accountId, name, permissions, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *roleRepo) create(ctx context.Context, accountId, name string, permissions []string, tx *sql.Tx) (role Role, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into role(id, account_id, name, built_in, scope) values(?, ?, ?, 0, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	id := uuid.New().String()
+	_, err = stmt.ExecContext(ctx, id, accountId, name, RoleScopeAccount)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	permStmt, err := tracing.Prepare(ctx, tx, "insert into role_permission(role_id, permission) values(?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer permStmt.Close()
+	for _, permission := range permissions {
+		_, err = permStmt.ExecContext(ctx, id, permission)
+		if err != nil {
+			logError(ctx, err)
+			return
+		}
+	}
+
+	role = Role{
+		Id:          id,
+		AccountId:   accountId,
+		Name:        name,
+		BuiltIn:     false,
+		Scope:       RoleScopeAccount,
+		Permissions: permissions,
+	}
+	return
+}
+
+// FindByAccount returns the built-in account roles together with the roles defined by the account
+func (r *roleRepo) FindByAccount(ctx context.Context, accountId string) (roles []Role, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select r.id, r.account_id, r.name, r.built_in, r.scope, group_concat(rp.permission) from role r "+
+			"left join role_permission rp on rp.role_id = r.id "+
+			"where (r.account_id is null or r.account_id = ?) and r.scope = 'account' "+
+			"group by r.id order by r.built_in desc, r.name asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, accountId)
+	if err != nil {
+		return
+	}
+
+	return scanRoles(rows)
+}
+
+// FindAll returns the built-in roles and the roles of all accounts
+func (r *roleRepo) FindAll(ctx context.Context) (roles []Role, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select r.id, r.account_id, r.name, r.built_in, r.scope, group_concat(rp.permission) from role r "+
+			"left join role_permission rp on rp.role_id = r.id "+
+			"group by r.id order by r.built_in desc, r.name asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return
+	}
+
+	return scanRoles(rows)
+}
+
+func scanRoles(rows *tracing.Rows) (roles []Role, err error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, name, scope string
+		var roleAccountId, permissions sql.NullString
+		var builtIn bool
+		err = rows.Scan(&id, &roleAccountId, &name, &builtIn, &scope, &permissions)
+		if err != nil {
+			return
+		}
+
+		roles = append(roles, Role{
+			Id:          id,
+			AccountId:   roleAccountId.String,
+			Name:        name,
+			BuiltIn:     builtIn,
+			Scope:       scope,
+			Permissions: splitPermissions(permissions),
+		})
+	}
+
+	return
+}
+
+func (r *roleRepo) Get(ctx context.Context, roleId string) (role Role, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select r.account_id, r.name, r.built_in, r.scope, group_concat(rp.permission) from role r "+
+			"left join role_permission rp on rp.role_id = r.id "+
+			"where r.id = ? group by r.id")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var name, scope string
+	var accountId, permissions sql.NullString
+	var builtIn bool
+	err = stmt.QueryRowContext(ctx, roleId).Scan(&accountId, &name, &builtIn, &scope, &permissions)
+	if err != nil {
+		return
+	}
+
+	role = Role{
+		Id:          roleId,
+		AccountId:   accountId.String,
+		Name:        name,
+		BuiltIn:     builtIn,
+		Scope:       scope,
+		Permissions: splitPermissions(permissions),
+	}
+
+	return
+}
+
+// Rename renames an account role. Built-in roles keep their names.
+func (r *roleRepo) Rename(ctx context.Context, roleId, name string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update role set name = ? where id = ? and built_in = 0")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, name, roleId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (r *roleRepo) Delete(ctx context.Context, roleId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "delete from role where id = ? and built_in = 0")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, roleId)
+
+	return
+}
+
+func (r *roleRepo) CountUsers(ctx context.Context, roleId string) (count int, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select count(*) from account_member where role_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, roleId).Scan(&count)
+
+	return
+}
+
+func splitPermissions(permissions sql.NullString) []string {
+	if !permissions.Valid || permissions.String == "" {
+		return []string{}
+	}
+	return strings.Split(permissions.String, ",")
+}
@@ -0,0 +1,257 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SessionRepo interface {
+	CreateRefreshToken(ctx context.Context, token RefreshToken, tx *sql.Tx) error
+	FindRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldTokenId string, token RefreshToken) error
+	RevokeFamily(ctx context.Context, familyId string) error
+	RevokeUser(ctx context.Context, userId string) error
+	RevokeAccount(ctx context.Context, accountId string) error
+	RevokeAccessToken(ctx context.Context, accessTokenId string, expiresAt int64) error
+	IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (bool, error)
+}
+
+// RefreshToken is a server-side session. Rotating a refresh token revokes it
+// and issues a successor in the same family, so that reusing a rotated token
+// can be detected and the whole family revoked.
+type RefreshToken struct {
+	Id              string
+	FamilyId        string
+	UserId          string
+	AccountId       string
+	TokenHash       string
+	AccessTokenId   string
+	AccessExpiresAt int64
+	CreatedAt       int64
+	ExpiresAt       int64
+	RevokedAt       int64
+	ReplacedBy      string
+}
+
+type sessionRepo struct {
+	db *sql.DB
+}
+
+func NewSessionRepo(db *sql.DB) SessionRepo {
+	return &sessionRepo{
+		db: db,
+	}
+}
+
+func (r *sessionRepo) CreateRefreshToken(ctx context.Context, token RefreshToken, tx *sql.Tx) (err error) {
+
+	if tx != nil {
+		return r.createRefreshToken(ctx, token, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.createRefreshToken(ctx, token, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *sessionRepo) createRefreshToken(ctx context.Context, token RefreshToken, tx *sql.Tx) (err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into refresh_token(id, family_id, user_id, account_id, token_hash, "+
+		"access_token_id, access_expires_at, created_at, expires_at, revoked_at) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	revokedAt := sql.NullInt64{Int64: token.RevokedAt, Valid: token.RevokedAt != 0}
+	_, err = stmt.ExecContext(ctx, token.Id, token.FamilyId, token.UserId, token.AccountId, token.TokenHash,
+		token.AccessTokenId, token.AccessExpiresAt, token.CreatedAt, token.ExpiresAt, revokedAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *sessionRepo) FindRefreshToken(ctx context.Context, tokenHash string) (token RefreshToken, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select id, family_id, user_id, account_id, access_token_id, access_expires_at, "+
+		"created_at, expires_at, revoked_at, replaced_by from refresh_token where token_hash = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var revokedAt sql.NullInt64
+	var replacedBy sql.NullString
+	err = stmt.QueryRowContext(ctx, tokenHash).Scan(&token.Id, &token.FamilyId, &token.UserId, &token.AccountId,
+		&token.AccessTokenId, &token.AccessExpiresAt, &token.CreatedAt, &token.ExpiresAt, &revokedAt, &replacedBy)
+	if err != nil {
+		return
+	}
+
+	token.TokenHash = tokenHash
+	token.RevokedAt = revokedAt.Int64
+	token.ReplacedBy = replacedBy.String
+
+	return
+}
+
+// RotateRefreshToken revokes the old token in favour of its successor. It
+// returns sql.ErrNoRows when the old token has already been revoked.
+func (r *sessionRepo) RotateRefreshToken(ctx context.Context, oldTokenId string, token RefreshToken) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.rotateRefreshToken(ctx, oldTokenId, token, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *sessionRepo) rotateRefreshToken(ctx context.Context, oldTokenId string, token RefreshToken, tx *sql.Tx) (err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "update refresh_token set revoked_at = ?, replaced_by = ? where id = ? and revoked_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, time.Now().Unix(), token.Id, oldTokenId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return r.createRefreshToken(ctx, token, tx)
+}
+
+// RevokeFamily revokes all refresh tokens of the family together with the
+// access tokens that were issued alongside them
+func (r *sessionRepo) RevokeFamily(ctx context.Context, familyId string) error {
+	return r.revoke(ctx, "family_id = ?", familyId)
+}
+
+// RevokeUser revokes all sessions of the user
+func (r *sessionRepo) RevokeUser(ctx context.Context, userId string) error {
+	return r.revoke(ctx, "user_id = ?", userId)
+}
+
+// RevokeAccount revokes all sessions in the account
+func (r *sessionRepo) RevokeAccount(ctx context.Context, accountId string) error {
+	return r.revoke(ctx, "account_id = ?", accountId)
+}
+
+func (r *sessionRepo) revoke(ctx context.Context, condition string, arg string) (err error) {
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	now := time.Now().Unix()
+	_, err = tracing.Exec(ctx, tx, "insert or ignore into revoked_token(id, expires_at) "+
+		"select access_token_id, access_expires_at from refresh_token "+
+		"where "+condition+" and access_expires_at > ?", arg, now)
+	if err == nil {
+		_, err = tracing.Exec(ctx, tx, "update refresh_token set revoked_at = ? "+
+			"where "+condition+" and revoked_at is null", now, arg)
+	}
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *sessionRepo) RevokeAccessToken(ctx context.Context, accessTokenId string, expiresAt int64) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "insert or ignore into revoked_token(id, expires_at) values(?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, accessTokenId, expiresAt)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	// expired tokens are rejected anyway, so they can leave the denylist
+	_, err = tracing.Exec(ctx, r.db, "delete from revoked_token where expires_at < ?", time.Now().Unix())
+
+	return
+}
+
+func (r *sessionRepo) IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (revoked bool, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select count(*) from revoked_token where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var count int
+	err = stmt.QueryRowContext(ctx, accessTokenId).Scan(&count)
+	if err != nil {
+		return
+	}
+
+	revoked = count > 0
+	return
+}
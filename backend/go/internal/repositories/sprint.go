@@ -1,18 +1,19 @@
 package repositories
 
 import (
+	"cerberus-examples/internal/tracing"
+	"context"
 	"database/sql"
 	"github.com/google/uuid"
-	"log"
 	"time"
 )
 
 type SprintRepo interface {
-	Create(projectId, goal string, tx *sql.Tx) (Sprint, error)
-	FindByProject(projectId string) ([]Sprint, error)
-	Get(sprintId string, tx *sql.Tx) (Sprint, error)
-	Start(sprintId string) (Sprint, error)
-	End(sprintId string) (Sprint, error)
+	Create(ctx context.Context, projectId, goal string, tx *sql.Tx) (Sprint, error)
+	FindByProject(ctx context.Context, projectId string) ([]Sprint, error)
+	Get(ctx context.Context, sprintId string, tx *sql.Tx) (Sprint, error)
+	Start(ctx context.Context, sprintId string) (Sprint, error)
+	End(ctx context.Context, sprintId string) (Sprint, error)
 }
 
 type Sprint struct {
@@ -34,63 +35,63 @@ func NewSprintRepo(db *sql.DB) SprintRepo {
 	}
 }
 
-func (r *sprintRepo) Create(projectId, goal string, tx *sql.Tx) (sprint Sprint, err error) {
+func (r *sprintRepo) Create(ctx context.Context, projectId, goal string, tx *sql.Tx) (sprint Sprint, err error) {
 	if tx != nil {
-		return r.create(projectId, goal, tx)
+		return r.create(ctx, projectId, goal, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	sprint, err = r.create(projectId, goal, tx)
+	sprint, err = r.create(ctx, projectId, goal, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *sprintRepo) create(projectId, goal string, tx *sql.Tx) (sprint Sprint, err error) {
-	stmt, err := tx.Prepare("insert into sprint(id, project_id, sprint_number, goal, start_date, end_date)" +
-		" values(?, ?, " +
-		"(SELECT COUNT(*) + 1 FROM sprint WHERE project_id = ?), " +
+func (r *sprintRepo) create(ctx context.Context, projectId, goal string, tx *sql.Tx) (sprint Sprint, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into sprint(id, project_id, sprint_number, goal, start_date, end_date)"+
+		" values(?, ?, "+
+		"(SELECT COUNT(*) + 1 FROM sprint WHERE project_id = ?), "+
 		"?, 0, 0)")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	id := uuid.New().String()
-	_, err = stmt.Exec(id, projectId, projectId, goal)
+	_, err = stmt.ExecContext(ctx, id, projectId, projectId, goal)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	return r.Get(id, tx)
+	return r.Get(ctx, id, tx)
 }
 
-func (r *sprintRepo) FindByProject(projectId string) (sprints []Sprint, err error) {
+func (r *sprintRepo) FindByProject(ctx context.Context, projectId string) (sprints []Sprint, err error) {
 
-	stmt, err := r.db.Prepare(
-		"select id, sprint_number, goal, start_date, end_date from sprint " +
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select id, sprint_number, goal, start_date, end_date from sprint "+
 			"where project_id = ? order by sprint_number asc")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(projectId)
+	rows, err := stmt.QueryContext(ctx, projectId)
 	if err != nil {
 		return
 	}
@@ -117,44 +118,44 @@ func (r *sprintRepo) FindByProject(projectId string) (sprints []Sprint, err erro
 	return
 }
 
-func (r *sprintRepo) Get(sprintId string, tx *sql.Tx) (sprint Sprint, err error) {
+func (r *sprintRepo) Get(ctx context.Context, sprintId string, tx *sql.Tx) (sprint Sprint, err error) {
 
 	if tx != nil {
-		return r.get(sprintId, tx)
+		return r.get(ctx, sprintId, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	sprint, err = r.get(sprintId, tx)
+	sprint, err = r.get(ctx, sprintId, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *sprintRepo) get(sprintId string, tx *sql.Tx) (sprint Sprint, err error) {
-	stmt, err := tx.Prepare("select project_id, sprint_number, goal, start_date, end_date from sprint where id = ?")
+func (r *sprintRepo) get(ctx context.Context, sprintId string, tx *sql.Tx) (sprint Sprint, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "select project_id, sprint_number, goal, start_date, end_date from sprint where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	var goal, projectId string
 	var sprintNumber int
 	var startDate, endDate int64
-	err = stmt.QueryRow(sprintId).Scan(&projectId, &sprintNumber, &goal, &startDate, &endDate)
+	err = stmt.QueryRowContext(ctx, sprintId).Scan(&projectId, &sprintNumber, &goal, &startDate, &endDate)
 	if err != nil {
 		return
 	}
@@ -170,28 +171,28 @@ func (r *sprintRepo) get(sprintId string, tx *sql.Tx) (sprint Sprint, err error)
 	return
 }
 
-func (r *sprintRepo) Start(sprintId string) (sprint Sprint, err error) {
-	tx, err := r.db.Begin()
+func (r *sprintRepo) Start(ctx context.Context, sprintId string) (sprint Sprint, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
-	stmt, err := tx.Prepare("update sprint set start_date = ? where id = ?")
+	stmt, err := tracing.Prepare(ctx, tx, "update sprint set start_date = ? where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	startDate := time.Now().Unix()
-	_, err = stmt.Exec(startDate, sprintId)
+	_, err = stmt.ExecContext(ctx, startDate, sprintId)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -203,28 +204,28 @@ func (r *sprintRepo) Start(sprintId string) (sprint Sprint, err error) {
 	return
 }
 
-func (r *sprintRepo) End(sprintId string) (sprint Sprint, err error) {
-	tx, err := r.db.Begin()
+func (r *sprintRepo) End(ctx context.Context, sprintId string) (sprint Sprint, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
-	stmt, err := tx.Prepare("update sprint set end_date = ? where id = ?")
+	stmt, err := tracing.Prepare(ctx, tx, "update sprint set end_date = ? where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	endDate := time.Now().Unix()
-	_, err = stmt.Exec(endDate, sprintId)
+	_, err = stmt.ExecContext(ctx, endDate, sprintId)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -1,18 +1,20 @@
 package repositories
 
 import (
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/tracing"
+	"context"
 	"database/sql"
 	"github.com/google/uuid"
-	"log"
 )
 
 type StoryRepo interface {
-	Create(sprintId, description string, tx *sql.Tx) (Story, error)
-	FindBySprint(sprintId string) ([]Story, error)
-	Get(storyId string, tx *sql.Tx) (Story, error)
-	Estimate(storyId string, estimate int) (Story, error)
-	ChangeStatus(storyId, status string) (Story, error)
-	Assign(storyId, userId string) (Story, error)
+	Create(ctx context.Context, sprintId, description, status string, tx *sql.Tx) (Story, error)
+	FindBySprint(ctx context.Context, sprintId string) ([]Story, error)
+	Get(ctx context.Context, storyId string, tx *sql.Tx) (Story, error)
+	Estimate(ctx context.Context, storyId string, estimate int) (Story, error)
+	ChangeStatus(ctx context.Context, storyId, from, status string) (Story, error)
+	Assign(ctx context.Context, storyId, userId string) (Story, error)
 }
 
 type Story struct {
@@ -34,45 +36,45 @@ func NewStoryRepo(db *sql.DB) StoryRepo {
 	}
 }
 
-func (r *storyRepo) Create(sprintId, description string, tx *sql.Tx) (story Story, err error) {
+func (r *storyRepo) Create(ctx context.Context, sprintId, description, status string, tx *sql.Tx) (story Story, err error) {
 
 	if tx != nil {
-		return r.create(sprintId, description, tx)
+		return r.create(ctx, sprintId, description, status, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	story, err = r.create(sprintId, description, tx)
+	story, err = r.create(ctx, sprintId, description, status, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *storyRepo) create(sprintId, description string, tx *sql.Tx) (story Story, err error) {
-	stmt, err := tx.Prepare("insert into story(id, sprint_id, estimation, description, status)" +
-		" values(?, ?, 0, ?, 'todo')")
+func (r *storyRepo) create(ctx context.Context, sprintId, description, status string, tx *sql.Tx) (story Story, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "insert into story(id, sprint_id, estimation, description, status)"+
+		" values(?, ?, 0, ?, ?)")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	id := uuid.New().String()
-	_, err = stmt.Exec(id, sprintId, description)
+	_, err = stmt.ExecContext(ctx, id, sprintId, description, status)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -81,23 +83,23 @@ func (r *storyRepo) create(sprintId, description string, tx *sql.Tx) (story Stor
 		SprintId:    sprintId,
 		Description: description,
 		Estimation:  0,
-		Status:      "todo",
+		Status:      status,
 	}
 
 	return
 }
 
-func (r *storyRepo) FindBySprint(sprintId string) (stories []Story, err error) {
+func (r *storyRepo) FindBySprint(ctx context.Context, sprintId string) (stories []Story, err error) {
 
-	stmt, err := r.db.Prepare(
-		"select id, estimation, description, status, user_id from story " +
+	stmt, err := tracing.Prepare(ctx, r.db,
+		"select id, estimation, description, status, user_id from story "+
 			"where sprint_id = ? order by description asc")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(sprintId)
+	rows, err := stmt.QueryContext(ctx, sprintId)
 	if err != nil {
 		return
 	}
@@ -124,43 +126,43 @@ func (r *storyRepo) FindBySprint(sprintId string) (stories []Story, err error) {
 	return
 }
 
-func (r *storyRepo) Get(storyId string, tx *sql.Tx) (story Story, err error) {
+func (r *storyRepo) Get(ctx context.Context, storyId string, tx *sql.Tx) (story Story, err error) {
 	if tx != nil {
-		return r.get(storyId, tx)
+		return r.get(ctx, storyId, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	story, err = r.get(storyId, tx)
+	story, err = r.get(ctx, storyId, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *storyRepo) get(storyId string, tx *sql.Tx) (story Story, err error) {
-	stmt, err := tx.Prepare("select sprint_id, estimation, description, status, user_id from story where id = ?")
+func (r *storyRepo) get(ctx context.Context, storyId string, tx *sql.Tx) (story Story, err error) {
+	stmt, err := tracing.Prepare(ctx, tx, "select sprint_id, estimation, description, status, user_id from story where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	var sprintId, description, status string
 	var userId sql.NullString
 	var estimation int
-	err = stmt.QueryRow(storyId).Scan(&sprintId, &estimation, &description, &status, &userId)
+	err = stmt.QueryRowContext(ctx, storyId).Scan(&sprintId, &estimation, &description, &status, &userId)
 	if err != nil {
 		return
 	}
@@ -177,27 +179,27 @@ func (r *storyRepo) get(storyId string, tx *sql.Tx) (story Story, err error) {
 	return
 }
 
-func (r *storyRepo) Estimate(storyId string, estimation int) (story Story, err error) {
-	tx, err := r.db.Begin()
+func (r *storyRepo) Estimate(ctx context.Context, storyId string, estimation int) (story Story, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
-	stmt, err := tx.Prepare("update story set estimation = ? where id = ?")
+	stmt, err := tracing.Prepare(ctx, tx, "update story set estimation = ? where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(estimation, storyId)
+	_, err = stmt.ExecContext(ctx, estimation, storyId)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -209,28 +211,29 @@ func (r *storyRepo) Estimate(storyId string, estimation int) (story Story, err e
 	return
 }
 
-func (r *storyRepo) ChangeStatus(storyId, status string) (story Story, err error) {
-	log.Println("ChangeStatus", storyId, status)
-	tx, err := r.db.Begin()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	stmt, err := tx.Prepare("update story set status = ? where id = ?")
+// ChangeStatus moves the story from one status to another. It fails with
+// sql.ErrNoRows when the story is no longer in the status it moves from, as
+// another request moved it in the meantime.
+func (r *storyRepo) ChangeStatus(ctx context.Context, storyId, from, status string) (story Story, err error) {
+	logging.FromContext(ctx).Debug("change status", "storyId", storyId, "from", from, "status", status)
+	stmt, err := tracing.Prepare(ctx, r.db, "update story set status = ? where id = ? and status = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(status, storyId)
+	res, err := stmt.ExecContext(ctx, status, storyId, from)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	err = tx.Commit()
+	affected, err := res.RowsAffected()
 	if err != nil {
-		log.Println(err)
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
 		return
 	}
 
@@ -242,27 +245,29 @@ func (r *storyRepo) ChangeStatus(storyId, status string) (story Story, err error
 	return
 }
 
-func (r *storyRepo) Assign(storyId, userId string) (story Story, err error) {
-	tx, err := r.db.Begin()
+func (r *storyRepo) Assign(ctx context.Context, storyId, userId string) (story Story, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
-	stmt, err := tx.Prepare("update story set user_id = ? where id = ?")
+	stmt, err := tracing.Prepare(ctx, tx, "update story set user_id = ? where id = ?")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(userId, storyId)
+	// An empty user unassigns the story
+	assignee := sql.NullString{String: userId, Valid: userId != ""}
+	_, err = stmt.ExecContext(ctx, assignee, storyId)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
@@ -1,25 +1,49 @@
 package repositories
 
 import (
+	"cerberus-examples/internal/tracing"
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"strings"
+	"time"
 )
 
 type UserRepo interface {
-	Save(accountId, email, plainPassword, name string, tx *sql.Tx) (User, error)
-	FindOneByEmailAndPassword(email string, password string) (User, error)
-	FindOneByEmail(email string) (User, error)
-	FindAll(accountId string) ([]User, error)
+	Save(ctx context.Context, accountId, email, plainPassword, name, roleId string, tx *sql.Tx) (User, error)
+	FindOneByEmailAndPassword(ctx context.Context, email string, password string) (User, error)
+	FindOneByEmail(ctx context.Context, email string) (User, error)
+	Get(ctx context.Context, userId string) (User, error)
+	GetMember(ctx context.Context, accountId, userId string) (User, error)
+	FindAll(ctx context.Context, accountId string) ([]User, error)
+	AddMember(ctx context.Context, accountId, userId, roleId string, tx *sql.Tx) error
+	SetRole(ctx context.Context, accountId, userId, roleId string) error
+	SetPassword(ctx context.Context, userId, plainPassword string, tx *sql.Tx) error
+	MarkEmailVerified(ctx context.Context, userId string, tx *sql.Tx) error
+	MarkEmailUnverified(ctx context.Context, userId string, tx *sql.Tx) error
+	Update(ctx context.Context, userId, email, name, externalId string, tx *sql.Tx) error
+	SetDeactivated(ctx context.Context, userId string, deactivated bool) error
 }
 
+// User is a user in one of its accounts, with its role in that account.
+// HomeAccountId is the account that created the user and manages its profile.
 type User struct {
-	Token     string `json:"token"`
-	Id        string `json:"id"`
-	AccountId string `json:"accountId"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
+	Token         string `json:"token"`
+	RefreshToken  string `json:"refreshToken,omitempty"`
+	Id            string `json:"id"`
+	AccountId     string `json:"accountId"`
+	HomeAccountId string `json:"homeAccountId"`
+	Name          string `json:"name"`
+	Email         string `json:"email"`
+	RoleId        string `json:"roleId"`
+	EmailVerified bool   `json:"emailVerified"`
+	ExternalId    string `json:"externalId,omitempty"`
+	DeactivatedAt int64  `json:"deactivatedAt,omitempty"`
+	// Set instead of the tokens when the login needs a second factor
+	MfaRequired           bool   `json:"mfaRequired,omitempty"`
+	MfaEnrollmentRequired bool   `json:"mfaEnrollmentRequired,omitempty"`
+	MfaToken              string `json:"mfaToken,omitempty"`
 }
 
 type userRepo struct {
@@ -32,73 +56,87 @@ func NewUserRepo(db *sql.DB) UserRepo {
 	}
 }
 
-func (r *userRepo) Save(accountId, email, plainPassword, name string, tx *sql.Tx) (user User, err error) {
+func (r *userRepo) Save(ctx context.Context, accountId, email, plainPassword, name, roleId string, tx *sql.Tx) (user User, err error) {
 
 	encryptedPassword, err := encryptPassword(plainPassword)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	if tx != nil {
-		return r.save(accountId, email, encryptedPassword, name, tx)
+		return r.save(ctx, accountId, email, encryptedPassword, name, roleId, tx)
 	}
 
-	tx, err = r.db.Begin()
+	tx, err = r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
-	user, err = r.save(accountId, email, encryptedPassword, name, tx)
+	user, err = r.save(ctx, accountId, email, encryptedPassword, name, roleId, tx)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 
 	return
 }
 
-func (r *userRepo) save(accountId, email, encryptedPassword, name string, tx *sql.Tx) (user User, err error) {
+func (r *userRepo) save(ctx context.Context, accountId, email, encryptedPassword, name, roleId string, tx *sql.Tx) (user User, err error) {
 
-	stmt, err := tx.Prepare("insert into user(id, account_id, email, password, name) values(?, ?, ?, ?, ?)")
+	stmt, err := tracing.Prepare(ctx, tx, "insert into user(id, account_id, email, password, name) values(?, ?, ?, ?, ?)")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	id := uuid.New().String()
-	_, err = stmt.Exec(id, accountId, email, encryptedPassword, name)
+	email = NormalizeEmail(email)
+	_, err = stmt.ExecContext(ctx, id, accountId, email, encryptedPassword, name)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
+		return
+	}
+
+	if err = r.addMember(ctx, accountId, id, roleId, tx); err != nil {
 		return
 	}
 
 	user = User{
-		Id:        id,
-		AccountId: accountId,
-		Name:      name,
-		Email:     email,
+		Id:            id,
+		AccountId:     accountId,
+		HomeAccountId: accountId,
+		Name:          name,
+		Email:         email,
+		RoleId:        roleId,
 	}
 	return
 }
 
-func (r *userRepo) FindOneByEmailAndPassword(email string, plainPassword string) (user User, err error) {
+func (r *userRepo) FindOneByEmailAndPassword(ctx context.Context, email string, plainPassword string) (user User, err error) {
 
-	stmt, err := r.db.Prepare("select id, account_id, name, password from user where email = ?")
+	stmt, err := tracing.Prepare(ctx, r.db, "select u.id, u.account_id, u.name, u.password, m.role_id, u.email_verified_at, "+
+		"u.external_id, u.deactivated_at "+
+		"from user u left join account_member m on m.account_id = u.account_id and m.user_id = u.id "+
+		"where u.email = ? collate nocase")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	var id, accountId, name, password string
-	err = stmt.QueryRow(email).Scan(&id, &accountId, &name, &password)
+	var roleId sql.NullString
+	var emailVerifiedAt, deactivatedAt sql.NullInt64
+	var externalId sql.NullString
+	email = NormalizeEmail(email)
+	err = stmt.QueryRowContext(ctx, email).Scan(&id, &accountId, &name, &password, &roleId, &emailVerifiedAt, &externalId, &deactivatedAt)
 	if err != nil {
 		err = fmt.Errorf("account not found or incorrect password")
 		return
@@ -110,67 +148,480 @@ func (r *userRepo) FindOneByEmailAndPassword(email string, plainPassword string)
 	}
 
 	user = User{
-		Id:        id,
-		AccountId: accountId,
-		Name:      name,
-		Email:     email,
+		Id:            id,
+		AccountId:     accountId,
+		HomeAccountId: accountId,
+		Name:          name,
+		Email:         email,
+		RoleId:        roleId.String,
+		EmailVerified: emailVerifiedAt.Valid,
+		ExternalId:    externalId.String,
+		DeactivatedAt: deactivatedAt.Int64,
 	}
 
 	return
 }
 
-func (r *userRepo) FindOneByEmail(email string) (user User, err error) {
+func (r *userRepo) FindOneByEmail(ctx context.Context, email string) (user User, err error) {
 
-	stmt, err := r.db.Prepare("select id, account_id, name, password from user where email = ?")
+	stmt, err := tracing.Prepare(ctx, r.db, "select u.id, u.account_id, u.name, m.role_id, u.email_verified_at, "+
+		"u.external_id, u.deactivated_at "+
+		"from user u left join account_member m on m.account_id = u.account_id and m.user_id = u.id "+
+		"where u.email = ? collate nocase")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
 	var id, accountId, name string
-	err = stmt.QueryRow(email).Scan(&id, &accountId, &name)
+	var roleId sql.NullString
+	var emailVerifiedAt, deactivatedAt sql.NullInt64
+	var externalId sql.NullString
+	email = NormalizeEmail(email)
+	err = stmt.QueryRowContext(ctx, email).Scan(&id, &accountId, &name, &roleId, &emailVerifiedAt, &externalId, &deactivatedAt)
 	if err != nil {
 		err = fmt.Errorf("account not found or incorrect password")
 		return
 	}
 
 	user = User{
-		Id:        id,
-		AccountId: accountId,
-		Name:      name,
-		Email:     email,
+		Id:            id,
+		AccountId:     accountId,
+		HomeAccountId: accountId,
+		Name:          name,
+		Email:         email,
+		RoleId:        roleId.String,
+		EmailVerified: emailVerifiedAt.Valid,
+		ExternalId:    externalId.String,
+		DeactivatedAt: deactivatedAt.Int64,
+	}
+
+	return
+}
+
+func (r *userRepo) Get(ctx context.Context, userId string) (user User, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select u.account_id, u.name, u.email, m.role_id, u.email_verified_at, "+
+		"u.external_id, u.deactivated_at "+
+		"from user u left join account_member m on m.account_id = u.account_id and m.user_id = u.id "+
+		"where u.id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var accountId, name, email string
+	var roleId sql.NullString
+	var emailVerifiedAt, deactivatedAt sql.NullInt64
+	var externalId sql.NullString
+	err = stmt.QueryRowContext(ctx, userId).Scan(&accountId, &name, &email, &roleId, &emailVerifiedAt, &externalId, &deactivatedAt)
+	if err != nil {
+		return
+	}
+
+	user = User{
+		Id:            userId,
+		AccountId:     accountId,
+		HomeAccountId: accountId,
+		Name:          name,
+		Email:         email,
+		RoleId:        roleId.String,
+		EmailVerified: emailVerifiedAt.Valid,
+		ExternalId:    externalId.String,
+		DeactivatedAt: deactivatedAt.Int64,
+	}
+
+	return
+}
+
+// GetMember returns the user as a member of the account, or sql.ErrNoRows
+// when the user is not a member of it
+func (r *userRepo) GetMember(ctx context.Context, accountId, userId string) (user User, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select u.account_id, u.name, u.email, m.role_id, u.email_verified_at, "+
+		"u.external_id, u.deactivated_at "+
+		"from account_member m join user u on u.id = m.user_id "+
+		"where m.account_id = ? and m.user_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var homeAccountId, name, email, roleId string
+	var emailVerifiedAt, deactivatedAt sql.NullInt64
+	var externalId sql.NullString
+	err = stmt.QueryRowContext(ctx, accountId, userId).Scan(&homeAccountId, &name, &email, &roleId, &emailVerifiedAt,
+		&externalId, &deactivatedAt)
+	if err != nil {
+		return
+	}
+
+	user = User{
+		Id:            userId,
+		AccountId:     accountId,
+		HomeAccountId: homeAccountId,
+		Name:          name,
+		Email:         email,
+		RoleId:        roleId,
+		EmailVerified: emailVerifiedAt.Valid,
+		ExternalId:    externalId.String,
+		DeactivatedAt: deactivatedAt.Int64,
 	}
 
 	return
 }
 
-func (r *userRepo) FindAll(accountId string) (users []User, err error) {
+func (r *userRepo) FindAll(ctx context.Context, accountId string) (users []User, err error) {
 
-	stmt, err := r.db.Prepare("select id, name, email from user where account_id = ? order by name asc")
+	stmt, err := tracing.Prepare(ctx, r.db, "select u.id, u.account_id, u.name, u.email, m.role_id, u.email_verified_at, "+
+		"u.external_id, u.deactivated_at "+
+		"from account_member m join user u on u.id = m.user_id "+
+		"where m.account_id = ? order by u.name asc")
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return
 	}
 	defer stmt.Close()
-	rows, err := stmt.Query(accountId)
+	rows, err := stmt.QueryContext(ctx, accountId)
 	if err != nil {
 		return
 	}
 
 	for rows.Next() {
-		var id, name, email string
-		err = rows.Scan(&id, &name, &email)
+		var id, homeAccountId, name, email string
+		var roleId sql.NullString
+		var emailVerifiedAt, deactivatedAt sql.NullInt64
+		var externalId sql.NullString
+		err = rows.Scan(&id, &homeAccountId, &name, &email, &roleId, &emailVerifiedAt, &externalId, &deactivatedAt)
 		if err != nil {
 			return
 		}
 
 		users = append(users, User{
-			Id:        id,
-			AccountId: accountId,
-			Name:      name,
-			Email:     email,
+			Id:            id,
+			AccountId:     accountId,
+			HomeAccountId: homeAccountId,
+			Name:          name,
+			Email:         email,
+			RoleId:        roleId.String,
+			EmailVerified: emailVerifiedAt.Valid,
+			ExternalId:    externalId.String,
+			DeactivatedAt: deactivatedAt.Int64,
 		})
 	}
 
 	return
 }
+
+// AddMember adds an existing user to another account with a role in it
+func (r *userRepo) AddMember(ctx context.Context, accountId, userId, roleId string, tx *sql.Tx) (err error) {
+
+	if tx != nil {
+		return r.addMember(ctx, accountId, userId, roleId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.addMember(ctx, accountId, userId, roleId, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) addMember(ctx context.Context, accountId, userId, roleId string, tx *sql.Tx) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "insert into account_member(account_id, user_id, role_id, created_at) values(?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, accountId, userId, roleId, time.Now().Unix())
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) SetRole(ctx context.Context, accountId, userId, roleId string) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update account_member set role_id = ? where user_id = ? and account_id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, roleId, userId, accountId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+func (r *userRepo) SetPassword(ctx context.Context, userId, plainPassword string, tx *sql.Tx) (err error) {
+
+	encryptedPassword, err := encryptPassword(plainPassword)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	if tx != nil {
+		return r.setPassword(ctx, userId, encryptedPassword, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.setPassword(ctx, userId, encryptedPassword, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) setPassword(ctx context.Context, userId, encryptedPassword string, tx *sql.Tx) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "update user set password = ? where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, encryptedPassword, userId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// MarkEmailVerified records that the user proved to own their email address
+func (r *userRepo) MarkEmailVerified(ctx context.Context, userId string, tx *sql.Tx) (err error) {
+
+	if tx != nil {
+		return r.markEmailVerified(ctx, userId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.markEmailVerified(ctx, userId, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) markEmailVerified(ctx context.Context, userId string, tx *sql.Tx) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "update user set email_verified_at = ? where id = ? and email_verified_at is null")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, time.Now().Unix(), userId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// MarkEmailUnverified requires the user to verify a changed email address
+func (r *userRepo) MarkEmailUnverified(ctx context.Context, userId string, tx *sql.Tx) (err error) {
+
+	if tx != nil {
+		return r.markEmailUnverified(ctx, userId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.markEmailUnverified(ctx, userId, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) markEmailUnverified(ctx context.Context, userId string, tx *sql.Tx) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "update user set email_verified_at = null where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, userId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// Update changes the email address, name and external id of a user, as
+// managed by an identity provider
+func (r *userRepo) Update(ctx context.Context, userId, email, name, externalId string, tx *sql.Tx) (err error) {
+
+	if tx != nil {
+		return r.update(ctx, userId, email, name, externalId, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	err = r.update(ctx, userId, email, name, externalId, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *userRepo) update(ctx context.Context, userId, email, name, externalId string, tx *sql.Tx) (err error) {
+
+	stmt, err := tracing.Prepare(ctx, tx, "update user set email = ?, name = ?, external_id = ? where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, NormalizeEmail(email), name, nullString(externalId), userId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		err = sql.ErrNoRows
+	}
+
+	return
+}
+
+// SetDeactivated deactivates a user, who can then no longer log in, or
+// activates the user again
+func (r *userRepo) SetDeactivated(ctx context.Context, userId string, deactivated bool) (err error) {
+
+	var deactivatedAt sql.NullInt64
+	if deactivated {
+		deactivatedAt = sql.NullInt64{Int64: time.Now().Unix(), Valid: true}
+	}
+
+	stmt, err := tracing.Prepare(ctx, r.db, "update user set deactivated_at = ? where id = ? and (deactivated_at is null) = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, deactivatedAt, userId, deactivated)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+// NormalizeEmail returns the form that emails are stored and compared in, as
+// they identify users whatever their case
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
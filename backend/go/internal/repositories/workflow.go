@@ -0,0 +1,251 @@
+package repositories
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+type WorkflowRepo interface {
+	Create(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (Workflow, error)
+	Replace(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (Workflow, error)
+	Get(ctx context.Context, projectId string) (Workflow, error)
+	GetBySprint(ctx context.Context, sprintId string) (Workflow, error)
+}
+
+// Categories of workflow states, which tell whether work on a story is still
+// to do, in progress or done, whatever the states are named
+const (
+	WorkflowCategoryTodo       = "todo"
+	WorkflowCategoryInProgress = "in-progress"
+	WorkflowCategoryDone       = "done"
+)
+
+// Workflow is the states that the stories of a project move through, in the
+// order of the columns of a board, and the transitions allowed between them
+type Workflow struct {
+	ProjectId   string               `json:"projectId"`
+	States      []WorkflowState      `json:"states"`
+	Transitions []WorkflowTransition `json:"transitions"`
+}
+
+type WorkflowState struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Position int    `json:"position"`
+}
+
+// WorkflowTransition allows stories to move between the states with the
+// names
+type WorkflowTransition struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type workflowRepo struct {
+	db *sql.DB
+}
+
+func NewWorkflowRepo(db *sql.DB) WorkflowRepo {
+	return &workflowRepo{
+		db: db,
+	}
+}
+
+// Create stores the workflow of a project. States are positioned in the
+// order given, and transitions refer to them by name.
+func (r *workflowRepo) Create(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (workflow Workflow, err error) {
+
+	if tx != nil {
+		return r.create(ctx, projectId, states, transitions, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	workflow, err = r.create(ctx, projectId, states, transitions, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *workflowRepo) create(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (workflow Workflow, err error) {
+	stateStmt, err := tracing.Prepare(ctx, tx,
+		"insert into workflow_state(id, project_id, name, category, position) values(?, ?, ?, ?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stateStmt.Close()
+
+	ids := make(map[string]string, len(states))
+	workflow = Workflow{ProjectId: projectId, Transitions: transitions}
+	for position, state := range states {
+		state.Id = uuid.New().String()
+		state.Position = position
+		_, err = stateStmt.ExecContext(ctx, state.Id, projectId, state.Name, state.Category, state.Position)
+		if err != nil {
+			logError(ctx, err)
+			return
+		}
+		ids[state.Name] = state.Id
+		workflow.States = append(workflow.States, state)
+	}
+
+	transitionStmt, err := tracing.Prepare(ctx, tx,
+		"insert into workflow_transition(from_state_id, to_state_id) values(?, ?)")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer transitionStmt.Close()
+	for _, transition := range transitions {
+		from, to := ids[transition.From], ids[transition.To]
+		if from == "" || to == "" {
+			err = fmt.Errorf("transition between unknown states %q and %q", transition.From, transition.To)
+			return
+		}
+		_, err = transitionStmt.ExecContext(ctx, from, to)
+		if err != nil {
+			logError(ctx, err)
+			return
+		}
+	}
+
+	return
+}
+
+// Replace replaces the workflow of a project with the states and transitions
+func (r *workflowRepo) Replace(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (workflow Workflow, err error) {
+
+	if tx != nil {
+		return r.replace(ctx, projectId, states, transitions, tx)
+	}
+
+	tx, err = r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	workflow, err = r.replace(ctx, projectId, states, transitions, tx)
+	if err != nil {
+		logError(ctx, err)
+		if rbe := tx.Rollback(); rbe != nil {
+			logError(ctx, rbe)
+		}
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return
+}
+
+func (r *workflowRepo) replace(ctx context.Context, projectId string, states []WorkflowState, transitions []WorkflowTransition, tx *sql.Tx) (workflow Workflow, err error) {
+	// Transitions are deleted with the states they are between
+	_, err = tracing.Exec(ctx, tx, "delete from workflow_state where project_id = ?", projectId)
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+
+	return r.create(ctx, projectId, states, transitions, tx)
+}
+
+func (r *workflowRepo) Get(ctx context.Context, projectId string) (workflow Workflow, err error) {
+
+	stateStmt, err := tracing.Prepare(ctx, r.db,
+		"select id, name, category, position from workflow_state where project_id = ? order by position asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stateStmt.Close()
+	rows, err := stateStmt.QueryContext(ctx, projectId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	workflow = Workflow{ProjectId: projectId}
+	for rows.Next() {
+		var state WorkflowState
+		err = rows.Scan(&state.Id, &state.Name, &state.Category, &state.Position)
+		if err != nil {
+			return
+		}
+		workflow.States = append(workflow.States, state)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	transitionStmt, err := tracing.Prepare(ctx, r.db,
+		"select f.name, t.name from workflow_transition wt "+
+			"join workflow_state f on f.id = wt.from_state_id "+
+			"join workflow_state t on t.id = wt.to_state_id "+
+			"where f.project_id = ? order by f.position asc, t.position asc")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer transitionStmt.Close()
+	transitionRows, err := transitionStmt.QueryContext(ctx, projectId)
+	if err != nil {
+		return
+	}
+	defer transitionRows.Close()
+
+	for transitionRows.Next() {
+		var transition WorkflowTransition
+		err = transitionRows.Scan(&transition.From, &transition.To)
+		if err != nil {
+			return
+		}
+		workflow.Transitions = append(workflow.Transitions, transition)
+	}
+	err = transitionRows.Err()
+
+	return
+}
+
+// GetBySprint returns the workflow of the project of the sprint
+func (r *workflowRepo) GetBySprint(ctx context.Context, sprintId string) (workflow Workflow, err error) {
+
+	stmt, err := tracing.Prepare(ctx, r.db, "select project_id from sprint where id = ?")
+	if err != nil {
+		logError(ctx, err)
+		return
+	}
+	defer stmt.Close()
+	var projectId string
+	err = stmt.QueryRowContext(ctx, sprintId).Scan(&projectId)
+	if err != nil {
+		return
+	}
+
+	return r.Get(ctx, projectId)
+}
@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type AccountData struct {
+	Name       *string              `json:"name"`
+	Slug       *string              `json:"slug"`
+	RequireMfa *bool                `json:"requireMfa"`
+	Settings   *AccountSettingsData `json:"settings"`
+}
+
+type AccountSettingsData struct {
+	StoryStatuses    []string `json:"storyStatuses"`
+	SprintLengthDays *int     `json:"sprintLengthDays"`
+}
+
+type OwnerData struct {
+	UserId string `json:"userId"`
+}
+
+type AccountDeletionData struct {
+	Confirm string `json:"confirm"`
+}
+
+type accountRoutes struct {
+	service services.AccountService
+}
+
+func NewAccountRoutes(service services.AccountService) Routable {
+	return &accountRoutes{service: service}
+}
+
+func (r *accountRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("accounts", func(c *gin.Context) { r.FindMine(c) })
+	rg.GET("accounts/:accountId", func(c *gin.Context) { r.Get(c) })
+	rg.PATCH("accounts/:accountId", func(c *gin.Context) { r.Update(c) })
+	rg.DELETE("accounts/:accountId", func(c *gin.Context) { r.Delete(c) })
+	rg.POST("accounts/:accountId/owner", func(c *gin.Context) { r.TransferOwnership(c) })
+	rg.GET("accounts/:accountId/export", func(c *gin.Context) { r.Export(c) })
+}
+
+func (r *accountRoutes) FindMine(c *gin.Context) {
+
+	accounts, err := r.service.FindMine(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(accounts))
+}
+
+func (r *accountRoutes) Get(c *gin.Context) {
+
+	accountId := c.Param("accountId")
+	if accountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	account, err := r.service.Get(c, accountId)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(account))
+}
+
+func (r *accountRoutes) Update(c *gin.Context) {
+
+	accountId := c.Param("accountId")
+	if accountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	var accountData AccountData
+
+	if err := c.ShouldBind(&accountData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	changes := services.AccountChanges{
+		Name:       accountData.Name,
+		Slug:       accountData.Slug,
+		RequireMfa: accountData.RequireMfa,
+	}
+	if accountData.Settings != nil {
+		changes.StoryStatuses = accountData.Settings.StoryStatuses
+		changes.SprintLengthDays = accountData.Settings.SprintLengthDays
+	}
+
+	account, err := r.service.Update(c, accountId, changes)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(account))
+}
+
+func (r *accountRoutes) TransferOwnership(c *gin.Context) {
+
+	accountId := c.Param("accountId")
+	if accountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	var ownerData OwnerData
+
+	if err := c.ShouldBind(&ownerData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+	if ownerData.UserId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	account, err := r.service.TransferOwnership(c, accountId, ownerData.UserId)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(account))
+}
+
+func (r *accountRoutes) Export(c *gin.Context) {
+
+	accountId := c.Param("accountId")
+	if accountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	export, err := r.service.Export(c, accountId)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Account.Slug+".json"))
+	c.JSON(http.StatusOK, jsonData(export))
+}
+
+func (r *accountRoutes) Delete(c *gin.Context) {
+
+	accountId := c.Param("accountId")
+	if accountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	var deletionData AccountDeletionData
+
+	if err := c.ShouldBind(&deletionData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if err := r.service.Delete(c, accountId, deletionData.Confirm); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type ImpersonationData struct {
+	AccountId string `json:"accountId"`
+	Reason    string `json:"reason"`
+}
+
+type adminRoutes struct {
+	impersonationService services.ImpersonationService
+}
+
+// NewAdminRoutes returns the routes for the platform admins that support the
+// users of all accounts
+func NewAdminRoutes(impersonationService services.ImpersonationService) Routable {
+	return &adminRoutes{impersonationService: impersonationService}
+}
+
+func (r *adminRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("admin/impersonate/:userId", func(c *gin.Context) { r.Impersonate(c) })
+}
+
+func (r *adminRoutes) Impersonate(c *gin.Context) {
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	var impersonationData ImpersonationData
+
+	if err := c.ShouldBind(&impersonationData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if impersonationData.Reason == "" {
+		AbortWithError(c, missing("reason"))
+		return
+	}
+
+	user, err := r.impersonationService.Start(c, userId, impersonationData.AccountId, impersonationData.Reason)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
@@ -2,37 +2,87 @@ package routes
 
 import (
 	"cerberus-examples/internal/services"
-	"fmt"
+	"cerberus-examples/internal/utils"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type AuthData struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name" `
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required,password"`
+	Name     string `json:"name" binding:"required,notblank,max=100"`
+}
+
+type EmailData struct {
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+type SessionData struct {
+	RefreshToken string `json:"refreshToken"`
+	AccountId    string `json:"accountId"`
+}
+
+// TokenData carries the token of a mail. Users that accept an invite set
+// their password and name, unless they have an account already.
+type TokenData struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"omitempty,password"`
+	Name     string `json:"name" binding:"max=100"`
+}
+
+type ResetPasswordData struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,password"`
 }
 
 type authRoutes struct {
-	userService services.UserService
+	userService          services.UserService
+	sessionService       services.SessionService
+	passwordResetService services.PasswordResetService
+	verificationService  services.EmailVerificationService
+	inviteService        services.InviteService
+	mfaService           services.MfaService
 }
 
-func NewAuthRoutes(userService services.UserService) Routable {
+func NewAuthRoutes(
+	userService services.UserService,
+	sessionService services.SessionService,
+	passwordResetService services.PasswordResetService,
+	verificationService services.EmailVerificationService,
+	inviteService services.InviteService,
+	mfaService services.MfaService) Routable {
 	return &authRoutes{
-		userService: userService,
+		userService:          userService,
+		sessionService:       sessionService,
+		passwordResetService: passwordResetService,
+		verificationService:  verificationService,
+		inviteService:        inviteService,
+		mfaService:           mfaService,
 	}
 }
 
 func (r *authRoutes) RegisterRoutes(rg *gin.RouterGroup) {
 	rg.POST("auth/register", func(c *gin.Context) { r.Register(c) })
 	rg.POST("auth/login", func(c *gin.Context) { r.Login(c) })
+	rg.POST("auth/refresh", func(c *gin.Context) { r.Refresh(c) })
+	rg.POST("auth/switch-account", func(c *gin.Context) { r.SwitchAccount(c) })
+	rg.POST("auth/logout", func(c *gin.Context) { r.Logout(c) })
+	rg.POST("auth/forgot-password", func(c *gin.Context) { r.ForgotPassword(c) })
+	rg.POST("auth/reset-password", func(c *gin.Context) { r.ResetPassword(c) })
+	rg.POST("auth/verify-email", func(c *gin.Context) { r.VerifyEmail(c) })
+	rg.POST("auth/resend-verification", func(c *gin.Context) { r.ResendVerification(c) })
+	rg.POST("auth/accept-invite", func(c *gin.Context) { r.AcceptInvite(c) })
+	rg.POST("auth/mfa/enroll", func(c *gin.Context) { r.MfaEnroll(c) })
+	rg.POST("auth/mfa/verify", func(c *gin.Context) { r.MfaVerify(c) })
 }
 
 func (r *authRoutes) Register(c *gin.Context) {
 	var authData AuthData
 
-	if err := c.Bind(&authData); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&authData); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -43,7 +93,7 @@ func (r *authRoutes) Register(c *gin.Context) {
 		authData.Name,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -53,7 +103,8 @@ func (r *authRoutes) Register(c *gin.Context) {
 func (r *authRoutes) Login(c *gin.Context) {
 	email, password, ok := c.Request.BasicAuth()
 	if !ok {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("invalid credentials")))
+		AbortWithError(c, utils.NewUnauthorizedError("invalid credentials"))
+		return
 	}
 
 	user, err := r.userService.Login(
@@ -62,7 +113,227 @@ func (r *authRoutes) Login(c *gin.Context) {
 		password,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+		if seconds, ok := retryAfter(err); ok {
+			c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+		}
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(user))
+}
+
+func (r *authRoutes) Refresh(c *gin.Context) {
+	var sessionData SessionData
+
+	if err := c.ShouldBind(&sessionData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if sessionData.RefreshToken == "" {
+		AbortWithError(c, missing("refreshToken"))
+		return
+	}
+
+	user, err := r.sessionService.Refresh(
+		c,
+		sessionData.RefreshToken,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(user))
+}
+
+// SwitchAccount exchanges the refresh token in the body for tokens scoped to
+// another account of the user
+func (r *authRoutes) SwitchAccount(c *gin.Context) {
+	var sessionData SessionData
+
+	if err := c.ShouldBind(&sessionData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if sessionData.RefreshToken == "" {
+		AbortWithError(c, missing("refreshToken"))
+		return
+	}
+	if sessionData.AccountId == "" {
+		AbortWithError(c, missing("accountId"))
+		return
+	}
+
+	user, err := r.sessionService.SwitchAccount(
+		c,
+		sessionData.RefreshToken,
+		sessionData.AccountId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(user))
+}
+
+// Logout revokes the session of the refresh token in the body, and the access
+// token in the Authorization header when present
+func (r *authRoutes) Logout(c *gin.Context) {
+	var sessionData SessionData
+
+	if err := c.ShouldBind(&sessionData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	accessToken := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+
+	err := r.sessionService.Logout(
+		c,
+		accessToken,
+		sessionData.RefreshToken,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
+
+func (r *authRoutes) ForgotPassword(c *gin.Context) {
+	var emailData EmailData
+
+	if err := c.ShouldBind(&emailData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if err := r.passwordResetService.ForgotPassword(c, emailData.Email); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, jsonData(true))
+}
+
+func (r *authRoutes) ResetPassword(c *gin.Context) {
+	var resetData ResetPasswordData
+
+	if err := c.ShouldBind(&resetData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if err := r.passwordResetService.ResetPassword(c, resetData.Token, resetData.Password); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
+
+func (r *authRoutes) VerifyEmail(c *gin.Context) {
+	var tokenData TokenData
+
+	if err := c.ShouldBind(&tokenData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	user, err := r.verificationService.Verify(c, tokenData.Token)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(user))
+}
+
+func (r *authRoutes) ResendVerification(c *gin.Context) {
+	var emailData EmailData
+
+	if err := c.ShouldBind(&emailData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if err := r.verificationService.Resend(c, emailData.Email); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, jsonData(true))
+}
+
+func (r *authRoutes) AcceptInvite(c *gin.Context) {
+	var tokenData TokenData
+
+	if err := c.ShouldBind(&tokenData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	// Users of other accounts accept without a password
+	user, err := r.inviteService.Accept(c, tokenData.Token, tokenData.Password, tokenData.Name)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(user))
+}
+
+// MfaEnroll starts setting up an authenticator app during a login that
+// requires one
+func (r *authRoutes) MfaEnroll(c *gin.Context) {
+	var mfaData MfaData
+
+	if err := c.ShouldBind(&mfaData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if mfaData.MfaToken == "" {
+		AbortWithError(c, missing("mfaToken"))
+		return
+	}
+
+	enrollment, err := r.mfaService.EnrollChallenge(c, mfaData.MfaToken)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(enrollment))
+}
+
+// MfaVerify completes a login with a code of the authenticator app or a
+// recovery code
+func (r *authRoutes) MfaVerify(c *gin.Context) {
+	var mfaData MfaData
+
+	if err := c.ShouldBind(&mfaData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if mfaData.MfaToken == "" {
+		AbortWithError(c, missing("mfaToken"))
+		return
+	}
+	if mfaData.Code == "" && mfaData.RecoveryCode == "" {
+		AbortWithError(c, missing("code"))
+		return
+	}
+
+	user, err := r.mfaService.VerifyChallenge(c, mfaData.MfaToken, mfaData.Code, mfaData.RecoveryCode)
+	if err != nil {
+		AbortWithError(c, err)
 		return
 	}
 
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type InviteData struct {
+	Email  string `json:"email" binding:"required,email,max=254"`
+	Name   string `json:"name" binding:"max=100"`
+	RoleId string `json:"roleId"`
+}
+
+type inviteRoutes struct {
+	service services.InviteService
+}
+
+func NewInviteRoutes(service services.InviteService) Routable {
+	return &inviteRoutes{service: service}
+}
+
+func (r *inviteRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("invites", func(c *gin.Context) { r.Create(c) })
+	rg.GET("invites", func(c *gin.Context) { r.FindAll(c) })
+	rg.DELETE("invites/:inviteId", func(c *gin.Context) { r.Revoke(c) })
+}
+
+func (r *inviteRoutes) Create(c *gin.Context) {
+
+	var inviteData InviteData
+
+	if err := c.ShouldBind(&inviteData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	invite, err := r.service.Create(
+		c,
+		inviteData.Email,
+		inviteData.Name,
+		inviteData.RoleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(invite))
+}
+
+func (r *inviteRoutes) FindAll(c *gin.Context) {
+
+	invites, err := r.service.FindAll(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(invites))
+}
+
+func (r *inviteRoutes) Revoke(c *gin.Context) {
+
+	inviteId := c.Param("inviteId")
+	if inviteId == "" {
+		AbortWithError(c, missing("inviteId"))
+		return
+	}
+
+	if err := r.service.Revoke(c, inviteId); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
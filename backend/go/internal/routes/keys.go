@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services/jwtutils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type keyRoutes struct {
+	keys *jwtutils.KeySet
+}
+
+func NewKeyRoutes(keys *jwtutils.KeySet) Routable {
+	return &keyRoutes{keys: keys}
+}
+
+func (r *keyRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET(".well-known/jwks.json", func(c *gin.Context) { r.JWKS(c) })
+}
+
+// JWKS publishes the public keys that tokens can be verified with. The document
+// is served as is, without the usual data envelope, so that standard JWT
+// libraries can consume it.
+func (r *keyRoutes) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, r.keys.JWKS())
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type MfaData struct {
+	MfaToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type mfaRoutes struct {
+	service services.MfaService
+}
+
+// NewMfaRoutes returns the routes with which users manage their own
+// authenticator app
+func NewMfaRoutes(service services.MfaService) Routable {
+	return &mfaRoutes{service: service}
+}
+
+func (r *mfaRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("users/me/mfa", func(c *gin.Context) { r.Enroll(c) })
+	rg.POST("users/me/mfa/confirm", func(c *gin.Context) { r.Confirm(c) })
+	rg.POST("users/me/mfa/disable", func(c *gin.Context) { r.Disable(c) })
+}
+
+func (r *mfaRoutes) Enroll(c *gin.Context) {
+
+	enrollment, err := r.service.Enroll(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(enrollment))
+}
+
+func (r *mfaRoutes) Confirm(c *gin.Context) {
+
+	var mfaData MfaData
+
+	if err := c.ShouldBind(&mfaData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if mfaData.Code == "" {
+		AbortWithError(c, missing("code"))
+		return
+	}
+
+	if err := r.service.Confirm(c, mfaData.Code); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
+
+func (r *mfaRoutes) Disable(c *gin.Context) {
+
+	var mfaData MfaData
+
+	if err := c.ShouldBind(&mfaData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if mfaData.Code == "" && mfaData.RecoveryCode == "" {
+		AbortWithError(c, missing("code"))
+		return
+	}
+
+	if err := r.service.Disable(c, mfaData.Code, mfaData.RecoveryCode); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
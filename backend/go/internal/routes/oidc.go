@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"cerberus-examples/internal/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// oidcStateCookie keeps the state of a login at the identity provider in the
+// browser that started it
+const (
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/auth/oidc"
+)
+
+type oidcRoutes struct {
+	service services.OidcService
+}
+
+// NewOidcRoutes returns the routes of the login with an OpenID Connect
+// identity provider
+func NewOidcRoutes(service services.OidcService) Routable {
+	return &oidcRoutes{service: service}
+}
+
+func (r *oidcRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("auth/oidc/login", func(c *gin.Context) { r.Login(c) })
+	rg.GET("auth/oidc/callback", func(c *gin.Context) { r.Callback(c) })
+}
+
+func (r *oidcRoutes) Login(c *gin.Context) {
+
+	url, state, err := r.service.Login(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	// The identity provider redirects back with a top-level navigation, which
+	// lax cookies are sent with
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(services.OidcLoginTtl.Seconds()), oidcCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, url)
+}
+
+func (r *oidcRoutes) Callback(c *gin.Context) {
+
+	if providerError := c.Query("error"); providerError != "" {
+		AbortWithError(c, utils.NewDomainError(http.StatusBadRequest, "identity provider error", map[string]interface{}{
+			"error":       providerError,
+			"description": c.Query("error_description"),
+		}))
+		return
+	}
+
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		AbortWithError(c, utils.NewValidationError("missing code or state", nil))
+		return
+	}
+
+	// The state is used once
+	browserState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+
+	user, err := r.service.Callback(c, code, state, browserState)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
@@ -0,0 +1,35 @@
+package routes
+
+import "cerberus-examples/internal/services"
+
+// routePermissions maps the method and full path of private routes to the
+// permission the caller's role on the account must grant. Routes that are not
+// listed only require an authenticated caller; routes on projects, sprints
+// and stories are authorized by the services against the caller's project
+// roles.
+var routePermissions = map[string]string{
+	"GET /api/users":                         services.PermissionUserRead,
+	"POST /api/users/:userId/role":           services.PermissionUserWrite,
+	"PATCH /api/users/:userId":               services.PermissionUserWrite,
+	"DELETE /api/users/:userId":              services.PermissionUserWrite,
+	"POST /api/users/:userId/unlock":         services.PermissionUserWrite,
+	"POST /api/invites":                      services.PermissionUserWrite,
+	"GET /api/invites":                       services.PermissionUserRead,
+	"DELETE /api/invites/:inviteId":          services.PermissionUserWrite,
+	"POST /api/roles":                        services.PermissionRoleWrite,
+	"GET /api/roles":                         services.PermissionRoleRead,
+	"GET /api/roles/:roleId":                 services.PermissionRoleRead,
+	"DELETE /api/roles/:roleId":              services.PermissionRoleWrite,
+	"POST /api/accounts/:accountId/projects": services.PermissionProjectWrite,
+	"PATCH /api/accounts/:accountId":         services.PermissionAccountWrite,
+	"DELETE /api/accounts/:accountId":        services.PermissionAccountWrite,
+	"POST /api/accounts/:accountId/owner":    services.PermissionAccountWrite,
+	"GET /api/accounts/:accountId/export":    services.PermissionAccountWrite,
+}
+
+// RequiredPermission returns the permission needed to call the route
+// registered under the method and full path
+func RequiredPermission(method, fullPath string) (string, bool) {
+	permission, ok := routePermissions[method+" "+fullPath]
+	return permission, ok
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type ProjectMemberData struct {
+	UserId string `json:"userId"`
+	RoleId string `json:"roleId"`
+}
+
+type projectMemberRoutes struct {
+	service services.ProjectMemberService
+}
+
+func NewProjectMemberRoutes(service services.ProjectMemberService) Routable {
+	return &projectMemberRoutes{service: service}
+}
+
+func (r *projectMemberRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("projects/:projectId/members", func(c *gin.Context) { r.Add(c) })
+	rg.GET("projects/:projectId/members", func(c *gin.Context) { r.FindByProject(c) })
+	rg.PATCH("projects/:projectId/members/:userId", func(c *gin.Context) { r.ChangeRole(c) })
+	rg.DELETE("projects/:projectId/members/:userId", func(c *gin.Context) { r.Remove(c) })
+}
+
+func (r *projectMemberRoutes) Add(c *gin.Context) {
+
+	var memberData ProjectMemberData
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	if err := c.ShouldBind(&memberData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	member, err := r.service.Add(
+		c,
+		projectId,
+		memberData.UserId,
+		memberData.RoleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(member))
+}
+
+func (r *projectMemberRoutes) FindByProject(c *gin.Context) {
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	members, err := r.service.FindByProject(
+		c,
+		projectId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(members))
+}
+
+func (r *projectMemberRoutes) ChangeRole(c *gin.Context) {
+
+	var memberData ProjectMemberData
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	if err := c.ShouldBind(&memberData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	member, err := r.service.ChangeRole(
+		c,
+		projectId,
+		userId,
+		memberData.RoleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(member))
+}
+
+func (r *projectMemberRoutes) Remove(c *gin.Context) {
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	err := r.service.Remove(
+		c,
+		projectId,
+		userId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
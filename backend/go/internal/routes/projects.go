@@ -1,15 +1,32 @@
 package routes
 
 import (
+	"cerberus-examples/internal/repositories"
 	"cerberus-examples/internal/services"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
 type ProjectData struct {
-	Name        string `json:"name" `
-	Description string `json:"description"`
+	Name        string `json:"name" binding:"required,notblank,max=100"`
+	Description string `json:"description" binding:"max=2000"`
+}
+
+// WorkflowData defines the states of the stories of a project, in the order
+// of the columns of a board, and the transitions allowed between them
+type WorkflowData struct {
+	States      []WorkflowStateData      `json:"states" binding:"required,dive"`
+	Transitions []WorkflowTransitionData `json:"transitions" binding:"dive"`
+}
+
+type WorkflowStateData struct {
+	Name     string `json:"name" binding:"required,notblank,max=50"`
+	Category string `json:"category" binding:"required"`
+}
+
+type WorkflowTransitionData struct {
+	From string `json:"from" binding:"required"`
+	To   string `json:"to" binding:"required"`
 }
 
 type projectRoutes struct {
@@ -25,6 +42,8 @@ func (r *projectRoutes) RegisterRoutes(rg *gin.RouterGroup) {
 	rg.GET("accounts/:accountId/projects", func(c *gin.Context) { r.FindAll(c) })
 	rg.GET("projects/:projectId", func(c *gin.Context) { r.Get(c) })
 	rg.DELETE("projects/:projectId", func(c *gin.Context) { r.Delete(c) })
+	rg.GET("projects/:projectId/workflow", func(c *gin.Context) { r.Workflow(c) })
+	rg.PUT("projects/:projectId/workflow", func(c *gin.Context) { r.UpdateWorkflow(c) })
 }
 
 func (r *projectRoutes) Create(c *gin.Context) {
@@ -33,12 +52,12 @@ func (r *projectRoutes) Create(c *gin.Context) {
 
 	accountId := c.Param("accountId")
 	if accountId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing accountId")))
+		AbortWithError(c, missing("accountId"))
 		return
 	}
 
-	if err := c.Bind(&projectData); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&projectData); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -49,7 +68,7 @@ func (r *projectRoutes) Create(c *gin.Context) {
 		projectData.Description,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -59,7 +78,7 @@ func (r *projectRoutes) Create(c *gin.Context) {
 func (r *projectRoutes) FindAll(c *gin.Context) {
 	accountId := c.Param("accountId")
 	if accountId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing accountId")))
+		AbortWithError(c, missing("accountId"))
 		return
 	}
 
@@ -68,7 +87,7 @@ func (r *projectRoutes) FindAll(c *gin.Context) {
 		accountId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -79,7 +98,7 @@ func (r *projectRoutes) Get(c *gin.Context) {
 
 	projectId := c.Param("projectId")
 	if projectId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing projectId")))
+		AbortWithError(c, missing("projectId"))
 		return
 	}
 
@@ -88,7 +107,7 @@ func (r *projectRoutes) Get(c *gin.Context) {
 		projectId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -99,7 +118,7 @@ func (r *projectRoutes) Delete(c *gin.Context) {
 
 	projectId := c.Param("projectId")
 	if projectId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing projectId")))
+		AbortWithError(c, missing("projectId"))
 		return
 	}
 
@@ -108,9 +127,71 @@ func (r *projectRoutes) Delete(c *gin.Context) {
 		projectId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, jsonData(true))
 }
+
+// Workflow answers the states that the stories of the project move through,
+// in the order of the columns of a board, and the transitions between them
+func (r *projectRoutes) Workflow(c *gin.Context) {
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	workflow, err := r.service.GetWorkflow(
+		c,
+		projectId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(workflow))
+}
+
+// UpdateWorkflow replaces the states that the stories of the project move
+// through and the transitions between them
+func (r *projectRoutes) UpdateWorkflow(c *gin.Context) {
+
+	var workflowData WorkflowData
+
+	projectId := c.Param("projectId")
+	if projectId == "" {
+		AbortWithError(c, missing("projectId"))
+		return
+	}
+
+	if err := c.ShouldBind(&workflowData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	states := make([]repositories.WorkflowState, 0, len(workflowData.States))
+	for _, state := range workflowData.States {
+		states = append(states, repositories.WorkflowState{Name: state.Name, Category: state.Category})
+	}
+	transitions := make([]repositories.WorkflowTransition, 0, len(workflowData.Transitions))
+	for _, transition := range workflowData.Transitions {
+		transitions = append(transitions, repositories.WorkflowTransition{From: transition.From, To: transition.To})
+	}
+
+	workflow, err := r.service.UpdateWorkflow(
+		c,
+		projectId,
+		states,
+		transitions,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(workflow))
+}
@@ -1,8 +1,29 @@
 package routes
 
-type errorResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+import (
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/utils"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// Problem describes why a request failed, as RFC 7807 problem details. The
+// code is stable and meant for clients, the detail is meant for people.
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Code      string                 `json:"code"`
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	RequestId string                 `json:"requestId,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
 }
 
 type successResponse struct {
@@ -10,15 +31,72 @@ type successResponse struct {
 	Data interface{} `json:"data"`
 }
 
-func jsonError(err error) errorResponse {
-	var message string
-	if err != nil {
-		message = err.Error()
+// Problems answers requests that ended with an error with problem details,
+// unless a response was written already. It must run before the handlers.
+func Problems(c *gin.Context) {
+	c.Next()
+
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
 	}
-	return errorResponse{
-		Code:    500,
-		Message: message,
+	problem := newProblem(c, c.Errors.Last().Err)
+	c.Header("Content-Type", problemContentType)
+	c.JSON(problem.Status, problem)
+}
+
+// AbortWithError ends the request with the error, which Problems answers
+func AbortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// domainError returns the domain error that the error is or stands for. Rows
+// that were not found are resources that were not found, and violated
+// constraints are conflicts or validation errors. Errors of the app are not
+// domain errors.
+func domainError(err error) (*utils.DomainError, bool) {
+	err = database.TranslateError(err)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = utils.NewNotFoundError("resource")
+	}
+
+	var domainErr *utils.DomainError
+	if errors.As(err, &domainErr) {
+		return domainErr, true
+	}
+	return nil, false
+}
+
+func newProblem(c *gin.Context, err error) Problem {
+	domainErr, ok := domainError(err)
+	if !ok {
+		// Errors of the app are logged rather than answered, as they may
+		// tell about its internals
+		logging.FromContext(c).Error("request failed", "error", err)
+		domainErr = utils.NewDomainError(http.StatusInternalServerError, "internal error", nil).(*utils.DomainError)
+	}
+
+	return Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(domainErr.StatusCode()),
+		Status:    domainErr.StatusCode(),
+		Code:      domainErr.Code(),
+		Detail:    domainErr.Message(),
+		Instance:  c.Request.URL.Path,
+		RequestId: c.GetString("requestId"),
+		Details:   domainErr.Details(),
+	}
+}
+
+// retryAfter returns the seconds a client has to wait before retrying, for
+// errors about too many requests
+func retryAfter(err error) (int64, bool) {
+	var domainErr *utils.DomainError
+	if !errors.As(err, &domainErr) || domainErr.StatusCode() != http.StatusTooManyRequests {
+		return 0, false
 	}
+	seconds, ok := domainErr.Details()["retryAfter"].(int64)
+	return seconds, ok
 }
 
 func jsonData(data interface{}) successResponse {
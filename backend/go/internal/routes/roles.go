@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type RoleData struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type roleRoutes struct {
+	service services.RoleService
+}
+
+func NewRoleRoutes(service services.RoleService) Routable {
+	return &roleRoutes{service: service}
+}
+
+func (r *roleRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("roles", func(c *gin.Context) { r.Create(c) })
+	rg.GET("roles", func(c *gin.Context) { r.FindAll(c) })
+	rg.GET("roles/:roleId", func(c *gin.Context) { r.Get(c) })
+	rg.DELETE("roles/:roleId", func(c *gin.Context) { r.Delete(c) })
+}
+
+func (r *roleRoutes) Create(c *gin.Context) {
+
+	var roleData RoleData
+
+	if err := c.ShouldBind(&roleData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	role, err := r.service.Create(
+		c,
+		roleData.Name,
+		roleData.Permissions,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(role))
+}
+
+func (r *roleRoutes) FindAll(c *gin.Context) {
+
+	roles, err := r.service.FindAll(
+		c,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(roles))
+}
+
+func (r *roleRoutes) Get(c *gin.Context) {
+
+	roleId := c.Param("roleId")
+	if roleId == "" {
+		AbortWithError(c, missing("roleId"))
+		return
+	}
+
+	role, err := r.service.Get(
+		c,
+		roleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(role))
+}
+
+func (r *roleRoutes) Delete(c *gin.Context) {
+
+	roleId := c.Param("roleId")
+	if roleId == "" {
+		AbortWithError(c, missing("roleId"))
+		return
+	}
+
+	err := r.service.Delete(
+		c,
+		roleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
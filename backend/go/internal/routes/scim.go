@@ -0,0 +1,326 @@
+package routes
+
+import (
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/scim"
+	"cerberus-examples/internal/services"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type scimRoutes struct {
+	service services.ScimService
+}
+
+// NewScimRoutes returns the SCIM 2.0 routes with which identity providers
+// provision users and groups. They are registered below /scim/v2.
+func NewScimRoutes(service services.ScimService) Routable {
+	return &scimRoutes{service: service}
+}
+
+func (r *scimRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("ServiceProviderConfig", func(c *gin.Context) { r.ServiceProviderConfig(c) })
+	rg.GET("Users", func(c *gin.Context) { r.FindUsers(c) })
+	rg.POST("Users", func(c *gin.Context) { r.CreateUser(c) })
+	rg.GET("Users/:userId", func(c *gin.Context) { r.GetUser(c) })
+	rg.PUT("Users/:userId", func(c *gin.Context) { r.ReplaceUser(c) })
+	rg.PATCH("Users/:userId", func(c *gin.Context) { r.PatchUser(c) })
+	rg.DELETE("Users/:userId", func(c *gin.Context) { r.DeactivateUser(c) })
+	rg.GET("Groups", func(c *gin.Context) { r.FindGroups(c) })
+	rg.POST("Groups", func(c *gin.Context) { r.CreateGroup(c) })
+	rg.GET("Groups/:groupId", func(c *gin.Context) { r.GetGroup(c) })
+	rg.PUT("Groups/:groupId", func(c *gin.Context) { r.ReplaceGroup(c) })
+	rg.PATCH("Groups/:groupId", func(c *gin.Context) { r.PatchGroup(c) })
+	rg.DELETE("Groups/:groupId", func(c *gin.Context) { r.DeleteGroup(c) })
+}
+
+func (r *scimRoutes) ServiceProviderConfig(c *gin.Context) {
+	supported := func(supported bool) gin.H { return gin.H{"supported": supported} }
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{scim.SchemaConfig},
+		"patch":          supported(true),
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scim.MaxResults},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "API key",
+			"description": "A service key of the account with the user:write scope",
+		}},
+	})
+}
+
+func (r *scimRoutes) FindUsers(c *gin.Context) {
+
+	startIndex, count, err := listParams(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	list, err := r.service.FindUsers(c, c.Query("filter"), startIndex, count)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	for i := range list.Resources.([]scim.User) {
+		setUserLocation(c, &list.Resources.([]scim.User)[i])
+	}
+	scimJSON(c, http.StatusOK, list)
+}
+
+func (r *scimRoutes) GetUser(c *gin.Context) {
+
+	user, err := r.service.GetUser(c, c.Param("userId"))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setUserLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (r *scimRoutes) CreateUser(c *gin.Context) {
+
+	var user scim.User
+
+	if err := c.ShouldBindJSON(&user); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	user, err := r.service.CreateUser(c, user)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setUserLocation(c, &user)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+func (r *scimRoutes) ReplaceUser(c *gin.Context) {
+
+	var user scim.User
+
+	if err := c.ShouldBindJSON(&user); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	user, err := r.service.ReplaceUser(c, c.Param("userId"), user)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setUserLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (r *scimRoutes) PatchUser(c *gin.Context) {
+
+	var patch scim.PatchOp
+
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	user, err := r.service.PatchUser(c, c.Param("userId"), patch)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setUserLocation(c, &user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (r *scimRoutes) DeactivateUser(c *gin.Context) {
+
+	if err := r.service.DeactivateUser(c, c.Param("userId")); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (r *scimRoutes) FindGroups(c *gin.Context) {
+
+	startIndex, count, err := listParams(c)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	list, err := r.service.FindGroups(c, c.Query("filter"), startIndex, count, withMembers(c))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	for i := range list.Resources.([]scim.Group) {
+		setGroupLocation(c, &list.Resources.([]scim.Group)[i])
+	}
+	scimJSON(c, http.StatusOK, list)
+}
+
+func (r *scimRoutes) GetGroup(c *gin.Context) {
+
+	group, err := r.service.GetGroup(c, c.Param("groupId"), withMembers(c))
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setGroupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (r *scimRoutes) CreateGroup(c *gin.Context) {
+
+	var group scim.Group
+
+	if err := c.ShouldBindJSON(&group); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	group, err := r.service.CreateGroup(c, group)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setGroupLocation(c, &group)
+	scimJSON(c, http.StatusCreated, group)
+}
+
+func (r *scimRoutes) ReplaceGroup(c *gin.Context) {
+
+	var group scim.Group
+
+	if err := c.ShouldBindJSON(&group); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	group, err := r.service.ReplaceGroup(c, c.Param("groupId"), group)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setGroupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (r *scimRoutes) PatchGroup(c *gin.Context) {
+
+	var patch scim.PatchOp
+
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, invalidSyntax(err))
+		return
+	}
+
+	group, err := r.service.PatchGroup(c, c.Param("groupId"), patch)
+	if err != nil {
+		scimError(c, err)
+		return
+	}
+
+	setGroupLocation(c, &group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (r *scimRoutes) DeleteGroup(c *gin.Context) {
+
+	if err := r.service.DeleteGroup(c, c.Param("groupId")); err != nil {
+		scimError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// listParams returns the 1-based start index and the page size of a list
+// request
+func listParams(c *gin.Context) (int, int, error) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil {
+		return 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "invalid startIndex")
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scim.MaxResults)))
+	if err != nil {
+		return 0, 0, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "invalid count")
+	}
+	return startIndex, count, nil
+}
+
+// withMembers tells whether the members of groups are requested, which
+// identity providers often leave out to keep lists of groups small
+func withMembers(c *gin.Context) bool {
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return false
+		}
+	}
+	return true
+}
+
+func setUserLocation(c *gin.Context, user *scim.User) {
+	if user.Meta != nil {
+		user.Meta.Location = scimLocation(c, "Users", user.Id)
+	}
+}
+
+func setGroupLocation(c *gin.Context, group *scim.Group) {
+	if group.Meta != nil {
+		group.Meta.Location = scimLocation(c, "Groups", group.Id)
+	}
+}
+
+func scimLocation(c *gin.Context, resource, id string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%s", scheme, c.Request.Host, resource, id)
+}
+
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, body)
+}
+
+// scimError answers with the SCIM error of a domain error, or with an
+// internal error
+func scimError(c *gin.Context, err error) {
+	domainErr, ok := domainError(err)
+	if !ok {
+		logging.FromContext(c).Error("request failed", "error", err)
+		c.Header("Content-Type", scim.ContentType)
+		c.AbortWithStatusJSON(http.StatusInternalServerError,
+			scim.NewErrorResponse(http.StatusInternalServerError, "", "internal error"))
+		return
+	}
+
+	scimType, _ := domainErr.Details()["scimType"].(string)
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(domainErr.StatusCode(),
+		scim.NewErrorResponse(domainErr.StatusCode(), scimType, domainErr.Message()))
+}
+
+func invalidSyntax(err error) error {
+	return scim.NewError(http.StatusBadRequest, scim.ErrorInvalidSyntax, err.Error())
+}
@@ -2,14 +2,13 @@ package routes
 
 import (
 	"cerberus-examples/internal/services"
-	"fmt"
+	"cerberus-examples/internal/utils"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
 )
 
 type SprintData struct {
-	Goal string `json:"goal"`
+	Goal string `json:"goal" binding:"required,notblank,max=500"`
 }
 
 type sprintRoutes struct {
@@ -34,12 +33,12 @@ func (r *sprintRoutes) Create(c *gin.Context) {
 
 	projectId := c.Param("projectId")
 	if projectId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing projectId")))
+		AbortWithError(c, missing("projectId"))
 		return
 	}
 
-	if err := c.Bind(&resourceTypeData); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&resourceTypeData); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -49,7 +48,7 @@ func (r *sprintRoutes) Create(c *gin.Context) {
 		resourceTypeData.Goal,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -57,16 +56,15 @@ func (r *sprintRoutes) Create(c *gin.Context) {
 }
 
 func (r *sprintRoutes) FindByProject(c *gin.Context) {
-	userId, exists := c.Get("userId")
+	_, exists := c.Get("userId")
 	if !exists {
-		c.AbortWithStatusJSON(401, jsonError(fmt.Errorf("unauthorized")))
+		AbortWithError(c, utils.NewDomainError(http.StatusUnauthorized, "unauthorized", nil))
+		return
 	}
 
-	log.Println("User:", userId)
-
 	projectId := c.Param("projectId")
 	if projectId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing projectId")))
+		AbortWithError(c, missing("projectId"))
 		return
 	}
 
@@ -75,7 +73,7 @@ func (r *sprintRoutes) FindByProject(c *gin.Context) {
 		projectId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -86,7 +84,7 @@ func (r *sprintRoutes) Start(c *gin.Context) {
 
 	sprintId := c.Param("sprintId")
 	if sprintId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing sprintId")))
+		AbortWithError(c, missing("sprintId"))
 		return
 	}
 
@@ -95,7 +93,7 @@ func (r *sprintRoutes) Start(c *gin.Context) {
 		sprintId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -106,7 +104,7 @@ func (r *sprintRoutes) End(c *gin.Context) {
 
 	sprintId := c.Param("sprintId")
 	if sprintId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing sprintId")))
+		AbortWithError(c, missing("sprintId"))
 		return
 	}
 
@@ -115,7 +113,7 @@ func (r *sprintRoutes) End(c *gin.Context) {
 		sprintId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -126,7 +124,7 @@ func (r *sprintRoutes) Get(c *gin.Context) {
 
 	sprintId := c.Param("sprintId")
 	if sprintId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing sprintId")))
+		AbortWithError(c, missing("sprintId"))
 		return
 	}
 
@@ -135,7 +133,7 @@ func (r *sprintRoutes) Get(c *gin.Context) {
 		sprintId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -2,17 +2,29 @@ package routes
 
 import (
 	"cerberus-examples/internal/services"
-	"fmt"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"strconv"
 )
 
 type StoryData struct {
-	Description string `json:"description"`
-	Estimation  string `json:"estimation"`
-	Status      string `json:"status"`
-	UserId      string `json:"userId"`
+	Description string `json:"description" binding:"required,notblank,max=2000"`
+}
+
+// EstimationData carries story points, as a number or a string
+type EstimationData struct {
+	Estimation json.Number `json:"estimation" binding:"required,estimation"`
+}
+
+// StatusData carries a state of the workflow of the story's project, which the
+// service checks
+type StatusData struct {
+	Status string `json:"status" binding:"required,notblank,max=40"`
+}
+
+// AssignmentData carries the assignee of a story, or no one
+type AssignmentData struct {
+	UserId string `json:"userId"`
 }
 
 type storyRoutes struct {
@@ -40,12 +52,12 @@ func (r *storyRoutes) Create(c *gin.Context) {
 
 	sprintId := c.Param("sprintId")
 	if sprintId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing sprintId")))
+		AbortWithError(c, missing("sprintId"))
 		return
 	}
 
-	if err := c.Bind(&data); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&data); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -55,7 +67,7 @@ func (r *storyRoutes) Create(c *gin.Context) {
 		data.Description,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -66,7 +78,7 @@ func (r *storyRoutes) FindBySprint(c *gin.Context) {
 
 	sprintId := c.Param("sprintId")
 	if sprintId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing sprintId")))
+		AbortWithError(c, missing("sprintId"))
 		return
 	}
 
@@ -75,7 +87,7 @@ func (r *storyRoutes) FindBySprint(c *gin.Context) {
 		sprintId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -86,7 +98,7 @@ func (r *storyRoutes) Get(c *gin.Context) {
 
 	storyId := c.Param("storyId")
 	if storyId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing storyId")))
+		AbortWithError(c, missing("storyId"))
 		return
 	}
 
@@ -95,7 +107,7 @@ func (r *storyRoutes) Get(c *gin.Context) {
 		storyId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -106,21 +118,19 @@ func (r *storyRoutes) Estimate(c *gin.Context) {
 
 	storyId := c.Param("storyId")
 	if storyId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing storyId")))
+		AbortWithError(c, missing("storyId"))
 		return
 	}
 
-	var data StoryData
+	var data EstimationData
 
-	if err := c.Bind(&data); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&data); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
-	estimation, err := strconv.ParseInt(data.Estimation, 10, 32)
-	if err != nil {
-		estimation = 0
-	}
+	// The estimation rule makes sure that it is a small integer
+	estimation, _ := data.Estimation.Int64()
 
 	story, err := r.service.Estimate(
 		c,
@@ -128,7 +138,7 @@ func (r *storyRoutes) Estimate(c *gin.Context) {
 		int(estimation),
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -139,14 +149,14 @@ func (r *storyRoutes) ChangeStatus(c *gin.Context) {
 
 	storyId := c.Param("storyId")
 	if storyId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing storyId")))
+		AbortWithError(c, missing("storyId"))
 		return
 	}
 
-	var data StoryData
+	var data StatusData
 
-	if err := c.Bind(&data); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&data); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -156,7 +166,7 @@ func (r *storyRoutes) ChangeStatus(c *gin.Context) {
 		data.Status,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
@@ -167,14 +177,14 @@ func (r *storyRoutes) Assign(c *gin.Context) {
 
 	storyId := c.Param("storyId")
 	if storyId == "" {
-		c.AbortWithStatusJSON(400, jsonError(fmt.Errorf("missing storyId")))
+		AbortWithError(c, missing("storyId"))
 		return
 	}
 
-	var data StoryData
+	var data AssignmentData
 
-	if err := c.Bind(&data); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&data); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
@@ -184,7 +194,7 @@ func (r *storyRoutes) Assign(c *gin.Context) {
 		data.UserId,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
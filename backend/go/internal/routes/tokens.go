@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type ApiKeyData struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"expiresAt"`
+	Service   bool     `json:"service"`
+	RoleId    string   `json:"roleId"`
+}
+
+type tokenRoutes struct {
+	service services.ApiKeyService
+}
+
+// NewTokenRoutes returns the routes with which users manage their personal
+// access tokens and the service keys of their account
+func NewTokenRoutes(service services.ApiKeyService) Routable {
+	return &tokenRoutes{service: service}
+}
+
+func (r *tokenRoutes) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.POST("users/me/tokens", func(c *gin.Context) { r.Create(c) })
+	rg.GET("users/me/tokens", func(c *gin.Context) { r.FindAll(c) })
+	rg.DELETE("users/me/tokens/:tokenId", func(c *gin.Context) { r.Revoke(c) })
+}
+
+func (r *tokenRoutes) Create(c *gin.Context) {
+
+	var apiKeyData ApiKeyData
+
+	if err := c.ShouldBind(&apiKeyData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	if apiKeyData.Name == "" {
+		AbortWithError(c, missing("name"))
+		return
+	}
+
+	key, err := r.service.Create(
+		c,
+		apiKeyData.Name,
+		apiKeyData.Scopes,
+		apiKeyData.ExpiresAt,
+		apiKeyData.Service,
+		apiKeyData.RoleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, jsonData(key))
+}
+
+func (r *tokenRoutes) FindAll(c *gin.Context) {
+
+	keys, err := r.service.FindAll(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(keys))
+}
+
+func (r *tokenRoutes) Revoke(c *gin.Context) {
+
+	tokenId := c.Param("tokenId")
+	if tokenId == "" {
+		AbortWithError(c, missing("tokenId"))
+		return
+	}
+
+	if err := r.service.Revoke(c, tokenId); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
@@ -6,59 +6,203 @@ import (
 	"net/http"
 )
 
+// UserData changes the profile of the caller. Empty fields are left
+// unchanged.
 type UserData struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
-	RoleId   string `json:"roleId"`
+	Email string `json:"email" binding:"omitempty,email,max=254"`
+	Name  string `json:"name" binding:"omitempty,notblank,max=100"`
+}
+
+type UserRoleData struct {
+	RoleId string `json:"roleId" binding:"required"`
+}
+
+// UserUpdateData changes a user. Empty fields are left unchanged, and Active
+// deactivates or reactivates the user.
+type UserUpdateData struct {
+	Email  string `json:"email" binding:"omitempty,email,max=254"`
+	Name   string `json:"name" binding:"omitempty,notblank,max=100"`
+	Active *bool  `json:"active"`
+}
+
+type PasswordChangeData struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword" binding:"required,password"`
 }
 
 type userRoutes struct {
-	userService services.UserService
+	userService       services.UserService
+	loginGuardService services.LoginGuardService
 }
 
-func NewUserRoutes(userService services.UserService) Routable {
-	return &userRoutes{userService: userService}
+func NewUserRoutes(userService services.UserService, loginGuardService services.LoginGuardService) Routable {
+	return &userRoutes{
+		userService:       userService,
+		loginGuardService: loginGuardService,
+	}
 }
 
 func (r *userRoutes) RegisterRoutes(rg *gin.RouterGroup) {
-	rg.POST("users", func(c *gin.Context) { r.Add(c) })
 	rg.GET("users", func(c *gin.Context) { r.GetAll(c) })
+	rg.GET("users/me", func(c *gin.Context) { r.GetMe(c) })
+	rg.PATCH("users/me", func(c *gin.Context) { r.UpdateMe(c) })
+	rg.POST("users/me/password", func(c *gin.Context) { r.ChangePassword(c) })
+	rg.PATCH("users/:userId", func(c *gin.Context) { r.Update(c) })
+	rg.DELETE("users/:userId", func(c *gin.Context) { r.Deactivate(c) })
+	rg.POST("users/:userId/role", func(c *gin.Context) { r.ChangeRole(c) })
+	rg.POST("users/:userId/unlock", func(c *gin.Context) { r.Unlock(c) })
+}
+
+func (r *userRoutes) GetAll(c *gin.Context) {
+
+	user, err := r.userService.GetAll(
+		c,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
+
+func (r *userRoutes) ChangeRole(c *gin.Context) {
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	var roleData UserRoleData
+
+	if err := c.ShouldBind(&roleData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	err := r.userService.ChangeRole(
+		c,
+		userId,
+		roleData.RoleId,
+	)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
 }
 
-func (r *userRoutes) Add(c *gin.Context) {
+func (r *userRoutes) Unlock(c *gin.Context) {
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	if err := r.loginGuardService.Unlock(c, userId); err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(true))
+}
+
+func (r *userRoutes) GetMe(c *gin.Context) {
+
+	user, err := r.userService.GetMe(c)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
+
+func (r *userRoutes) UpdateMe(c *gin.Context) {
 
 	var userData UserData
 
-	if err := c.Bind(&userData); err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+	if err := c.ShouldBind(&userData); err != nil {
+		AbortWithError(c, bindError(err))
 		return
 	}
 
-	user, err := r.userService.Add(
+	user, err := r.userService.UpdateMe(
 		c,
-		userData.Email,
-		userData.Password,
 		userData.Name,
-		userData.RoleId,
+		userData.Email,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(500, jsonError(err))
+		AbortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, jsonData(user))
+	c.JSON(http.StatusOK, jsonData(user))
 }
 
-func (r *userRoutes) GetAll(c *gin.Context) {
+func (r *userRoutes) ChangePassword(c *gin.Context) {
 
-	user, err := r.userService.GetAll(
+	var passwordData PasswordChangeData
+
+	if err := c.ShouldBind(&passwordData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	user, err := r.userService.ChangePassword(c, passwordData.CurrentPassword, passwordData.NewPassword)
+	if err != nil {
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
+
+func (r *userRoutes) Update(c *gin.Context) {
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	var userData UserUpdateData
+
+	if err := c.ShouldBind(&userData); err != nil {
+		AbortWithError(c, bindError(err))
+		return
+	}
+
+	user, err := r.userService.Update(
 		c,
+		userId,
+		userData.Name,
+		userData.Email,
+		userData.Active,
 	)
 	if err != nil {
-		c.AbortWithStatusJSON(400, jsonError(err))
+		AbortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jsonData(user))
+}
+
+func (r *userRoutes) Deactivate(c *gin.Context) {
+
+	userId := c.Param("userId")
+	if userId == "" {
+		AbortWithError(c, missing("userId"))
+		return
+	}
+
+	if err := r.userService.Deactivate(c, userId); err != nil {
+		AbortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, jsonData(user))
+	c.JSON(http.StatusOK, jsonData(true))
 }
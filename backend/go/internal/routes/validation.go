@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"cerberus-examples/internal/services"
+	"cerberus-examples/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Limits of passwords. Bcrypt ignores what comes after 72 bytes.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// The rules that request data can have in its binding tags, besides the ones
+// of the validator
+var rules = map[string]validator.Func{
+	"notblank":   notBlank,
+	"password":   strongPassword,
+	"estimation": estimation,
+}
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// Violations name fields as requests do
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	for tag, rule := range rules {
+		utils.PanicOnError(engine.RegisterValidation(tag, rule))
+	}
+}
+
+// bindError is the error of a request whose data can't be bound: a
+// validation error that lists the violated rules or a field of the wrong
+// type, or a bad request for data that can't be read
+func bindError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return utils.NewViolationsError([]utils.Violation{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "has the wrong type",
+		}})
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return utils.NewDomainError(http.StatusBadRequest, err.Error(), nil)
+	}
+
+	violations := make([]utils.Violation, 0, len(validationErrs))
+	for _, fieldErr := range validationErrs {
+		violations = append(violations, utils.Violation{
+			Field:   violationField(fieldErr),
+			Rule:    fieldErr.Tag(),
+			Message: violationMessage(fieldErr),
+		})
+	}
+	return utils.NewViolationsError(violations)
+}
+
+// missing is the error of a request without a required value
+func missing(field string) error {
+	return utils.NewViolationsError([]utils.Violation{{
+		Field:   field,
+		Rule:    "required",
+		Message: "is required",
+	}})
+}
+
+// violationField names the field as requests do, with the path to it in the
+// data, as in states[0].name
+func violationField(fieldErr validator.FieldError) string {
+	namespace := fieldErr.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fieldErr.Field()
+}
+
+func violationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "email":
+		return "must be an email address"
+	case "max":
+		return fmt.Sprintf("must have at most %s characters", fieldErr.Param())
+	case "password":
+		return fmt.Sprintf("must have %d to %d characters, with a letter and a digit", minPasswordLength, maxPasswordLength)
+	case "estimation":
+		return fmt.Sprintf("must be one of %v", services.EstimationScale)
+	default:
+		return "is invalid"
+	}
+}
+
+func notBlank(fl validator.FieldLevel) bool {
+	return strings.TrimSpace(fl.Field().String()) != ""
+}
+
+func strongPassword(fl validator.FieldLevel) bool {
+	password := fl.Field().String()
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return false
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		letter = letter || unicode.IsLetter(r)
+		digit = digit || unicode.IsDigit(r)
+	}
+	return letter && digit
+}
+
+// estimation accepts numbers on the estimation scale, which are given as
+// JSON numbers or strings
+func estimation(fl validator.FieldLevel) bool {
+	number, ok := fl.Field().Interface().(json.Number)
+	if !ok {
+		return false
+	}
+	points, err := number.Int64()
+	return err == nil && services.ValidEstimation(int(points))
+}
@@ -0,0 +1,47 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Filter is an equality filter like `userName eq "jane@example.com"`, which
+// is what identity providers use to look up resources before creating them.
+// An empty filter matches everything.
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+var filterPattern = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+func ParseFilter(filter string) (Filter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return Filter{}, nil
+	}
+
+	match := filterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return Filter{}, NewError(http.StatusBadRequest, ErrorInvalidFilter, "only filters like 'attribute eq \"value\"' are supported")
+	}
+
+	var value string
+	if err := json.Unmarshal([]byte(match[2]), &value); err != nil {
+		return Filter{}, NewError(http.StatusBadRequest, ErrorInvalidFilter, "invalid filter value")
+	}
+
+	return Filter{Attribute: match[1], Value: value}, nil
+}
+
+// Is reports whether the filter is on the attribute, which are case
+// insensitive
+func (f Filter) Is(attribute string) bool {
+	return strings.EqualFold(f.Attribute, attribute)
+}
+
+// Empty reports whether the filter matches everything
+func (f Filter) Empty() bool {
+	return f.Attribute == ""
+}
@@ -0,0 +1,82 @@
+package scim
+
+import (
+	"cerberus-examples/internal/utils"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected Filter
+	}{
+		{``, Filter{}},
+		{`   `, Filter{}},
+		{`userName eq "jane@example.com"`, Filter{Attribute: "userName", Value: "jane@example.com"}},
+		{`  userName   EQ   "jane@example.com"  `, Filter{Attribute: "userName", Value: "jane@example.com"}},
+		{`externalId eq ""`, Filter{Attribute: "externalId", Value: ""}},
+		{`emails.value eq "jane@example.com"`, Filter{Attribute: "emails.value", Value: "jane@example.com"}},
+		{`displayName eq "Jane \"JJ\" Doe"`, Filter{Attribute: "displayName", Value: `Jane "JJ" Doe`}},
+		{`displayName eq "back\\slash"`, Filter{Attribute: "displayName", Value: `back\slash`}},
+		{`displayName eq "café"`, Filter{Attribute: "displayName", Value: "café"}},
+		{`displayName eq "a or b eq c"`, Filter{Attribute: "displayName", Value: "a or b eq c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			filter, err := ParseFilter(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filter != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, filter)
+			}
+		})
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	tests := []string{
+		`userName`,
+		`userName eq`,
+		`userName eq jane@example.com`,
+		`userName eq 'jane@example.com'`,
+		`userName eq "jane@example.com`,
+		`userName eq "jane"doe"`,
+		`userName ne "jane@example.com"`,
+		`userName co "jane"`,
+		`userName pr`,
+		`userName eq "jane" and active eq "true"`,
+		`userName eq "jane" or userName eq "joe"`,
+		`not (userName eq "jane")`,
+		`1userName eq "jane"`,
+		`user name eq "jane"`,
+		`userName eq "bad \x escape"`,
+	}
+	for _, filter := range tests {
+		t.Run(filter, func(t *testing.T) {
+			_, err := ParseFilter(filter)
+			var domainErr *utils.DomainError
+			if !errors.As(err, &domainErr) {
+				t.Fatalf("expected a domain error, got %v", err)
+			}
+			if domainErr.StatusCode() != http.StatusBadRequest || domainErr.Details()["scimType"] != ErrorInvalidFilter {
+				t.Errorf("expected an invalid filter error, got %d %v", domainErr.StatusCode(), domainErr.Details())
+			}
+		})
+	}
+}
+
+func TestFilterIs(t *testing.T) {
+	filter := Filter{Attribute: "USERNAME", Value: "jane@example.com"}
+	if !filter.Is("userName") {
+		t.Error("expected attributes to be compared without case")
+	}
+	if filter.Is("externalId") {
+		t.Error("expected the filter not to be on another attribute")
+	}
+	if filter.Empty() || !(Filter{}).Empty() {
+		t.Error("expected only a filter without attribute to be empty")
+	}
+}
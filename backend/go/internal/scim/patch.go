@@ -0,0 +1,251 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type PatchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []Operation `json:"Operations"`
+}
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+var memberPathPattern = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+
+// ApplyUserPatch applies the operations of a patch to a user. Email
+// addresses and extension attributes are ignored, as the userName is the
+// email address of a user. A changed name also changes the display name,
+// unless the display name itself was changed.
+func ApplyUserPatch(user *User, patch PatchOp) error {
+	displayName, name := user.DisplayName, user.Name.Display()
+
+	for _, op := range patch.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path != "" {
+				if err := setUserAttribute(user, op.Path, op.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return invalidValue("value must be an object when there is no path")
+			}
+			for attribute, value := range values {
+				if err := setUserAttribute(user, attribute, value); err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if err := removeUserAttribute(user, op.Path); err != nil {
+				return err
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "unsupported operation "+op.Op)
+		}
+	}
+
+	if user.DisplayName == displayName && user.Name.Display() != name {
+		user.DisplayName = user.Name.Display()
+	}
+	return nil
+}
+
+func setUserAttribute(user *User, path string, value json.RawMessage) error {
+	attribute := strings.ToLower(path)
+	if strings.HasPrefix(attribute, "emails") || strings.HasPrefix(attribute, "urn:") {
+		return nil
+	}
+
+	switch attribute {
+	case "active":
+		active, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+		return nil
+	case "name":
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return invalidValue("name must be an object")
+		}
+		user.Name = &name
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return invalidValue(path + " must be a string")
+	}
+	switch attribute {
+	case "username":
+		user.UserName = s
+	case "displayname":
+		user.DisplayName = s
+	case "externalid":
+		user.ExternalId = s
+	case "name.formatted", "name.givenname", "name.familyname":
+		setNamePart(user, attribute, s)
+	default:
+		return NewError(http.StatusBadRequest, ErrorInvalidPath, "unsupported path "+path)
+	}
+	return nil
+}
+
+func removeUserAttribute(user *User, path string) error {
+	attribute := strings.ToLower(path)
+	if strings.HasPrefix(attribute, "emails") || strings.HasPrefix(attribute, "urn:") {
+		return nil
+	}
+
+	switch attribute {
+	case "externalid":
+		user.ExternalId = ""
+	case "displayname":
+		user.DisplayName = ""
+	case "name.formatted", "name.givenname", "name.familyname":
+		setNamePart(user, attribute, "")
+	case "":
+		return NewError(http.StatusBadRequest, ErrorNoTarget, "remove needs a path")
+	default:
+		return NewError(http.StatusBadRequest, ErrorMutability, path+" cannot be removed")
+	}
+	return nil
+}
+
+// setNamePart changes a part of the name. Changing the given or family name
+// composes the name of its parts.
+func setNamePart(user *User, attribute, value string) {
+	if user.Name == nil {
+		user.Name = &Name{}
+	}
+	switch attribute {
+	case "name.formatted":
+		user.Name.Formatted = value
+	case "name.givenname":
+		user.Name.GivenName = value
+		user.Name.Formatted = ""
+	case "name.familyname":
+		user.Name.FamilyName = value
+		user.Name.Formatted = ""
+	}
+}
+
+// ApplyGroupPatch applies the operations of a patch to the display name and
+// the members of a group
+func ApplyGroupPatch(group *Group, patch PatchOp) error {
+	for _, op := range patch.Operations {
+		opName := strings.ToLower(op.Op)
+		path := strings.ToLower(op.Path)
+
+		switch {
+		case (opName == "add" || opName == "replace") && path == "":
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return invalidValue("value must be an object when there is no path")
+			}
+			for attribute, value := range values {
+				if err := setGroupAttribute(group, opName, attribute, value); err != nil {
+					return err
+				}
+			}
+		case opName == "add" || opName == "replace":
+			if err := setGroupAttribute(group, opName, op.Path, op.Value); err != nil {
+				return err
+			}
+		case opName == "remove" && path == "members":
+			if len(op.Value) == 0 || string(op.Value) == "null" {
+				group.Members = []Reference{}
+				continue
+			}
+			var members []Reference
+			if err := json.Unmarshal(op.Value, &members); err != nil {
+				return invalidValue("members must be a list")
+			}
+			for _, member := range members {
+				group.Members = removeMember(group.Members, member.Value)
+			}
+		case opName == "remove" && memberPathPattern.MatchString(op.Path):
+			var memberId string
+			if err := json.Unmarshal([]byte(memberPathPattern.FindStringSubmatch(op.Path)[1]), &memberId); err != nil {
+				return NewError(http.StatusBadRequest, ErrorInvalidPath, "invalid member filter")
+			}
+			group.Members = removeMember(group.Members, memberId)
+		case opName == "remove":
+			return NewError(http.StatusBadRequest, ErrorMutability, op.Path+" cannot be removed")
+		default:
+			return NewError(http.StatusBadRequest, ErrorInvalidSyntax, "unsupported operation "+op.Op)
+		}
+	}
+	return nil
+}
+
+func setGroupAttribute(group *Group, op, path string, value json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "displayname":
+		var displayName string
+		if err := json.Unmarshal(value, &displayName); err != nil {
+			return invalidValue("displayName must be a string")
+		}
+		group.DisplayName = displayName
+	case "members":
+		var members []Reference
+		if err := json.Unmarshal(value, &members); err != nil {
+			return invalidValue("members must be a list")
+		}
+		if op == "replace" {
+			group.Members = []Reference{}
+		}
+		for _, member := range members {
+			group.Members = append(removeMember(group.Members, member.Value), Reference{Value: member.Value})
+		}
+	case "externalid":
+		// Groups are roles of the account, which are only known by their id
+	default:
+		return NewError(http.StatusBadRequest, ErrorInvalidPath, "unsupported path "+path)
+	}
+	return nil
+}
+
+func removeMember(members []Reference, memberId string) []Reference {
+	kept := make([]Reference, 0, len(members))
+	for _, member := range members {
+		if member.Value != memberId {
+			kept = append(kept, member)
+		}
+	}
+	return kept
+}
+
+// parseBool accepts booleans as well as the strings "True" and "False" that
+// some identity providers send
+func parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, invalidValue("active must be a boolean")
+}
+
+func invalidValue(detail string) error {
+	return NewError(http.StatusBadRequest, ErrorInvalidValue, detail)
+}
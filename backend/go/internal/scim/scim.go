@@ -0,0 +1,159 @@
+// Package scim holds the resources and messages of SCIM 2.0 (RFC 7643 and
+// RFC 7644) that identity providers use to provision users and groups.
+package scim
+
+import (
+	"cerberus-examples/internal/utils"
+	"strconv"
+)
+
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaConfig       = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	ContentType = "application/scim+json"
+
+	// MaxResults is the largest page of a list
+	MaxResults = 200
+)
+
+// Error types of RFC 7644 section 3.12
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorNoTarget      = "noTarget"
+)
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Display returns the formatted name, or the name composed of its parts
+func (n *Name) Display() string {
+	if n == nil {
+		return ""
+	}
+	if n.Formatted != "" {
+		return n.Formatted
+	}
+	if n.GivenName == "" || n.FamilyName == "" {
+		return n.GivenName + n.FamilyName
+	}
+	return n.GivenName + " " + n.FamilyName
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Reference points to another resource, like the members of a group or the
+// groups of a user
+type Reference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+type User struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	ExternalId  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *Name       `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []Email     `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Groups      []Reference `json:"groups,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// FullName returns the display name, or else the name, of the user
+func (u User) FullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Name.Display()
+}
+
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// NewError returns a domain error that carries the SCIM error type in its
+// details
+func NewError(status int, scimType, detail string) error {
+	var details map[string]interface{}
+	if scimType != "" {
+		details = map[string]interface{}{"scimType": scimType}
+	}
+	return utils.NewDomainError(status, detail, details)
+}
+
+func NewErrorResponse(status int, scimType, detail string) ErrorResponse {
+	return ErrorResponse{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// Page returns the bounds of the page of a list with the total number of
+// results, for the 1-based start index and count of a request
+func Page(total, startIndex, count int) (from, to int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxResults {
+		count = MaxResults
+	}
+	from = startIndex - 1
+	if from > total {
+		from = total
+	}
+	to = from + count
+	if to > total {
+		to = total
+	}
+	return from, to
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// TestAccountIsolation checks that the projects, sprints, stories and users
+// of an account are not found by the users of another account, whatever
+// their role there
+func TestAccountIsolation(t *testing.T) {
+	app := newTestApp(t, context.Background())
+
+	owner := app.register("owner@example.com")
+	other := app.register("other@example.com")
+
+	var project, sprint, story struct {
+		Id string `json:"id"`
+	}
+	app.expect(app.request(http.MethodPost, "/api/accounts/"+owner.accountId+"/projects", owner.token,
+		map[string]string{"name": "Project"}), http.StatusCreated, &project)
+	app.expect(app.request(http.MethodPost, "/api/projects/"+project.Id+"/sprints", owner.token,
+		map[string]string{"goal": "Goal"}), http.StatusCreated, &sprint)
+	app.expect(app.request(http.MethodPost, "/api/sprints/"+sprint.Id+"/stories", owner.token,
+		map[string]string{"description": "Story"}), http.StatusCreated, &story)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"get project", http.MethodGet, "/api/projects/" + project.Id, nil},
+		{"get workflow", http.MethodGet, "/api/projects/" + project.Id + "/workflow", nil},
+		{"delete project", http.MethodDelete, "/api/projects/" + project.Id, nil},
+		{"list sprints", http.MethodGet, "/api/projects/" + project.Id + "/sprints", nil},
+		{"create sprint", http.MethodPost, "/api/projects/" + project.Id + "/sprints", map[string]string{"goal": "Goal"}},
+		{"get sprint", http.MethodGet, "/api/sprints/" + sprint.Id, nil},
+		{"start sprint", http.MethodPost, "/api/sprints/" + sprint.Id + "/start", nil},
+		{"list stories", http.MethodGet, "/api/sprints/" + sprint.Id + "/stories", nil},
+		{"create story", http.MethodPost, "/api/sprints/" + sprint.Id + "/stories", map[string]string{"description": "Story"}},
+		{"get story", http.MethodGet, "/api/stories/" + story.Id, nil},
+		{"estimate story", http.MethodPost, "/api/stories/" + story.Id + "/estimate", map[string]int{"estimation": 3}},
+		{"assign story", http.MethodPost, "/api/stories/" + story.Id + "/assign", map[string]string{"userId": other.id}},
+		{"update user", http.MethodPatch, "/api/users/" + owner.id, map[string]string{"name": "Renamed"}},
+		{"deactivate user", http.MethodDelete, "/api/users/" + owner.id, nil},
+		{"change user role", http.MethodPost, "/api/users/" + owner.id + "/role", map[string]string{"roleId": "member"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := app.request(test.method, test.path, other.token, test.body)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("expected status %d, got %d: %s", http.StatusNotFound, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// The owner still finds everything
+	app.expect(app.request(http.MethodGet, "/api/projects/"+project.Id, owner.token, nil), http.StatusOK, nil)
+	app.expect(app.request(http.MethodGet, "/api/sprints/"+sprint.Id, owner.token, nil), http.StatusOK, nil)
+	app.expect(app.request(http.MethodGet, "/api/stories/"+story.Id, owner.token, nil), http.StatusOK, nil)
+
+	var users []struct {
+		Id string `json:"id"`
+	}
+	app.expect(app.request(http.MethodGet, "/api/users", other.token, nil), http.StatusOK, &users)
+	for _, user := range users {
+		if user.Id == owner.id {
+			t.Errorf("users of account %s list the user %s of another account", other.accountId, owner.id)
+		}
+	}
+
+	var projects []struct {
+		Id string `json:"id"`
+	}
+	app.expect(app.request(http.MethodGet, "/api/accounts/"+other.accountId+"/projects", other.token, nil),
+		http.StatusOK, &projects)
+	if projects == nil || len(projects) != 0 {
+		t.Errorf("expected an empty list of the projects of account %s, got %v", other.accountId, projects)
+	}
+}
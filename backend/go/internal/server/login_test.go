@@ -0,0 +1,96 @@
+package server
+
+import (
+	"cerberus-examples/internal/services/totp"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// login logs in with the password, from the client ip that the test requests
+// come from, and with the X-Forwarded-For header when one is given
+func (a *testApp) login(email, password, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	req.SetBasicAuth(email, password)
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestLoginIpLockoutWithForwardedFor checks that a client can't spread its
+// failed logins over ips that it names in the X-Forwarded-For header, as no
+// proxy is trusted by default
+func TestLoginIpLockoutWithForwardedFor(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	app.register("user@example.com")
+
+	// Every email fails less often than locks it out, but the ip of the
+	// client fails as often as locks it out
+	for i := 0; i < 50; i++ {
+		rec := app.login(fmt.Sprintf("user%d@example.com", i), "wrong", fmt.Sprintf("203.0.113.%d", i))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusUnauthorized, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := app.login("user@example.com", "Passw0rd1", "203.0.113.200")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the ip of the client to be locked out, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestMfaLockout checks that wrong codes count as failed logins, so that a
+// caller who knows the password can't guess codes over new challenges
+func TestMfaLockout(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	user := app.register("user@example.com")
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	app.expect(app.request(http.MethodPost, "/api/users/me/mfa", user.token, nil), http.StatusCreated, &enrollment)
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.expect(app.request(http.MethodPost, "/api/users/me/mfa/confirm", user.token, gin.H{"code": code}), http.StatusOK, nil)
+
+	// A code of a step long past is never accepted
+	wrongCode, err := totp.Code(enrollment.Secret, totp.Step(time.Now())-100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var challenge struct {
+		MfaToken string `json:"mfaToken"`
+	}
+	for i := 0; i < 5; i++ {
+		app.expect(app.login("user@example.com", "Passw0rd1", ""), http.StatusCreated, &challenge)
+		app.expect(app.request(http.MethodPost, "/auth/mfa/verify", "", gin.H{
+			"mfaToken": challenge.MfaToken,
+			"code":     wrongCode,
+		}), http.StatusUnauthorized, nil)
+	}
+
+	rec := app.login("user@example.com", "Passw0rd1", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the email to be locked out, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Nor does a right code complete a login that was started before
+	code, err = totp.Code(enrollment.Secret, totp.Step(time.Now())+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = app.request(http.MethodPost, "/auth/mfa/verify", "", gin.H{"mfaToken": challenge.MfaToken, "code": code})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the email to be locked out, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
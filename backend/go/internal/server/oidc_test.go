@@ -0,0 +1,112 @@
+package server
+
+import (
+	"cerberus-examples/internal/services/oidc/oidctest"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// startOidcLogin starts a login at the identity provider, and returns the
+// state cookie that the browser keeps and the url that the provider sends
+// the browser back to once the user logged in there
+func (a *testApp) startOidcLogin(email string) (*http.Cookie, string) {
+	a.t.Helper()
+
+	rec := a.request(http.MethodGet, "/auth/oidc/login", "", nil)
+	if rec.Code != http.StatusFound {
+		a.t.Fatalf("expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "oidc_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 {
+		a.t.Fatalf("expected an http-only, lax state cookie, got %v", cookie)
+	}
+
+	callback, err := a.oidc.Authorize(rec.Header().Get("Location"), oidctest.User{
+		Subject:       "subject",
+		Email:         email,
+		EmailVerified: true,
+		Name:          "User",
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	u, err := url.Parse(callback)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return cookie, u.RequestURI()
+}
+
+// completeOidcLogin follows the redirect back from the identity provider in
+// a browser with the cookie
+func (a *testApp) completeOidcLogin(callback string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOidcLogin(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	registered := app.register("user@example.com")
+
+	cookie, callback := app.startOidcLogin("USER@example.com")
+
+	var user struct {
+		Id    string `json:"id"`
+		Token string `json:"token"`
+	}
+	app.expect(app.completeOidcLogin(callback, cookie), http.StatusOK, &user)
+	if user.Id != registered.id || user.Token == "" {
+		t.Errorf("expected a token of user %s, got %+v", registered.id, user)
+	}
+
+	// A login is completed once
+	rec := app.completeOidcLogin(callback, cookie)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+	}
+}
+
+// TestOidcLoginOfOtherBrowser checks that a browser can't be made to complete
+// a login that another browser started, as an attacker would to log a victim
+// in to the attacker's account
+func TestOidcLoginOfOtherBrowser(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	app.register("attacker@example.com")
+
+	_, attackerCallback := app.startOidcLogin("attacker@example.com")
+	victimCookie, victimCallback := app.startOidcLogin("attacker@example.com")
+
+	tests := []struct {
+		name     string
+		callback string
+		cookie   *http.Cookie
+	}{
+		{"without state cookie", attackerCallback, nil},
+		{"with state cookie of another login", attackerCallback, victimCookie},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := app.completeOidcLogin(test.callback, test.cookie)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("expected status %d, got %d: %s", http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	// The browser that started a login still completes it
+	app.expect(app.completeOidcLogin(victimCallback, victimCookie), http.StatusOK, nil)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"cerberus-examples/internal/scim"
+	"cerberus-examples/internal/services"
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// apiKey creates a personal access token of the user with the scopes
+func (a *testApp) apiKey(user testUser, scopes ...string) string {
+	a.t.Helper()
+
+	var key struct {
+		Token string `json:"token"`
+	}
+	a.expect(a.request(http.MethodPost, "/api/users/me/tokens", user.token, gin.H{
+		"name":   "scim",
+		"scopes": scopes,
+	}), http.StatusCreated, &key)
+	return key.Token
+}
+
+// scimRequest makes a SCIM request with the api key
+func (a *testApp) scimRequest(method, path, key string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", scim.ContentType)
+	req.Header.Set("Authorization", "Bearer "+key)
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestScimGroupsRequireRoleWrite checks that an api key that may only write
+// users can't create, rename or delete the roles that groups stand for
+func TestScimGroupsRequireRoleWrite(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	user := app.register("admin@example.com")
+	userKey := app.apiKey(user, services.PermissionUserWrite)
+	roleKey := app.apiKey(user, services.PermissionUserWrite, services.PermissionRoleWrite)
+
+	group := scim.Group{Schemas: []string{scim.SchemaGroup}, DisplayName: "Engineers"}
+
+	rec := app.scimRequest(http.MethodPost, "/scim/v2/Groups", userKey, group)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+	}
+
+	rec = app.scimRequest(http.MethodPost, "/scim/v2/Groups", roleKey, group)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+
+	// Groups are still listed with the permission to write users
+	rec = app.scimRequest(http.MethodGet, "/scim/v2/Groups/"+group.Id, userKey, nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	renamed := scim.Group{Schemas: []string{scim.SchemaGroup}, DisplayName: "Developers"}
+	tests := []struct {
+		name   string
+		method string
+		body   interface{}
+	}{
+		{"rename", http.MethodPut, renamed},
+		{"patch", http.MethodPatch, scim.PatchOp{
+			Schemas:    []string{scim.SchemaPatchOp},
+			Operations: []scim.Operation{{Op: "replace", Path: "displayName", Value: json.RawMessage(`"Developers"`)}},
+		}},
+		{"delete", http.MethodDelete, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := app.scimRequest(test.method, "/scim/v2/Groups/"+group.Id, userKey, test.body)
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("expected status %d, got %d: %s", http.StatusForbidden, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	rec = app.scimRequest(http.MethodDelete, "/scim/v2/Groups/"+group.Id, roleKey, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+}
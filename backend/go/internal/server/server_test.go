@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bytes"
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/health"
+	"cerberus-examples/internal/mail"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/routes"
+	"cerberus-examples/internal/services"
+	"cerberus-examples/internal/services/jwtutils"
+	"cerberus-examples/internal/services/oidc"
+	"cerberus-examples/internal/services/oidc/oidctest"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+// testApp is the application wired as the api command does, on a database of
+// its own and with a stand-in identity provider
+type testApp struct {
+	t        *testing.T
+	db       *sql.DB
+	userRepo repositories.UserRepo
+	oidc     *oidctest.Provider
+	server   *webServer
+	handler  http.Handler
+}
+
+// discardMailer drops the mail that the services send
+type discardMailer struct{}
+
+func (discardMailer) Send(ctx context.Context, message mail.Message) error {
+	return nil
+}
+
+func newTestApp(t *testing.T, ctx context.Context) *testApp {
+	t.Helper()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrateTestDB(t, db, "../../migrations", sqlite3.DefaultMigrationsTable)
+	migrateTestDB(t, db, "../../authz_migrations", "authz_schema_migrations")
+
+	keys, err := jwtutils.LoadKeySet(nil, jwtutils.AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authzClient := authz.NewLocalClient(db)
+	txProvider := database.NewTxProvider(db)
+	mailer := discardMailer{}
+	appUrl := "http://localhost"
+
+	userRepo := repositories.NewUserRepo(db)
+	accountRepo := repositories.NewAccountRepo(db)
+	roleRepo := repositories.NewRoleRepo(db)
+	projectRepo := repositories.NewProjectRepo(db)
+	sprintRepo := repositories.NewSprintRepo(db)
+	storyRepo := repositories.NewStoryRepo(db)
+	workflowRepo := repositories.NewWorkflowRepo(db)
+	ownershipRepo := repositories.NewOwnershipRepo(db)
+	projectMemberRepo := repositories.NewProjectMemberRepo(db)
+	sessionRepo := repositories.NewSessionRepo(db)
+	auditRepo := repositories.NewAuditRepo(db)
+	mfaRepo := repositories.NewMfaRepo(db)
+
+	sessionService := services.NewSessionService(sessionRepo, userRepo, accountRepo, mfaRepo, keys, 15*time.Minute, time.Hour)
+	loginGuardService := services.NewLoginGuardService(repositories.NewLoginAttemptRepo(db), userRepo, auditRepo, ownershipRepo,
+		services.LoginLimits{MaxFailures: 5, MaxIpFailures: 50, Window: time.Minute, Lockout: time.Minute})
+	mfaService := services.NewMfaService(mfaRepo, userRepo, accountRepo, sessionService, loginGuardService, "test")
+	verificationService := services.NewEmailVerificationService(txProvider, repositories.NewEmailVerificationRepo(db), userRepo,
+		sessionService, mailer, appUrl, time.Hour)
+	userService := services.NewUserService(txProvider, userRepo, accountRepo, roleRepo, authzClient, mfaService,
+		verificationService, loginGuardService, sessionService, 4)
+	roleService := services.NewRoleService(txProvider, roleRepo, authzClient)
+	if err = roleService.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	passwordResetService := services.NewPasswordResetService(txProvider, repositories.NewPasswordResetRepo(db), userRepo,
+		sessionService, mailer, appUrl, time.Hour)
+	inviteService := services.NewInviteService(txProvider, repositories.NewInviteRepo(db), userRepo, roleRepo, authzClient,
+		mfaService, mailer, appUrl, time.Hour)
+	apiKeyService := services.NewApiKeyService(txProvider, repositories.NewApiKeyRepo(db), userRepo, roleRepo, authzClient)
+	impersonationService := services.NewImpersonationService(userRepo, auditRepo, sessionService, nil, time.Minute)
+
+	identityProvider := oidctest.NewProvider("client", "secret")
+	t.Cleanup(identityProvider.Close)
+	oidcService := services.NewOidcService(txProvider, repositories.NewOidcLoginRepo(db), oidc.NewProvider(oidc.Config{
+		Issuer:       identityProvider.Issuer(),
+		ClientId:     identityProvider.ClientId,
+		ClientSecret: identityProvider.ClientSecret,
+		RedirectUrl:  appUrl + "/auth/oidc/callback",
+	}), userRepo, roleRepo, authzClient, mfaService, services.OidcProvisioning{})
+
+	publicRoutes := []routes.Routable{
+		routes.NewAuthRoutes(userService, sessionService, passwordResetService, verificationService, inviteService, mfaService),
+		routes.NewOidcRoutes(oidcService),
+	}
+	privateRoutes := []routes.Routable{
+		routes.NewUserRoutes(userService, loginGuardService),
+		routes.NewMfaRoutes(mfaService),
+		routes.NewTokenRoutes(apiKeyService),
+		routes.NewAccountRoutes(services.NewAccountService(accountRepo, userRepo, roleRepo, projectRepo, sprintRepo, storyRepo,
+			auditRepo, sessionService, authzClient, ownershipRepo)),
+		routes.NewProjectRoutes(services.NewProjectService(txProvider, projectRepo, projectMemberRepo, accountRepo, workflowRepo,
+			ownershipRepo, authzClient)),
+		routes.NewProjectMemberRoutes(services.NewProjectMemberService(projectMemberRepo, roleRepo, ownershipRepo, authzClient)),
+		routes.NewSprintRoutes(services.NewSprintService(txProvider, sprintRepo, ownershipRepo, authzClient)),
+		routes.NewStoryRoutes(services.NewStoryService(txProvider, storyRepo, workflowRepo, ownershipRepo, authzClient)),
+	}
+	scimRoutes := []routes.Routable{
+		routes.NewScimRoutes(services.NewScimService(txProvider, userRepo, roleRepo, authzClient, userService, roleService,
+			sessionService)),
+	}
+
+	server := NewWebServer(ctx, "0", 0, time.Second, nil, keys, roleService, sessionService, apiKeyService, impersonationService,
+		[]health.Checker{health.NewDatabaseChecker(db)}, publicRoutes, privateRoutes, scimRoutes).(*webServer)
+	handler, err := server.router()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testApp{
+		t:        t,
+		db:       db,
+		userRepo: userRepo,
+		oidc:     identityProvider,
+		server:   server,
+		handler:  handler,
+	}
+}
+
+func migrateTestDB(t *testing.T, db *sql.DB, dir, migrationsTable string) {
+	t.Helper()
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := sqlite3.WithInstance(db, &sqlite3.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+dir, "sqlite3", driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatal(err)
+	}
+}
+
+// testUser is a user that registered its own account and logged in
+type testUser struct {
+	id        string
+	accountId string
+	token     string
+}
+
+// register registers a user with an account of its own, verifies its email
+// address and logs it in
+func (a *testApp) register(email string) testUser {
+	a.t.Helper()
+
+	var registered struct {
+		Id string `json:"id"`
+	}
+	a.expect(a.request(http.MethodPost, "/auth/register", "", gin.H{
+		"email":    email,
+		"password": "Passw0rd1",
+		"name":     email,
+	}), http.StatusCreated, &registered)
+	if err := a.userRepo.MarkEmailVerified(context.Background(), registered.Id, nil); err != nil {
+		a.t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	req.SetBasicAuth(email, "Passw0rd1")
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+
+	var user struct {
+		Id        string `json:"id"`
+		AccountId string `json:"accountId"`
+		Token     string `json:"token"`
+	}
+	a.expect(rec, http.StatusCreated, &user)
+	return testUser{id: user.Id, accountId: user.AccountId, token: user.Token}
+}
+
+// request answers a request with the JSON body, made with the access token
+// when one is given
+func (a *testApp) request(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	a.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect fails the test unless the response has the status, and reads the
+// data of the response into data when it is given
+func (a *testApp) expect(rec *httptest.ResponseRecorder, status int, data interface{}) {
+	a.t.Helper()
+
+	if rec.Code != status {
+		a.t.Fatalf("expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+	}
+	if data == nil {
+		return
+	}
+	envelope := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		a.t.Fatal(err)
+	}
+	if err := json.Unmarshal(envelope.Data, data); err != nil {
+		a.t.Fatal(fmt.Errorf("reading %s: %w", envelope.Data, err))
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestUnassignStory(t *testing.T) {
+	app := newTestApp(t, context.Background())
+	owner := app.register("owner@example.com")
+
+	var project, sprint struct {
+		Id string `json:"id"`
+	}
+	var story struct {
+		Id       string `json:"id"`
+		Assignee string `json:"assignee"`
+	}
+	app.expect(app.request(http.MethodPost, "/api/accounts/"+owner.accountId+"/projects", owner.token,
+		map[string]string{"name": "Project"}), http.StatusCreated, &project)
+	app.expect(app.request(http.MethodPost, "/api/projects/"+project.Id+"/sprints", owner.token,
+		map[string]string{"goal": "Goal"}), http.StatusCreated, &sprint)
+	app.expect(app.request(http.MethodPost, "/api/sprints/"+sprint.Id+"/stories", owner.token,
+		map[string]string{"description": "Story"}), http.StatusCreated, &story)
+
+	app.expect(app.request(http.MethodPost, "/api/stories/"+story.Id+"/assign", owner.token,
+		map[string]string{"userId": owner.id}), http.StatusOK, &story)
+	if story.Assignee != owner.id {
+		t.Fatalf("expected the story to be assigned to %s, got %q", owner.id, story.Assignee)
+	}
+
+	app.expect(app.request(http.MethodPost, "/api/stories/"+story.Id+"/assign", owner.token,
+		map[string]string{"userId": ""}), http.StatusOK, &story)
+	if story.Assignee != "" {
+		t.Errorf("expected the story to be unassigned, got %q", story.Assignee)
+	}
+
+	app.expect(app.request(http.MethodGet, "/api/stories/"+story.Id, owner.token, nil), http.StatusOK, &story)
+	if story.Assignee != "" {
+		t.Errorf("expected the story to stay unassigned, got %q", story.Assignee)
+	}
+}
@@ -1,16 +1,46 @@
 package server
 
 import (
+	"cerberus-examples/internal/health"
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/routes"
+	"cerberus-examples/internal/scim"
+	"cerberus-examples/internal/services"
 	"cerberus-examples/internal/services/jwtutils"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
-	"log"
+	"github.com/google/uuid"
+	"net"
 	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
 	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout limits the time that the dependency checks of a readiness
+// probe take
+const readinessTimeout = 2 * time.Second
+
+// requestIdHeader carries the id of a request, which is taken from the caller
+// when it is valid so that a request can be followed across services
+const requestIdHeader = "X-Request-ID"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// The errors of callers that aren't authenticated, or lack a permission
+var (
+	errUnauthorized = utils.NewUnauthorizedError("unauthorized")
+	errForbidden    = utils.NewForbiddenError("forbidden")
 )
 
 type WebServer interface {
@@ -18,30 +48,64 @@ type WebServer interface {
 }
 
 type webServer struct {
-	context       context.Context
-	port          string
-	jwtSecret     string
-	publicRoutes  []routes.Routable
-	privateRoutes []routes.Routable
+	context              context.Context
+	port                 string
+	drainDelay           time.Duration
+	shutdownTimeout      time.Duration
+	trustedProxies       []string
+	keys                 *jwtutils.KeySet
+	roleService          services.RoleService
+	sessionService       services.SessionService
+	apiKeyService        services.ApiKeyService
+	impersonationService services.ImpersonationService
+	checkers             []health.Checker
+	publicRoutes         []routes.Routable
+	privateRoutes        []routes.Routable
+	scimRoutes           []routes.Routable
+	// draining is set to 1 once the server stops taking new requests
+	draining int32
 }
 
-func NewWebServer(context context.Context, port string, jwtSecret string, publicRoutes []routes.Routable, privateRoutes []routes.Routable) WebServer {
+func NewWebServer(context context.Context, port string, drainDelay, shutdownTimeout time.Duration, trustedProxies []string, keys *jwtutils.KeySet, roleService services.RoleService, sessionService services.SessionService, apiKeyService services.ApiKeyService, impersonationService services.ImpersonationService, checkers []health.Checker, publicRoutes []routes.Routable, privateRoutes []routes.Routable, scimRoutes []routes.Routable) WebServer {
 	return &webServer{
-		context:       context,
-		port:          port,
-		jwtSecret:     jwtSecret,
-		publicRoutes:  publicRoutes,
-		privateRoutes: privateRoutes,
+		context:              context,
+		port:                 port,
+		drainDelay:           drainDelay,
+		shutdownTimeout:      shutdownTimeout,
+		trustedProxies:       trustedProxies,
+		keys:                 keys,
+		roleService:          roleService,
+		sessionService:       sessionService,
+		apiKeyService:        apiKeyService,
+		impersonationService: impersonationService,
+		checkers:             checkers,
+		publicRoutes:         publicRoutes,
+		privateRoutes:        privateRoutes,
+		scimRoutes:           scimRoutes,
 	}
 }
 
-func (s *webServer) Start() {
-	router := gin.Default()
+// router routes the requests to the probes, the public routes, the private
+// routes of the api and the scim routes. The ip of the client is taken from
+// the X-Forwarded-For and X-Real-IP headers only when a trusted proxy sent
+// the request, as clients could otherwise pick the ip they log in from.
+func (s *webServer) router() (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(s.trustedProxies); err != nil {
+		return nil, err
+	}
+	router.Use(s.RequestId, s.Tracing, s.AccessLog, routes.Problems, gin.CustomRecoveryWithWriter(nil, recovered))
 	applyCors(router)
+	router.Use(s.ClientIp, s.Metrics)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	router.GET("/healthz", s.Healthz)
+	router.GET("/readyz", s.Readyz)
 
 	public := router.Group("/")
 	api := router.Group("/api")
-	api.Use(s.JWTAuthRequired)
+	api.Use(s.JWTAuthRequired, s.PermissionRequired)
+	scimApi := router.Group("/scim/v2")
+	scimApi.Use(s.ScimAuthRequired)
 
 	for _, route := range s.publicRoutes {
 		route.RegisterRoutes(public)
@@ -51,85 +115,397 @@ func (s *webServer) Start() {
 		route.RegisterRoutes(api)
 	}
 
-	fmt.Println("Listening on port " + s.port)
+	for _, route := range s.scimRoutes {
+		route.RegisterRoutes(scimApi)
+	}
+
+	return router, nil
+}
+
+// Start serves requests until the context is done. It then reports that it
+// is not ready, keeps serving for the drain delay so that load balancers
+// notice, and drains the requests in flight for at most the shutdown timeout.
+func (s *webServer) Start() {
+	listener, err := net.Listen("tcp", ":"+s.port)
+	if err != nil {
+		logging.Default().Error("listen failed", "port", s.port, "error", err)
+		os.Exit(1)
+	}
+	s.serve(listener)
+}
+
+// serve serves requests on the listener as Start does
+func (s *webServer) serve(listener net.Listener) {
+	logger := logging.Default().With("port", s.port)
+
+	router, err := s.router()
+	if err != nil {
+		logger.Error("invalid trusted proxies", "trustedProxies", s.trustedProxies, "error", err)
+		os.Exit(1)
+	}
+	logger.Info("listening")
 
 	srv := &http.Server{
-		Addr:    ":" + s.port,
 		Handler: router,
 	}
 
 	go func() {
 		// service connections
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("serve failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	select {
-	case <-s.context.Done():
-		log.Println(s.port + " context done.")
+	<-s.context.Done()
+	logger.Info("context done, draining requests", "timeout", (s.drainDelay + s.shutdownTimeout).String())
+	atomic.StoreInt32(&s.draining, 1)
+	time.Sleep(s.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("requests still in flight after the shutdown timeout", "error", err)
+	}
+	logger.Info("server exiting")
+}
+
+// Healthz tells the orchestrator that the process is alive
+func (s *webServer) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Readyz tells the orchestrator whether to send requests, which it should not
+// do while a dependency is down or while the server drains
+func (s *webServer) Readyz(c *gin.Context) {
+	report := health.Run(c, s.checkers, readinessTimeout)
+	if atomic.LoadInt32(&s.draining) == 1 {
+		report.Status = "draining"
+	}
+
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// Metrics records every request by its route template. Requests that match
+// no route share one series.
+func (s *webServer) Metrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
 	}
-	log.Println(s.port + " Server exiting")
+	metrics.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
 }
 
+// RequestId gives every request an id, which is answered in a header and
+// added to the log entries of the request
+func (s *webServer) RequestId(c *gin.Context) {
+	requestId := c.GetHeader(requestIdHeader)
+	if !requestIdPattern.MatchString(requestId) {
+		requestId = uuid.New().String()
+	}
+	c.Set("requestId", requestId)
+	c.Header(requestIdHeader, requestId)
+	c.Next()
+}
+
+// Tracing starts the span of every request but the probes, which continues
+// the trace of the caller and is the parent of the spans of the services and
+// statements that answer the request
+func (s *webServer) Tracing(c *gin.Context) {
+	route := c.FullPath()
+	switch route {
+	case "/healthz", "/readyz", "/metrics":
+		c.Next()
+		return
+	case "":
+		route = "unmatched"
+	}
+
+	_, span := tracing.StartServer(c, c.Request.Method+" "+route, c.Request.Header,
+		"http.method", c.Request.Method,
+		"http.route", route,
+		"http.target", c.Request.URL.Path,
+		"request.id", c.GetString("requestId"),
+	)
+	if span == nil {
+		c.Next()
+		return
+	}
+	defer span.End()
+	c.Set("span", span)
+	c.Set("traceId", span.Context().TraceId.String())
+
+	c.Next()
+
+	// The caller is known once the request is authenticated
+	status := c.Writer.Status()
+	span.SetAttributes("http.status_code", status)
+	if userId := c.GetString("userId"); userId != "" {
+		span.SetAttributes("enduser.id", userId, "account.id", c.GetString("accountId"))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(status)))
+	}
+}
+
+// AccessLog logs every answered request. The caller is known once the
+// request is authenticated, so the entry is written when it is answered.
+func (s *webServer) AccessLog(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	logger := logging.FromContext(c)
+	write := logger.Info
+	switch status := c.Writer.Status(); {
+	case status >= http.StatusInternalServerError:
+		write = logger.Error
+	case c.FullPath() == "/healthz" || c.FullPath() == "/readyz" || c.FullPath() == "/metrics":
+		write = logger.Debug
+	}
+	write("request",
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"bytes", c.Writer.Size(),
+		"durationMs", time.Since(start).Milliseconds(),
+		"clientIp", c.ClientIP(),
+	)
+}
+
+// recovered ends a request that panicked with an internal error, and logs
+// the panic with the stack
+func recovered(c *gin.Context, err interface{}) {
+	logging.FromContext(c).Error("panic", "error", fmt.Sprint(err), "stack", string(debug.Stack()))
+	routes.AbortWithError(c, fmt.Errorf("panic: %v", err))
+}
+
+// ClientIp makes the ip of the client available to services, which use it
+// to throttle logins and in audit entries
+func (s *webServer) ClientIp(c *gin.Context) {
+	c.Set("clientIp", c.ClientIP())
+	c.Next()
+}
+
+// JWTAuthRequired authenticates the caller with an access token, or with an
+// api key whose scopes then limit the permissions of the caller
 func (s *webServer) JWTAuthRequired(c *gin.Context) {
 	auth := c.Request.Header.Get("Authorization")
 	if auth == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		routes.AbortWithError(c, errUnauthorized)
 		return
 	}
 	token := strings.TrimPrefix(auth, "Bearer ")
 	if token == auth {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		routes.AbortWithError(c, errUnauthorized)
 		return
 	}
 
-	userId, accountId, err := s.extractSubjectAndToken(token)
-	if err != nil || userId == "" || accountId == "" {
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	if services.IsApiKey(token) {
+		s.apiKeyRequired(c, token)
+		return
+	}
+
+	claims, err := s.extractClaims(token)
+	if err != nil || claims.userId == "" || claims.accountId == "" || claims.tokenId == "" {
+		routes.AbortWithError(c, errUnauthorized)
+		return
+	}
+
+	revoked, err := s.sessionService.IsRevoked(c, claims.tokenId)
+	if err != nil {
+		routes.AbortWithError(c, fmt.Errorf("checking the revocation of a token failed: %w", err))
+		return
+	}
+	if revoked {
+		routes.AbortWithError(c, errUnauthorized)
 		return
 	}
 
 	// Set userId for route handlers
-	c.Set("userId", userId)
-	c.Set("accountId", accountId)
+	c.Set("userId", claims.userId)
+	c.Set("accountId", claims.accountId)
+	c.Set("tokenId", claims.tokenId)
+
+	if claims.actorId != "" {
+		s.impersonated(c, claims.actorId)
+		return
+	}
 
 	c.Next()
 }
 
-func (s *webServer) extractSubjectAndToken(bearer string) (string, string, error) {
-	if bearer == "" {
-		return "", "", nil
+// impersonated handles a request made by a platform admin as another user,
+// which is logged and recorded in the audit log once it is answered
+func (s *webServer) impersonated(c *gin.Context, actorId string) {
+	c.Set("actorId", actorId)
+	logging.FromContext(c).Info("impersonation", "method", c.Request.Method, "path", c.Request.URL.Path)
+
+	c.Next()
+
+	err := s.impersonationService.Record(c, c.Request.Method, c.Request.URL.Path, c.Writer.Status())
+	if err != nil {
+		logging.FromContext(c).Error("recording an impersonated request failed", "error", err)
+	}
+}
+
+func (s *webServer) apiKeyRequired(c *gin.Context, token string) {
+	switch s.authenticateApiKey(c, token) {
+	case http.StatusUnauthorized:
+		routes.AbortWithError(c, errUnauthorized)
+		return
+	case http.StatusInternalServerError:
+		routes.AbortWithError(c, errors.New("authenticating an api key failed"))
+		return
+	}
+
+	c.Next()
+}
+
+// authenticateApiKey sets the caller of a request made with an api key, and
+// returns the status code of a failed authentication otherwise
+func (s *webServer) authenticateApiKey(c *gin.Context, token string) int {
+	key, err := s.apiKeyService.Authenticate(c, token)
+	var domainErr *utils.DomainError
+	if errors.As(err, &domainErr) {
+		return http.StatusUnauthorized
 	}
-	subject, err := jwtutils.ExtractToken(bearer, s.jwtSecret, func(token *jwt.Token) interface{} {
-		claims := token.Claims.(jwt.MapClaims)
-		return claims["sub"]
-	})
 	if err != nil {
-		return "", "", err
+		logging.FromContext(c).Error("authenticating an api key failed", "error", err)
+		return http.StatusInternalServerError
 	}
 
-	if subject == nil {
-		return "", "", fmt.Errorf("empty subject in claims")
+	c.Set("userId", key.UserId)
+	c.Set("accountId", key.AccountId)
+	c.Set("apiKeyId", key.Id)
+	c.Set("scopes", key.Scopes)
+
+	return http.StatusOK
+}
+
+// ScimAuthRequired authenticates identity providers, which call the SCIM
+// routes with an api key that has the user:write permission. Groups are the
+// roles of the account, so changing them also takes the role:write
+// permission, as it does in the api. Failures are answered with SCIM errors.
+func (s *webServer) ScimAuthRequired(c *gin.Context) {
+	token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if !services.IsApiKey(token) {
+		abortScim(c, http.StatusUnauthorized)
+		return
+	}
+	if status := s.authenticateApiKey(c, token); status != http.StatusOK {
+		abortScim(c, status)
+		return
 	}
 
-	accountId, err := jwtutils.ExtractToken(bearer, s.jwtSecret, func(token *jwt.Token) interface{} {
-		claims := token.Claims.(jwt.MapClaims)
-		extraClaims, ok := claims[subject.(string)].(map[string]interface{})
-		if !ok {
-			return nil
+	permissions := []string{services.PermissionUserWrite}
+	if c.Request.Method != http.MethodGet && strings.HasPrefix(c.FullPath(), "/scim/v2/Groups") {
+		permissions = append(permissions, services.PermissionRoleWrite)
+	}
+	for _, permission := range permissions {
+		allowed, err := s.roleService.HasPermission(c, c.GetString("userId"), permission)
+		if err != nil {
+			logging.FromContext(c).Error("checking a permission failed", "permission", permission, "error", err)
+			abortScim(c, http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			abortScim(c, http.StatusForbidden)
+			return
 		}
-		return extraClaims["accountId"]
+	}
+
+	c.Next()
+}
+
+func abortScim(c *gin.Context, status int) {
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(status, scim.NewErrorResponse(status, "", strings.ToLower(http.StatusText(status))))
+}
+
+// PermissionRequired checks that the caller's role grants the permission
+// required by the matched route. It must run after JWTAuthRequired.
+func (s *webServer) PermissionRequired(c *gin.Context) {
+	permission, ok := routes.RequiredPermission(c.Request.Method, c.FullPath())
+	if !ok {
+		c.Next()
+		return
+	}
+
+	allowed, err := s.roleService.HasPermission(c, c.GetString("userId"), permission)
+	if err != nil {
+		routes.AbortWithError(c, fmt.Errorf("checking the permission %s failed: %w", permission, err))
+		return
+	}
+	if !allowed {
+		routes.AbortWithError(c, errForbidden)
+		return
+	}
+
+	c.Next()
+}
+
+// accessClaims are the claims of an access token that identify the caller.
+// The actor is set when a platform admin impersonates the subject.
+type accessClaims struct {
+	userId    string
+	accountId string
+	tokenId   string
+	actorId   string
+}
+
+func (s *webServer) extractClaims(bearer string) (accessClaims, error) {
+	if bearer == "" {
+		return accessClaims{}, nil
+	}
+	claims, err := jwtutils.ExtractToken(bearer, s.keys, func(token *jwt.Token) interface{} {
+		return token.Claims
 	})
 	if err != nil {
-		return "", "", err
+		return accessClaims{}, err
 	}
+	mapClaims := claims.(jwt.MapClaims)
 
-	if accountId == nil {
-		return "", "", fmt.Errorf("empty accountId in extra claims")
+	subject, ok := mapClaims["sub"].(string)
+	if !ok || subject == "" {
+		return accessClaims{}, fmt.Errorf("empty subject in claims")
+	}
+
+	extraClaims, ok := mapClaims[subject].(map[string]interface{})
+	if !ok {
+		return accessClaims{}, fmt.Errorf("no extra claims for subject")
+	}
+	accountId, ok := extraClaims["accountId"].(string)
+	if !ok || accountId == "" {
+		return accessClaims{}, fmt.Errorf("empty accountId in extra claims")
+	}
+
+	tokenId, ok := mapClaims["jti"].(string)
+	if !ok || tokenId == "" {
+		return accessClaims{}, fmt.Errorf("empty token id in claims")
+	}
+
+	var actorId string
+	if actor, ok := extraClaims["act"].(map[string]interface{}); ok {
+		actorId, ok = actor["sub"].(string)
+		if !ok || actorId == "" {
+			return accessClaims{}, fmt.Errorf("empty actor in extra claims")
+		}
 	}
 
-	return subject.(string), accountId.(string), nil
+	return accessClaims{
+		userId:    subject,
+		accountId: accountId,
+		tokenId:   tokenId,
+		actorId:   actorId,
+	}, nil
 }
 
 func applyCors(r *gin.Engine) {
@@ -137,6 +513,7 @@ func applyCors(r *gin.Engine) {
 	//hot reload CORS
 	corsConfig.AllowOrigins = []string{"http://localhost:3001"}
 	corsConfig.AllowCredentials = true
-	corsConfig.AllowHeaders = []string{"Content-Type", "Authorization"}
+	corsConfig.AllowHeaders = []string{"Content-Type", "Authorization", requestIdHeader, tracing.TraceparentHeader}
+	corsConfig.ExposeHeaders = []string{requestIdHeader}
 	r.Use(cors.New(corsConfig))
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowRoute answers once it is released, so that a request is in flight for
+// as long as a test needs
+type slowRoute struct {
+	started  chan struct{}
+	released chan struct{}
+}
+
+func (r *slowRoute) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("slow", func(c *gin.Context) {
+		close(r.started)
+		<-r.released
+		c.JSON(http.StatusOK, gin.H{"status": "done"})
+	})
+}
+
+// TestDrain checks that a request in flight is answered after the context of
+// the server is done, while the server reports that it is not ready and then
+// shuts down
+func TestDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := newTestApp(t, ctx)
+	route := &slowRoute{started: make(chan struct{}), released: make(chan struct{})}
+	app.server.publicRoutes = append(app.server.publicRoutes, route)
+	app.server.drainDelay = 500 * time.Millisecond
+	app.server.shutdownTimeout = 5 * time.Second
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	stopped := make(chan struct{})
+	go func() {
+		app.server.serve(listener)
+		close(stopped)
+	}()
+
+	readiness := func() int {
+		res, err := http.Get(url + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := readiness(); status != http.StatusOK {
+		t.Fatalf("expected readiness %d before draining, got %d", http.StatusOK, status)
+	}
+
+	answered := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			t.Error(err)
+			answered <- 0
+			return
+		}
+		res.Body.Close()
+		answered <- res.StatusCode
+	}()
+	<-route.started
+
+	cancel()
+	deadline := time.Now().Add(app.server.drainDelay)
+	for status := readiness(); status != http.StatusServiceUnavailable; status = readiness() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected readiness %d while draining, got %d", http.StatusServiceUnavailable, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The server waits for the request once the drain delay is over
+	time.Sleep(app.server.drainDelay + 200*time.Millisecond)
+	select {
+	case <-stopped:
+		t.Fatal("the server stopped with a request in flight")
+	default:
+	}
+
+	close(route.released)
+	if status := <-answered; status != http.StatusOK {
+		t.Errorf("expected the request in flight to be answered with %d, got %d", http.StatusOK, status)
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not stop after draining")
+	}
+}
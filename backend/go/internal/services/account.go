@@ -0,0 +1,477 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	AuditAccountTransfer = "account.transfer"
+	AuditAccountExport   = "account.export"
+	AuditAccountDelete   = "account.delete"
+)
+
+const (
+	maxAccountNameLength = 100
+	maxStoryStatuses     = 20
+	maxStatusLength      = 40
+	maxSprintLengthDays  = 90
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,38}[a-z0-9]$`)
+
+type AccountService interface {
+	FindMine(ctx context.Context) ([]repositories.AccountMembership, error)
+	Get(ctx context.Context, accountId string) (repositories.Account, error)
+	Update(ctx context.Context, accountId string, changes AccountChanges) (repositories.Account, error)
+	TransferOwnership(ctx context.Context, accountId, userId string) (repositories.Account, error)
+	Export(ctx context.Context, accountId string) (AccountExport, error)
+	Delete(ctx context.Context, accountId, confirm string) error
+}
+
+// AccountChanges are the changes to an account. Fields that are nil are left
+// as they are.
+type AccountChanges struct {
+	Name             *string
+	Slug             *string
+	RequireMfa       *bool
+	StoryStatuses    []string
+	SprintLengthDays *int
+}
+
+// AccountExport holds everything that is deleted with an account
+type AccountExport struct {
+	Account    repositories.Account `json:"account"`
+	Members    []repositories.User  `json:"members"`
+	Roles      []repositories.Role  `json:"roles"`
+	Projects   []ProjectExport      `json:"projects"`
+	ExportedAt int64                `json:"exportedAt"`
+}
+
+type ProjectExport struct {
+	repositories.Project
+	Sprints []SprintExport `json:"sprints"`
+}
+
+type SprintExport struct {
+	repositories.Sprint
+	Stories []repositories.Story `json:"stories"`
+}
+
+type accountService struct {
+	repo        repositories.AccountRepo
+	userRepo    repositories.UserRepo
+	roleRepo    repositories.RoleRepo
+	projectRepo repositories.ProjectRepo
+	sprintRepo  repositories.SprintRepo
+	storyRepo   repositories.StoryRepo
+	auditRepo   repositories.AuditRepo
+	sessions    SessionService
+	authz       authz.Client
+	ownership   ownership
+}
+
+func NewAccountService(
+	repo repositories.AccountRepo,
+	userRepo repositories.UserRepo,
+	roleRepo repositories.RoleRepo,
+	projectRepo repositories.ProjectRepo,
+	sprintRepo repositories.SprintRepo,
+	storyRepo repositories.StoryRepo,
+	auditRepo repositories.AuditRepo,
+	sessions SessionService,
+	authzClient authz.Client,
+	ownershipRepo repositories.OwnershipRepo) AccountService {
+	return &accountService{
+		repo:        repo,
+		userRepo:    userRepo,
+		roleRepo:    roleRepo,
+		projectRepo: projectRepo,
+		sprintRepo:  sprintRepo,
+		storyRepo:   storyRepo,
+		auditRepo:   auditRepo,
+		sessions:    sessions,
+		authz:       authzClient,
+		ownership:   ownership{repo: ownershipRepo},
+	}
+}
+
+// FindMine returns the accounts of the caller, marking the one the caller is
+// working in
+func (s *accountService) FindMine(ctx context.Context) ([]repositories.AccountMembership, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.FindMine")
+	defer span.End()
+
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return []repositories.AccountMembership{}, fmt.Errorf("no userId")
+	}
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return []repositories.AccountMembership{}, err
+	}
+
+	memberships, err := s.repo.FindByUser(ctx, userId.(string))
+	if err != nil {
+		return []repositories.AccountMembership{}, err
+	}
+
+	for i := range memberships {
+		memberships[i].Current = memberships[i].Id == accountId
+	}
+
+	return memberships, nil
+}
+
+func (s *accountService) Get(ctx context.Context, accountId string) (repositories.Account, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.Get")
+	defer span.End()
+
+	if err := s.ownership.account(ctx, accountId); err != nil {
+		return repositories.Account{}, err
+	}
+
+	account, err := s.repo.Get(ctx, accountId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.Account{}, notFound("account")
+	}
+
+	return account, err
+}
+
+// Update changes the name, slug and settings of the caller's account
+func (s *accountService) Update(ctx context.Context, accountId string, changes AccountChanges) (repositories.Account, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.Update")
+	defer span.End()
+
+	account, err := s.Get(ctx, accountId)
+	if err != nil {
+		return repositories.Account{}, err
+	}
+
+	if changes.Name != nil {
+		if account.Name, err = accountName(*changes.Name); err != nil {
+			return repositories.Account{}, err
+		}
+	}
+	if changes.Slug != nil && *changes.Slug != account.Slug {
+		if !slugPattern.MatchString(*changes.Slug) {
+			return repositories.Account{}, utils.NewValidationError("a slug has 3 to 40 lowercase letters, digits and dashes, and starts and ends with a letter or digit", nil)
+		}
+		if _, err = s.repo.FindBySlug(ctx, *changes.Slug); err == nil {
+			return repositories.Account{}, utils.NewConflictError("slug already in use", nil)
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return repositories.Account{}, err
+		}
+		account.Slug = *changes.Slug
+	}
+	if changes.RequireMfa != nil {
+		account.RequireMfa = *changes.RequireMfa
+	}
+	if changes.StoryStatuses != nil {
+		if account.Settings.StoryStatuses, err = storyStatuses(changes.StoryStatuses); err != nil {
+			return repositories.Account{}, err
+		}
+	}
+	if changes.SprintLengthDays != nil {
+		if *changes.SprintLengthDays < 1 || *changes.SprintLengthDays > maxSprintLengthDays {
+			return repositories.Account{}, utils.NewValidationError(fmt.Sprintf("the sprint length is 1 to %d days", maxSprintLengthDays), nil)
+		}
+		account.Settings.SprintLengthDays = *changes.SprintLengthDays
+	}
+
+	if err = s.repo.Update(ctx, account); err != nil {
+		return repositories.Account{}, err
+	}
+
+	return account, nil
+}
+
+// TransferOwnership makes another member of the caller's account its owner.
+// Only the owner can transfer the account, and the new owner becomes an admin
+// so that it can manage the account.
+func (s *accountService) TransferOwnership(ctx context.Context, accountId, userId string) (repositories.Account, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.TransferOwnership")
+	defer span.End()
+
+	account, err := s.owned(ctx, accountId)
+	if err != nil {
+		return repositories.Account{}, err
+	}
+	if userId == account.OwnerId {
+		return account, nil
+	}
+
+	user, err := s.userRepo.GetMember(ctx, accountId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.Account{}, notFound("user")
+	}
+	if err != nil {
+		return repositories.Account{}, err
+	}
+	if user.DeactivatedAt != 0 {
+		return repositories.Account{}, utils.NewValidationError("the user is deactivated", nil)
+	}
+
+	if err = s.repo.SetOwner(ctx, accountId, userId, nil); err != nil {
+		return repositories.Account{}, err
+	}
+
+	if user.RoleId != RoleAdmin {
+		if err = s.userRepo.SetRole(ctx, accountId, userId, RoleAdmin); err != nil {
+			return repositories.Account{}, err
+		}
+		if user.RoleId != "" {
+			if err = s.authz.UnassignRole(ctx, user.RoleId, userId, accountId); err != nil {
+				return repositories.Account{}, err
+			}
+		}
+		if err = s.authz.AssignRole(ctx, RoleAdmin, userId, accountId); err != nil {
+			return repositories.Account{}, err
+		}
+	}
+
+	if err = s.audit(ctx, accountId, AuditAccountTransfer, map[string]interface{}{
+		"previousOwnerId": account.OwnerId,
+		"ownerId":         userId,
+	}); err != nil {
+		return repositories.Account{}, err
+	}
+
+	account.OwnerId = userId
+	return account, nil
+}
+
+// Export returns the caller's account with its members, roles, projects,
+// sprints and stories
+func (s *accountService) Export(ctx context.Context, accountId string) (AccountExport, error) {
+	ctx, span := tracing.Start(ctx, "AccountService.Export")
+	defer span.End()
+
+	account, err := s.Get(ctx, accountId)
+	if err != nil {
+		return AccountExport{}, err
+	}
+
+	export := AccountExport{
+		Account:    account,
+		Members:    []repositories.User{},
+		Roles:      []repositories.Role{},
+		Projects:   []ProjectExport{},
+		ExportedAt: time.Now().Unix(),
+	}
+
+	members, err := s.userRepo.FindAll(ctx, accountId)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	export.Members = append(export.Members, members...)
+
+	roles, err := s.roleRepo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	export.Roles = append(export.Roles, roles...)
+
+	projects, err := s.projectRepo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	for _, project := range projects {
+		projectExport := ProjectExport{Project: project, Sprints: []SprintExport{}}
+
+		sprints, err := s.sprintRepo.FindByProject(ctx, project.Id)
+		if err != nil {
+			return AccountExport{}, err
+		}
+		for _, sprint := range sprints {
+			stories, err := s.storyRepo.FindBySprint(ctx, sprint.Id)
+			if err != nil {
+				return AccountExport{}, err
+			}
+			projectExport.Sprints = append(projectExport.Sprints,
+				SprintExport{Sprint: sprint, Stories: append([]repositories.Story{}, stories...)})
+		}
+
+		export.Projects = append(export.Projects, projectExport)
+	}
+
+	if err = s.audit(ctx, accountId, AuditAccountExport, nil); err != nil {
+		return AccountExport{}, err
+	}
+
+	return export, nil
+}
+
+// Delete deletes the caller's account with all of its projects, sprints and
+// stories, and the users that belong to no other account. Only the owner can
+// delete the account, and has to confirm it with the slug of the account.
+func (s *accountService) Delete(ctx context.Context, accountId, confirm string) error {
+	ctx, span := tracing.Start(ctx, "AccountService.Delete")
+	defer span.End()
+
+	account, err := s.owned(ctx, accountId)
+	if err != nil {
+		return err
+	}
+
+	if confirm != account.Slug {
+		return utils.NewValidationError("confirm the deletion with the slug of the account",
+			map[string]interface{}{
+				"confirm": "the slug of the account",
+				"export":  "/api/accounts/" + accountId + "/export",
+			})
+	}
+
+	roles, err := s.roleRepo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return err
+	}
+
+	// Sessions are revoked first, as those of the deleted users are deleted
+	// with them
+	if err = s.sessions.RevokeAccount(ctx, accountId); err != nil {
+		return err
+	}
+
+	if err = s.repo.Delete(ctx, accountId); err != nil {
+		return err
+	}
+
+	// The authorization client is called after deleting, as it may use
+	// the same database or a remote service
+	if err = s.authz.DeleteResource(ctx, accountId); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if !role.BuiltIn {
+			if err = s.authz.DeleteRole(ctx, role.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.audit(ctx, accountId, AuditAccountDelete, map[string]interface{}{
+		"name": account.Name,
+		"slug": account.Slug,
+	})
+}
+
+// owned returns the caller's account when the caller owns it. It can't be
+// used with api keys.
+func (s *accountService) owned(ctx context.Context, accountId string) (repositories.Account, error) {
+
+	if err := requireSession(ctx); err != nil {
+		return repositories.Account{}, err
+	}
+
+	account, err := s.Get(ctx, accountId)
+	if err != nil {
+		return repositories.Account{}, err
+	}
+
+	if account.OwnerId == "" || account.OwnerId != ctx.Value("userId") {
+		return repositories.Account{}, utils.NewForbiddenError("only the owner of the account can do this")
+	}
+
+	return account, nil
+}
+
+func (s *accountService) audit(ctx context.Context, accountId, action string, details map[string]interface{}) error {
+	actorId, _ := ctx.Value("userId").(string)
+	return s.auditRepo.Create(ctx, repositories.AuditEntry{
+		AccountId: accountId,
+		ActorId:   actorId,
+		Action:    action,
+		Target:    "account:" + accountId,
+		Details:   details,
+		Ip:        clientIp(ctx),
+	})
+}
+
+func accountName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAccountNameLength {
+		return "", utils.NewValidationError(fmt.Sprintf("an account name has 1 to %d characters", maxAccountNameLength), nil)
+	}
+	return name, nil
+}
+
+// storyStatuses returns the trimmed statuses, which have to be unique
+func storyStatuses(statuses []string) ([]string, error) {
+	if len(statuses) == 0 || len(statuses) > maxStoryStatuses {
+		return nil, utils.NewValidationError(fmt.Sprintf("an account has 1 to %d story statuses", maxStoryStatuses), nil)
+	}
+
+	seen := make(map[string]bool, len(statuses))
+	trimmed := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		status = strings.TrimSpace(status)
+		if status == "" || len(status) > maxStatusLength {
+			return nil, utils.NewValidationError(fmt.Sprintf("a story status has 1 to %d characters", maxStatusLength), nil)
+		}
+		if seen[status] {
+			return nil, utils.NewValidationError("duplicate story status "+status, nil)
+		}
+		seen[status] = true
+		trimmed = append(trimmed, status)
+	}
+	return trimmed, nil
+}
+
+// accountSlug turns a name into a slug. Names without letters or digits give
+// an empty slug.
+func accountSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if b.Len() >= 31 {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// uniqueSlug returns the slug of the name, with a random suffix when it is
+// taken or too short
+func uniqueSlug(ctx context.Context, accountRepo repositories.AccountRepo, name string) (string, error) {
+	slug := accountSlug(name)
+	if slugPattern.MatchString(slug) {
+		_, err := accountRepo.FindBySlug(ctx, slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	if slug == "" {
+		slug = "account"
+	}
+	return slug + "-" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ApiKeyPrefixPersonal = "cxp_"
+	ApiKeyPrefixService  = "cxs_"
+	// apiKeyDisplayLength is the part of a key that is kept to recognize it
+	apiKeyDisplayLength = 12
+)
+
+type ApiKeyService interface {
+	Create(ctx context.Context, name string, scopes []string, expiresAt int64, service bool, roleId string) (repositories.ApiKey, error)
+	FindAll(ctx context.Context) ([]repositories.ApiKey, error)
+	Revoke(ctx context.Context, keyId string) error
+	Authenticate(ctx context.Context, token string) (repositories.ApiKey, error)
+}
+
+type apiKeyService struct {
+	txProvider database.TxProvider
+	repo       repositories.ApiKeyRepo
+	userRepo   repositories.UserRepo
+	roleRepo   repositories.RoleRepo
+	authz      authz.Client
+	authorizer authorizer
+}
+
+func NewApiKeyService(
+	txProvider database.TxProvider,
+	repo repositories.ApiKeyRepo,
+	userRepo repositories.UserRepo,
+	roleRepo repositories.RoleRepo,
+	authzClient authz.Client) ApiKeyService {
+	return &apiKeyService{
+		txProvider: txProvider,
+		repo:       repo,
+		userRepo:   userRepo,
+		roleRepo:   roleRepo,
+		authz:      authzClient,
+		authorizer: authorizer{client: authzClient},
+	}
+}
+
+// IsApiKey tells api keys apart from access tokens
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, ApiKeyPrefixPersonal) || strings.HasPrefix(token, ApiKeyPrefixService)
+}
+
+// Create creates a personal key of the caller, or a service key of the
+// caller's account. A service key gets a service user of its own with an
+// account role, the member role by default, and can be added to projects like
+// any other user. Creating service keys needs the user:write permission. The
+// key itself is only returned this once.
+func (s *apiKeyService) Create(ctx context.Context, name string, scopes []string, expiresAt int64, service bool, roleId string) (repositories.ApiKey, error) {
+	ctx, span := tracing.Start(ctx, "ApiKeyService.Create")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return repositories.ApiKey{}, err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return repositories.ApiKey{}, fmt.Errorf("no userId")
+	}
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return repositories.ApiKey{}, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return repositories.ApiKey{}, utils.NewValidationError("missing key name", nil)
+	}
+	if len(scopes) == 0 {
+		return repositories.ApiKey{}, utils.NewValidationError("missing scopes", nil)
+	}
+	for _, scope := range scopes {
+		if !isPermission(scope) {
+			return repositories.ApiKey{}, utils.NewValidationError("unknown scope",
+				map[string]interface{}{"scope": scope})
+		}
+	}
+	if expiresAt != 0 && expiresAt <= time.Now().Unix() {
+		return repositories.ApiKey{}, utils.NewValidationError("expiry is in the past", nil)
+	}
+
+	prefix := ApiKeyPrefixPersonal
+	if service {
+		prefix = ApiKeyPrefixService
+		if err = s.authorizer.require(ctx, accountId, PermissionUserWrite); err != nil {
+			return repositories.ApiKey{}, err
+		}
+		if roleId == "" {
+			roleId = RoleMember
+		}
+		if _, err = getAccountRole(ctx, s.roleRepo, accountId, roleId); err != nil {
+			return repositories.ApiKey{}, err
+		}
+	}
+
+	secret, err := newOpaqueToken()
+	if err != nil {
+		return repositories.ApiKey{}, err
+	}
+	token := prefix + secret
+
+	key := repositories.ApiKey{
+		AccountId: accountId,
+		UserId:    userId.(string),
+		Name:      name,
+		Service:   service,
+		Prefix:    token[:apiKeyDisplayLength],
+		TokenHash: hashToken(token),
+		Scopes:    scopes,
+		CreatedBy: userId.(string),
+		ExpiresAt: expiresAt,
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return repositories.ApiKey{}, err
+	}
+
+	if service {
+		// The service user cannot log in, as nobody knows its password
+		var password string
+		var serviceUser repositories.User
+		if password, err = newOpaqueToken(); err == nil {
+			email := fmt.Sprintf("%s@service.invalid", uuid.New().String())
+			serviceUser, err = s.userRepo.Save(ctx, accountId, email, password, name, roleId, tx)
+		}
+		if err != nil {
+			if rbe := tx.Rollback(); rbe != nil {
+				err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+			}
+			return repositories.ApiKey{}, err
+		}
+		key.UserId = serviceUser.Id
+	}
+
+	key, err = s.repo.Create(ctx, key, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return repositories.ApiKey{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.ApiKey{}, err
+	}
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if service {
+		if err = s.authz.AssignRole(ctx, roleId, key.UserId, accountId); err != nil {
+			return repositories.ApiKey{}, err
+		}
+	}
+
+	key.Token = token
+	return key, nil
+}
+
+// FindAll lists the caller's personal keys, and the service keys of the
+// account when the caller may manage them
+func (s *apiKeyService) FindAll(ctx context.Context) ([]repositories.ApiKey, error) {
+	ctx, span := tracing.Start(ctx, "ApiKeyService.FindAll")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return []repositories.ApiKey{}, err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return []repositories.ApiKey{}, fmt.Errorf("no userId")
+	}
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return []repositories.ApiKey{}, err
+	}
+
+	keys, err := s.repo.FindPersonal(ctx, userId.(string))
+	if err != nil {
+		return []repositories.ApiKey{}, err
+	}
+
+	err = s.authorizer.require(ctx, accountId, PermissionUserWrite)
+	if isForbidden(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return []repositories.ApiKey{}, err
+	}
+
+	serviceKeys, err := s.repo.FindService(ctx, accountId)
+	if err != nil {
+		return []repositories.ApiKey{}, err
+	}
+
+	return append(keys, serviceKeys...), nil
+}
+
+// Revoke revokes a personal key of the caller, or a service key of the
+// caller's account
+func (s *apiKeyService) Revoke(ctx context.Context, keyId string) error {
+	ctx, span := tracing.Start(ctx, "ApiKeyService.Revoke")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return fmt.Errorf("no userId")
+	}
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	key, err := s.repo.Get(ctx, keyId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("key")
+	}
+	if err != nil {
+		return err
+	}
+
+	if key.Service {
+		if key.AccountId != accountId {
+			return notFound("key")
+		}
+		if err = s.authorizer.require(ctx, accountId, PermissionUserWrite); err != nil {
+			return err
+		}
+	} else if key.UserId != userId.(string) {
+		return notFound("key")
+	}
+
+	err = s.repo.Revoke(ctx, key.Id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.NewDomainError(http.StatusNotFound, "key not found or already revoked", nil)
+	}
+
+	return err
+}
+
+// Authenticate returns the key for a request that presents it, and records
+// that it was used. Keys of deactivated users, and of users that left the
+// account of the key, are refused.
+func (s *apiKeyService) Authenticate(ctx context.Context, token string) (repositories.ApiKey, error) {
+	ctx, span := tracing.Start(ctx, "ApiKeyService.Authenticate")
+	defer span.End()
+
+	key, err := s.repo.FindByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ApiKey{}, unauthorized()
+	}
+	if err != nil {
+		return repositories.ApiKey{}, err
+	}
+
+	now := time.Now().Unix()
+	if key.RevokedAt != 0 || (key.ExpiresAt != 0 && key.ExpiresAt <= now) {
+		return repositories.ApiKey{}, unauthorized()
+	}
+
+	user, err := s.userRepo.GetMember(ctx, key.AccountId, key.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ApiKey{}, unauthorized()
+	}
+	if err != nil {
+		return repositories.ApiKey{}, err
+	}
+	if user.DeactivatedAt != 0 {
+		return repositories.ApiKey{}, unauthorized()
+	}
+
+	if err = s.repo.Touch(ctx, key.Id, now); err != nil {
+		return repositories.ApiKey{}, err
+	}
+
+	return key, nil
+}
+
+// scopeAllows checks the permission against the scopes of the api key that
+// the request was made with. Requests with an access token are not limited.
+func scopeAllows(ctx context.Context, permission string) bool {
+	scopes, ok := ctx.Value("scopes").([]string)
+	if !ok {
+		return true
+	}
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// requireSession refuses requests made with an api key or while impersonating
+// the caller, for what only the caller's own interactive login should be able
+// to do
+func requireSession(ctx context.Context) error {
+	if apiKeyId, ok := ctx.Value("apiKeyId").(string); ok && apiKeyId != "" {
+		return utils.NewForbiddenError("not allowed with an api key")
+	}
+	if actorId, ok := ctx.Value("actorId").(string); ok && actorId != "" {
+		return utils.NewForbiddenError("not allowed while impersonating")
+	}
+	return nil
+}
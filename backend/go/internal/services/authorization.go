@@ -0,0 +1,88 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// authorizer asks the authorization client whether the caller may perform an
+// action on a resource. Callers using an api key are also limited to its scopes.
+type authorizer struct {
+	client authz.Client
+}
+
+func (a authorizer) require(ctx context.Context, resourceId, action string) error {
+	userId, ok := ctx.Value("userId").(string)
+	if !ok || userId == "" {
+		return fmt.Errorf("no userId")
+	}
+
+	if !scopeAllows(ctx, action) {
+		return utils.NewForbiddenError("forbidden")
+	}
+
+	allowed, err := a.client.HasPermission(ctx, userId, resourceId, action)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return utils.NewForbiddenError("forbidden")
+	}
+
+	return nil
+}
+
+// requireVisible is like require, but reports resources of projects that the
+// caller cannot see as not found, so that their existence is not disclosed
+func (a authorizer) requireVisible(ctx context.Context, resource, resourceId, action string) error {
+	err := a.require(ctx, resourceId, action)
+	if !isForbidden(err) {
+		return err
+	}
+
+	if action != PermissionProjectRead {
+		visibleErr := a.require(ctx, resourceId, PermissionProjectRead)
+		if visibleErr == nil {
+			return err
+		}
+		if !isForbidden(visibleErr) {
+			return visibleErr
+		}
+	}
+
+	return notFound(resource)
+}
+
+// permitted returns the set of resources of the type on which the caller may
+// perform the action
+func (a authorizer) permitted(ctx context.Context, resourceType, action string) (map[string]bool, error) {
+	userId, ok := ctx.Value("userId").(string)
+	if !ok || userId == "" {
+		return nil, fmt.Errorf("no userId")
+	}
+
+	if !scopeAllows(ctx, action) {
+		return map[string]bool{}, nil
+	}
+
+	resourceIds, err := a.client.ListPermittedResources(ctx, userId, resourceType, action)
+	if err != nil {
+		return nil, err
+	}
+
+	permitted := make(map[string]bool, len(resourceIds))
+	for _, resourceId := range resourceIds {
+		permitted[resourceId] = true
+	}
+
+	return permitted, nil
+}
+
+func isForbidden(err error) bool {
+	var domainErr *utils.DomainError
+	return errors.As(err, &domainErr) && domainErr.StatusCode() == http.StatusForbidden
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/mail"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type EmailVerificationService interface {
+	Send(ctx context.Context, user repositories.User) error
+	Resend(ctx context.Context, email string) error
+	Verify(ctx context.Context, token string) (repositories.User, error)
+}
+
+type emailVerificationService struct {
+	txProvider database.TxProvider
+	repo       repositories.EmailVerificationRepo
+	userRepo   repositories.UserRepo
+	sessions   SessionService
+	mailer     mail.Mailer
+	appUrl     string
+	ttl        time.Duration
+}
+
+func NewEmailVerificationService(
+	txProvider database.TxProvider,
+	repo repositories.EmailVerificationRepo,
+	userRepo repositories.UserRepo,
+	sessions SessionService,
+	mailer mail.Mailer,
+	appUrl string,
+	ttl time.Duration) EmailVerificationService {
+	return &emailVerificationService{
+		txProvider: txProvider,
+		repo:       repo,
+		userRepo:   userRepo,
+		sessions:   sessions,
+		mailer:     mailer,
+		appUrl:     appUrl,
+		ttl:        ttl,
+	}
+}
+
+// Send mails a verification link to the user
+func (s *emailVerificationService) Send(ctx context.Context, user repositories.User) error {
+	ctx, span := tracing.Start(ctx, "EmailVerificationService.Send")
+	defer span.End()
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.ttl)
+	if _, err = s.repo.Create(ctx, user.Id, hashToken(token), expiresAt.Unix()); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", s.appUrl, url.QueryEscape(token))
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to verify your email address. "+
+			"It expires at %s.\n\n%s",
+			user.Name, expiresAt.UTC().Format(time.RFC1123), link),
+	})
+}
+
+// Resend mails a new verification link to an unverified user. Like
+// ForgotPassword it succeeds for unknown emails.
+func (s *emailVerificationService) Resend(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "EmailVerificationService.Resend")
+	defer span.End()
+
+	user, err := s.userRepo.FindOneByEmail(ctx, email)
+	if err != nil || user.EmailVerified {
+		return nil
+	}
+
+	return s.Send(ctx, user)
+}
+
+// Verify marks the email of the token's user as verified and logs the user in
+func (s *emailVerificationService) Verify(ctx context.Context, token string) (_ repositories.User, err error) {
+	ctx, span := tracing.Start(ctx, "EmailVerificationService.Verify")
+	defer span.End()
+
+	verification, err := s.repo.FindByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, invalidVerificationToken()
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if verification.UsedAt != 0 || verification.ExpiresAt <= time.Now().Unix() {
+		return repositories.User{}, invalidVerificationToken()
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	if err = s.repo.Consume(ctx, verification, tx); err == nil {
+		err = s.userRepo.MarkEmailVerified(ctx, verification.UserId, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.User{}, invalidVerificationToken()
+		}
+		return repositories.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.userRepo.Get(ctx, verification.UserId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	return s.sessions.Create(ctx, user)
+}
+
+func invalidVerificationToken() error {
+	return utils.NewValidationError("invalid or expired verification token", nil)
+}
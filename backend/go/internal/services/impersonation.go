@@ -0,0 +1,148 @@
+package services
+
+import (
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationRequest = "impersonation.request"
+)
+
+type ImpersonationService interface {
+	Start(ctx context.Context, userId, accountId, reason string) (repositories.User, error)
+	Record(ctx context.Context, method, path string, status int) error
+}
+
+type impersonationService struct {
+	userRepo       repositories.UserRepo
+	auditRepo      repositories.AuditRepo
+	sessions       SessionService
+	platformAdmins map[string]bool
+	ttl            time.Duration
+}
+
+// NewImpersonationService returns the service with which platform admins,
+// identified by their user ids, act as other users to see what they see
+func NewImpersonationService(
+	userRepo repositories.UserRepo,
+	auditRepo repositories.AuditRepo,
+	sessions SessionService,
+	platformAdmins []string,
+	ttl time.Duration) ImpersonationService {
+	admins := make(map[string]bool, len(platformAdmins))
+	for _, userId := range platformAdmins {
+		admins[strings.TrimSpace(userId)] = true
+	}
+	return &impersonationService{
+		userRepo:       userRepo,
+		auditRepo:      auditRepo,
+		sessions:       sessions,
+		platformAdmins: admins,
+		ttl:            ttl,
+	}
+}
+
+// Start returns the user with a short-lived access token that lets the caller,
+// a platform admin with a verified email address, act as the user in one of
+// the user's accounts. The account defaults to the one that created the
+// user. Platform admins can't be impersonated, and impersonation can't be
+// nested.
+func (s *impersonationService) Start(ctx context.Context, userId, accountId, reason string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "ImpersonationService.Start")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return repositories.User{}, err
+	}
+
+	actorId, ok := ctx.Value("userId").(string)
+	if !ok || actorId == "" {
+		return repositories.User{}, fmt.Errorf("no userId")
+	}
+	actor, err := s.userRepo.Get(ctx, actorId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if !s.platformAdmins[actor.Id] || !actor.EmailVerified || actor.DeactivatedAt != 0 {
+		return repositories.User{}, utils.NewForbiddenError("forbidden")
+	}
+
+	user, err := s.userRepo.Get(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, notFound("user")
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if accountId == "" {
+		accountId = user.HomeAccountId
+	}
+	user, err = s.userRepo.GetMember(ctx, accountId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, notFound("user")
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	if user.Id == actor.Id || s.platformAdmins[user.Id] {
+		return repositories.User{}, utils.NewValidationError("platform admins can't be impersonated", nil)
+	}
+	if user.DeactivatedAt != 0 {
+		return repositories.User{}, utils.NewValidationError("the user is deactivated", nil)
+	}
+
+	user, err = s.sessions.Impersonate(ctx, actor, user, s.ttl)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	err = s.auditRepo.Create(ctx, repositories.AuditEntry{
+		AccountId: user.AccountId,
+		ActorId:   actor.Id,
+		Action:    AuditImpersonationStart,
+		Target:    "user:" + user.Id,
+		Details:   map[string]interface{}{"reason": reason, "expiresIn": s.ttl.String()},
+		Ip:        clientIp(ctx),
+	})
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	return user, nil
+}
+
+// Record adds a request that was made while impersonating the caller to the
+// audit log
+func (s *impersonationService) Record(ctx context.Context, method, path string, status int) error {
+	ctx, span := tracing.Start(ctx, "ImpersonationService.Record")
+	defer span.End()
+
+	actorId, _ := ctx.Value("actorId").(string)
+	userId, _ := ctx.Value("userId").(string)
+	accountId, _ := ctx.Value("accountId").(string)
+	tokenId, _ := ctx.Value("tokenId").(string)
+
+	return s.auditRepo.Create(ctx, repositories.AuditEntry{
+		AccountId: accountId,
+		ActorId:   actorId,
+		Action:    AuditImpersonationRequest,
+		Target:    "user:" + userId,
+		Details: map[string]interface{}{
+			"method":  method,
+			"path":    path,
+			"status":  status,
+			"tokenId": tokenId,
+		},
+		Ip: clientIp(ctx),
+	})
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/mail"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type InviteService interface {
+	Create(ctx context.Context, email, name, roleId string) (repositories.Invite, error)
+	FindAll(ctx context.Context) ([]repositories.Invite, error)
+	Revoke(ctx context.Context, inviteId string) error
+	Accept(ctx context.Context, token, plainPassword, name string) (repositories.User, error)
+}
+
+type inviteService struct {
+	txProvider database.TxProvider
+	repo       repositories.InviteRepo
+	userRepo   repositories.UserRepo
+	roleRepo   repositories.RoleRepo
+	authz      authz.Client
+	mfa        MfaService
+	mailer     mail.Mailer
+	appUrl     string
+	ttl        time.Duration
+}
+
+func NewInviteService(
+	txProvider database.TxProvider,
+	repo repositories.InviteRepo,
+	userRepo repositories.UserRepo,
+	roleRepo repositories.RoleRepo,
+	authzClient authz.Client,
+	mfa MfaService,
+	mailer mail.Mailer,
+	appUrl string,
+	ttl time.Duration) InviteService {
+	return &inviteService{
+		txProvider: txProvider,
+		repo:       repo,
+		userRepo:   userRepo,
+		roleRepo:   roleRepo,
+		authz:      authzClient,
+		mfa:        mfa,
+		mailer:     mailer,
+		appUrl:     appUrl,
+		ttl:        ttl,
+	}
+}
+
+// Create invites someone to the caller's account with an account role, the
+// member role by default, and mails them a link to accept the invite. Users
+// of other accounts can be invited too.
+func (s *inviteService) Create(ctx context.Context, email, name, roleId string) (repositories.Invite, error) {
+	ctx, span := tracing.Start(ctx, "InviteService.Create")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return repositories.Invite{}, fmt.Errorf("no accountId")
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return repositories.Invite{}, fmt.Errorf("no userId")
+	}
+
+	email = strings.TrimSpace(email)
+	if roleId == "" {
+		roleId = RoleMember
+	}
+
+	if _, err := getAccountRole(ctx, s.roleRepo, accountId.(string), roleId); err != nil {
+		return repositories.Invite{}, err
+	}
+
+	// FindOneByEmail fails for any error, which is taken as a new user
+	existing, err := s.userRepo.FindOneByEmail(ctx, email)
+	isUser := err == nil
+	if isUser {
+		_, err = s.userRepo.GetMember(ctx, accountId.(string), existing.Id)
+		if err == nil {
+			return repositories.Invite{}, utils.NewConflictError("the user is already a member of the account", nil)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return repositories.Invite{}, err
+		}
+	}
+
+	pending, err := s.repo.FindPendingByEmail(ctx, accountId.(string), email)
+	if err != nil {
+		return repositories.Invite{}, err
+	}
+	if len(pending) > 0 {
+		return repositories.Invite{}, utils.NewConflictError("an invite for this email is pending",
+			map[string]interface{}{"inviteId": pending[0].Id})
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return repositories.Invite{}, err
+	}
+
+	invite, err := s.repo.Create(ctx, repositories.Invite{
+		AccountId: accountId.(string),
+		Email:     email,
+		Name:      name,
+		RoleId:    roleId,
+		InvitedBy: userId.(string),
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		return repositories.Invite{}, err
+	}
+
+	link := fmt.Sprintf("%s/accept-invite?token=%s", s.appUrl, url.QueryEscape(token))
+	instruction := "choose a password and accept the invite"
+	if isUser {
+		instruction = "accept the invite, you keep logging in as before"
+	}
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hi %s,\n\nYou have been invited to join a team. Use the link below to "+
+			"%s. It expires at %s.\n\n%s",
+			name, instruction, time.Unix(invite.ExpiresAt, 0).UTC().Format(time.RFC1123), link),
+	})
+	if err != nil {
+		return repositories.Invite{}, err
+	}
+
+	return invite, nil
+}
+
+func (s *inviteService) FindAll(ctx context.Context) ([]repositories.Invite, error) {
+	ctx, span := tracing.Start(ctx, "InviteService.FindAll")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return []repositories.Invite{}, fmt.Errorf("no accountId")
+	}
+
+	return s.repo.FindByAccount(ctx, accountId.(string))
+}
+
+func (s *inviteService) Revoke(ctx context.Context, inviteId string) error {
+	ctx, span := tracing.Start(ctx, "InviteService.Revoke")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return fmt.Errorf("no accountId")
+	}
+
+	err := s.repo.Revoke(ctx, accountId.(string), inviteId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.NewDomainError(http.StatusNotFound, "invite not found or no longer pending", nil)
+	}
+
+	return err
+}
+
+// Accept creates the invited user with the password of their choice and logs
+// them in, asking for a second factor when the account requires one. The
+// invite proves that the user owns the email address. A user of another
+// account becomes a member of the account and keeps its password.
+func (s *inviteService) Accept(ctx context.Context, token, plainPassword, name string) (_ repositories.User, err error) {
+	ctx, span := tracing.Start(ctx, "InviteService.Accept")
+	defer span.End()
+
+	invite, err := s.repo.FindByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, invalidInvite()
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if invite.Status != repositories.InviteStatusPending {
+		return repositories.User{}, invalidInvite()
+	}
+
+	// FindOneByEmail fails for any error, which is taken as a new user
+	existing, err := s.userRepo.FindOneByEmail(ctx, invite.Email)
+	isUser := err == nil
+	if !isUser && plainPassword == "" {
+		return repositories.User{}, utils.NewValidationError("missing password", nil)
+	}
+
+	if name == "" {
+		name = invite.Name
+	}
+
+	// Custom roles can be deleted while an invite is pending
+	if _, err = getAccountRole(ctx, s.roleRepo, invite.AccountId, invite.RoleId); err != nil {
+		invite.RoleId = RoleMember
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	user := existing
+	if err = s.repo.Accept(ctx, invite.Id, tx); err == nil && isUser {
+		err = s.userRepo.AddMember(ctx, invite.AccountId, user.Id, invite.RoleId, tx)
+	} else if err == nil {
+		user, err = s.userRepo.Save(ctx, invite.AccountId, invite.Email, plainPassword, name, invite.RoleId, tx)
+	}
+	if err == nil {
+		err = s.userRepo.MarkEmailVerified(ctx, user.Id, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.User{}, invalidInvite()
+		}
+		return repositories.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.User{}, err
+	}
+	user.AccountId = invite.AccountId
+	user.RoleId = invite.RoleId
+	user.EmailVerified = true
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.AssignRole(ctx, invite.RoleId, user.Id, invite.AccountId); err != nil {
+		return repositories.User{}, err
+	}
+
+	return s.mfa.Login(ctx, user)
+}
+
+func invalidInvite() error {
+	return utils.NewValidationError("invalid or expired invite", nil)
+}
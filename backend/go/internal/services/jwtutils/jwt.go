@@ -2,35 +2,41 @@ package jwtutils
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"time"
 )
 
-func Sign(subject string, claims map[string]interface{}, secret string) (string, error) {
+// Sign issues a token for the subject that expires after ttl. It returns the
+// signed token together with its unique id (jti), which can be used to revoke it.
+func Sign(subject string, claims map[string]interface{}, keys *KeySet, ttl time.Duration) (string, string, error) {
 	now := time.Now()
+	tokenId := uuid.New().String()
 	registeredClaims := jwt.RegisteredClaims{
 		Issuer:    "acme",
 		Subject:   subject,
-		ExpiresAt: jwt.NewNumericDate(now.Add(24 * time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        tokenId,
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, customClaims{
+	key := keys.SigningKey()
+	token := jwt.NewWithClaims(key.method, customClaims{
 		extraClaims: map[string]interface{}{
 			subject: claims,
 		},
 		RegisteredClaims: registeredClaims,
 	})
-	return token.SignedString([]byte(secret))
+	token.Header["kid"] = key.Id
+	signed, err := token.SignedString(key.signer)
+	if err != nil {
+		return "", "", err
+	}
+	return signed, tokenId, nil
 }
 
-func ExtractToken(bearer string, secret string, mapper func(*jwt.Token) interface{}) (interface{}, error) {
-	token, err := jwt.Parse(bearer, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
+func ExtractToken(bearer string, keys *KeySet, mapper func(*jwt.Token) interface{}) (interface{}, error) {
+	token, err := jwt.Parse(bearer, keys.verificationKey,
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,221 @@
+package jwtutils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Key is a private signing key identified by the thumbprint of its public part.
+type Key struct {
+	Id     string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+// KeySet holds the keys that tokens are verified with. The first key signs new
+// tokens, the others stay valid for verification while a rotation is in progress.
+type KeySet struct {
+	keys []*Key
+	byId map[string]*Key
+}
+
+// JWK is the public representation of a key as published in a JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set needs at least one key")
+	}
+	byId := make(map[string]*Key, len(keys))
+	for _, key := range keys {
+		if _, exists := byId[key.Id]; exists {
+			return nil, fmt.Errorf("duplicate key %s", key.Id)
+		}
+		byId[key.Id] = key
+	}
+	return &KeySet{keys: keys, byId: byId}, nil
+}
+
+// LoadKeySet reads PKCS#8 PEM private keys from files. When no files are given
+// a key for the algorithm is generated, which means tokens do not survive a restart.
+func LoadKeySet(files []string, algorithm string) (*KeySet, error) {
+	if len(files) == 0 {
+		key, err := GenerateKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeySet(key)
+	}
+
+	var keys []*Key
+	for _, file := range files {
+		key, err := LoadKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(keys...)
+}
+
+func LoadKey(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type in %s", file)
+	}
+	return newKey(signer)
+}
+
+func GenerateKey(algorithm string) (*Key, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(privateKey)
+	case AlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(privateKey)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+}
+
+func newKey(signer crypto.Signer) (*Key, error) {
+	var method jwt.SigningMethod
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", signer)
+	}
+	key := &Key{method: method, signer: signer}
+	id, err := key.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	key.Id = id
+	return key, nil
+}
+
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// JWK returns the public part of the key.
+func (k *Key) JWK() JWK {
+	jwk := JWK{Kid: k.Id, Use: "sig", Alg: k.Algorithm()}
+	switch public := k.signer.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint, which serves as the key id.
+func (k *Key) thumbprint() (string, error) {
+	jwk := k.JWK()
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (ks *KeySet) SigningKey() *Key {
+	return ks.keys[0]
+}
+
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
+
+// verificationKey selects the public key named by the token's kid header and
+// makes sure the token was signed with the algorithm that belongs to it.
+func (ks *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing kid header")
+	}
+	key, ok := ks.byId[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	if token.Method.Alg() != key.Algorithm() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.signer.Public(), nil
+}
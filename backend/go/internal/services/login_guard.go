@@ -0,0 +1,230 @@
+package services
+
+import (
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	AuditLoginLockout = "login.lockout"
+	AuditLoginUnlock  = "login.unlock"
+)
+
+// LoginLimits configures how failed logins are throttled. Every failure of
+// an email doubles the time before the next attempt, starting at Delay and
+// capped at MaxDelay. MaxFailures failures of an email, or MaxIpFailures of
+// a client ip, within Window lock it out for Lockout.
+type LoginLimits struct {
+	MaxFailures   int
+	MaxIpFailures int
+	Window        time.Duration
+	Lockout       time.Duration
+	Delay         time.Duration
+	MaxDelay      time.Duration
+}
+
+type LoginGuardService interface {
+	Check(ctx context.Context, email string) error
+	Failed(ctx context.Context, email string) error
+	Succeeded(ctx context.Context, email string) error
+	Unlock(ctx context.Context, userId string) error
+}
+
+type loginGuardService struct {
+	repo      repositories.LoginAttemptRepo
+	userRepo  repositories.UserRepo
+	auditRepo repositories.AuditRepo
+	ownership ownership
+	limits    LoginLimits
+}
+
+func NewLoginGuardService(
+	repo repositories.LoginAttemptRepo,
+	userRepo repositories.UserRepo,
+	auditRepo repositories.AuditRepo,
+	ownershipRepo repositories.OwnershipRepo,
+	limits LoginLimits) LoginGuardService {
+	return &loginGuardService{
+		repo:      repo,
+		userRepo:  userRepo,
+		auditRepo: auditRepo,
+		ownership: ownership{repo: ownershipRepo},
+		limits:    limits,
+	}
+}
+
+// Check refuses a login attempt while the email or the client ip is locked
+// out, or while the delay after the last failure of the email has not passed
+func (s *loginGuardService) Check(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "LoginGuardService.Check")
+	defer span.End()
+
+	now := time.Now()
+	email = repositories.NormalizeEmail(email)
+
+	for _, subject := range s.subjects(ctx, email) {
+		until, err := s.repo.LockedUntil(ctx, subject.kind, subject.value)
+		if err != nil {
+			return err
+		}
+		if until > now.Unix() {
+			return tooManyAttempts("too many failed login attempts", until-now.Unix())
+		}
+	}
+
+	count, last, err := s.repo.CountFailures(ctx, repositories.LoginSubjectEmail, email, now.Add(-s.limits.Window).Unix())
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	delay := s.limits.MaxDelay
+	if count <= 32 && s.limits.Delay<<(count-1) < delay {
+		delay = s.limits.Delay << (count - 1)
+	}
+	next := time.Unix(last, 0).Add(delay)
+	if now.Before(next) {
+		return tooManyAttempts("login attempted too soon after a failed attempt", int64(next.Sub(now).Seconds())+1)
+	}
+
+	return nil
+}
+
+// Failed records a failed login and locks the email or the client ip out when
+// it failed too often
+func (s *loginGuardService) Failed(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "LoginGuardService.Failed")
+	defer span.End()
+
+	now := time.Now()
+	email = repositories.NormalizeEmail(email)
+	ip := clientIp(ctx)
+
+	if err := s.repo.AddFailure(ctx, email, ip); err != nil {
+		return err
+	}
+
+	limits := map[string]int{
+		repositories.LoginSubjectEmail: s.limits.MaxFailures,
+		repositories.LoginSubjectIp:    s.limits.MaxIpFailures,
+	}
+	for _, subject := range s.subjects(ctx, email) {
+		count, _, err := s.repo.CountFailures(ctx, subject.kind, subject.value, now.Add(-s.limits.Window).Unix())
+		if err != nil {
+			return err
+		}
+		if count < limits[subject.kind] {
+			continue
+		}
+
+		until := now.Add(s.limits.Lockout)
+		if err = s.repo.Lock(ctx, subject.kind, subject.value, until.Unix()); err != nil {
+			return err
+		}
+		// The failures are cleared so that the email or ip starts over once
+		// the lockout ends
+		if err = s.repo.ClearFailures(ctx, subject.kind, subject.value); err != nil {
+			return err
+		}
+
+		entry := repositories.AuditEntry{
+			Action:  AuditLoginLockout,
+			Target:  subject.kind + ":" + subject.value,
+			Details: map[string]interface{}{"failures": count, "lockedUntil": until.Unix()},
+			Ip:      ip,
+		}
+		if subject.kind == repositories.LoginSubjectEmail {
+			if user, err := s.userRepo.FindOneByEmail(ctx, email); err == nil {
+				entry.AccountId = user.AccountId
+			}
+		}
+		if err = s.auditRepo.Create(ctx, entry); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Warn("locked out", "target", entry.Target, "until", until.Format(time.RFC3339))
+	}
+
+	// Failures outside the window are no longer needed
+	return s.repo.PurgeFailures(ctx, now.Add(-s.limits.Window).Unix())
+}
+
+func (s *loginGuardService) Succeeded(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "LoginGuardService.Succeeded")
+	defer span.End()
+
+	return s.repo.ClearFailures(ctx, repositories.LoginSubjectEmail, repositories.NormalizeEmail(email))
+}
+
+// Unlock lifts the lockout of a user of the caller's account
+func (s *loginGuardService) Unlock(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "LoginGuardService.Unlock")
+	defer span.End()
+
+	if err := s.ownership.user(ctx, userId); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		return err
+	}
+	email := repositories.NormalizeEmail(user.Email)
+
+	until, err := s.repo.LockedUntil(ctx, repositories.LoginSubjectEmail, email)
+	if err != nil {
+		return err
+	}
+	if until <= time.Now().Unix() {
+		return utils.NewConflictError("user is not locked out", nil)
+	}
+
+	if err = s.repo.Unlock(ctx, repositories.LoginSubjectEmail, email); err != nil {
+		return err
+	}
+	if err = s.repo.ClearFailures(ctx, repositories.LoginSubjectEmail, email); err != nil {
+		return err
+	}
+
+	actorId, _ := ctx.Value("userId").(string)
+	accountId, _ := ctx.Value("accountId").(string)
+	return s.auditRepo.Create(ctx, repositories.AuditEntry{
+		AccountId: accountId,
+		ActorId:   actorId,
+		Action:    AuditLoginUnlock,
+		Target:    repositories.LoginSubjectEmail + ":" + email,
+		Details:   map[string]interface{}{"userId": userId},
+		Ip:        clientIp(ctx),
+	})
+}
+
+type loginSubject struct {
+	kind  string
+	value string
+}
+
+func (s *loginGuardService) subjects(ctx context.Context, email string) []loginSubject {
+	subjects := []loginSubject{{repositories.LoginSubjectEmail, email}}
+	if ip := clientIp(ctx); ip != "" {
+		subjects = append(subjects, loginSubject{repositories.LoginSubjectIp, ip})
+	}
+	return subjects
+}
+
+// clientIp returns the ip of the client that made the request, as set by the webserver
+func clientIp(ctx context.Context) string {
+	ip, _ := ctx.Value("clientIp").(string)
+	return ip
+}
+
+func tooManyAttempts(message string, retryAfter int64) error {
+	return utils.NewDomainError(http.StatusTooManyRequests, message, map[string]interface{}{
+		"retryAfter": retryAfter,
+	})
+}
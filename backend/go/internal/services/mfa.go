@@ -0,0 +1,400 @@
+package services
+
+import (
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/services/totp"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	mfaChallengeTtl         = 5 * time.Minute
+	mfaChallengeMaxAttempts = 5
+	mfaRecoveryCodes        = 10
+)
+
+// MfaEnrollment is returned when a user starts setting up an authenticator
+// app. The recovery codes are only shown this once.
+type MfaEnrollment struct {
+	Secret        string   `json:"secret"`
+	Uri           string   `json:"uri"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type MfaService interface {
+	Login(ctx context.Context, user repositories.User) (repositories.User, error)
+	Enroll(ctx context.Context) (MfaEnrollment, error)
+	Confirm(ctx context.Context, code string) error
+	Disable(ctx context.Context, code, recoveryCode string) error
+	EnrollChallenge(ctx context.Context, mfaToken string) (MfaEnrollment, error)
+	VerifyChallenge(ctx context.Context, mfaToken, code, recoveryCode string) (repositories.User, error)
+}
+
+type mfaService struct {
+	repo        repositories.MfaRepo
+	userRepo    repositories.UserRepo
+	accountRepo repositories.AccountRepo
+	sessions    SessionService
+	loginGuard  LoginGuardService
+	issuer      string
+}
+
+func NewMfaService(
+	repo repositories.MfaRepo,
+	userRepo repositories.UserRepo,
+	accountRepo repositories.AccountRepo,
+	sessions SessionService,
+	loginGuard LoginGuardService,
+	issuer string) MfaService {
+	return &mfaService{
+		repo:        repo,
+		userRepo:    userRepo,
+		accountRepo: accountRepo,
+		sessions:    sessions,
+		loginGuard:  loginGuard,
+		issuer:      issuer,
+	}
+}
+
+// Login completes the first step of a login. Users with an authenticator app,
+// or in an account that requires one, get an mfa token to present with a code
+// instead of a session. Deactivated users cannot log in.
+func (s *mfaService) Login(ctx context.Context, user repositories.User) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "MfaService.Login")
+	defer span.End()
+
+	if user.DeactivatedAt != 0 {
+		return repositories.User{}, userDeactivated()
+	}
+
+	account, err := s.accountRepo.Get(ctx, user.AccountId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	enrollment, err := s.repo.GetTotp(ctx, user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, err
+	}
+	enrolled := err == nil && enrollment.ConfirmedAt != 0
+
+	if !enrolled && !account.RequireMfa {
+		return s.sessions.Create(ctx, user)
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	_, err = s.repo.CreateChallenge(ctx, user.Id, user.AccountId, hashToken(token), time.Now().Add(mfaChallengeTtl).Unix())
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	return repositories.User{
+		MfaRequired:           true,
+		MfaEnrollmentRequired: !enrolled,
+		MfaToken:              token,
+	}, nil
+}
+
+// Enroll starts setting up an authenticator app for the caller
+func (s *mfaService) Enroll(ctx context.Context) (MfaEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "MfaService.Enroll")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return MfaEnrollment{}, err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return MfaEnrollment{}, fmt.Errorf("no userId")
+	}
+
+	return s.enroll(ctx, userId.(string))
+}
+
+// enroll replaces an enrollment that is not confirmed yet with a new secret
+// and recovery codes. A confirmed enrollment has to be disabled first.
+func (s *mfaService) enroll(ctx context.Context, userId string) (MfaEnrollment, error) {
+
+	enrollment, err := s.repo.GetTotp(ctx, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return MfaEnrollment{}, err
+	}
+	if err == nil && enrollment.ConfirmedAt != 0 {
+		return MfaEnrollment{}, mfaAlreadyEnabled()
+	}
+
+	return s.newEnrollment(ctx, userId)
+}
+
+// Confirm activates the caller's authenticator app with a code generated by it
+func (s *mfaService) Confirm(ctx context.Context, code string) error {
+	ctx, span := tracing.Start(ctx, "MfaService.Confirm")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return fmt.Errorf("no userId")
+	}
+
+	enrollment, err := s.repo.GetTotp(ctx, userId.(string))
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.NewValidationError("two-factor authentication is not being set up", nil)
+	}
+	if err != nil {
+		return err
+	}
+	if enrollment.ConfirmedAt != 0 {
+		return mfaAlreadyEnabled()
+	}
+
+	return s.verify(ctx, enrollment, code, "")
+}
+
+// Disable removes the caller's authenticator app, after checking a code or a
+// recovery code. It is refused when the account requires two-factor
+// authentication.
+func (s *mfaService) Disable(ctx context.Context, code, recoveryCode string) error {
+	ctx, span := tracing.Start(ctx, "MfaService.Disable")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return err
+	}
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return fmt.Errorf("no userId")
+	}
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	account, err := s.accountRepo.Get(ctx, accountId)
+	if err != nil {
+		return err
+	}
+	if account.RequireMfa {
+		return utils.NewConflictError("the account requires two-factor authentication", nil)
+	}
+
+	enrollment, err := s.repo.GetTotp(ctx, userId.(string))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && enrollment.ConfirmedAt == 0) {
+		return utils.NewDomainError(http.StatusNotFound, "two-factor authentication is not enabled", nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = s.verify(ctx, enrollment, code, recoveryCode); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteTotp(ctx, userId.(string))
+}
+
+// EnrollChallenge starts setting up an authenticator app during a login, for
+// users of accounts that require one
+func (s *mfaService) EnrollChallenge(ctx context.Context, mfaToken string) (MfaEnrollment, error) {
+	ctx, span := tracing.Start(ctx, "MfaService.EnrollChallenge")
+	defer span.End()
+
+	challenge, err := s.challenge(ctx, mfaToken)
+	if err != nil {
+		return MfaEnrollment{}, err
+	}
+
+	return s.enroll(ctx, challenge.UserId)
+}
+
+// VerifyChallenge completes a login with a code or a recovery code. A code
+// of an authenticator app that was set up during the login also confirms it.
+// Wrong codes count as failed logins of the user's email, so that guessing
+// codes over new challenges ends in a lockout.
+func (s *mfaService) VerifyChallenge(ctx context.Context, mfaToken, code, recoveryCode string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "MfaService.VerifyChallenge")
+	defer span.End()
+
+	challenge, err := s.challenge(ctx, mfaToken)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.userRepo.Get(ctx, challenge.UserId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if err = s.loginGuard.Check(ctx, user.Email); err != nil {
+		return repositories.User{}, err
+	}
+
+	enrollment, err := s.repo.GetTotp(ctx, challenge.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, utils.NewValidationError("two-factor authentication is not set up", nil)
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	if err = s.verify(ctx, enrollment, code, recoveryCode); err != nil {
+		if fe := s.repo.FailChallenge(ctx, challenge.Id); fe != nil {
+			return repositories.User{}, fe
+		}
+		if ge := s.loginGuard.Failed(ctx, user.Email); ge != nil {
+			return repositories.User{}, ge
+		}
+		return repositories.User{}, err
+	}
+
+	if err = s.repo.CompleteChallenge(ctx, challenge.Id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.User{}, unauthorized()
+		}
+		return repositories.User{}, err
+	}
+
+	if err = s.loginGuard.Succeeded(ctx, user.Email); err != nil {
+		return repositories.User{}, err
+	}
+
+	// The user can have left the account during the login
+	user, err = s.userRepo.GetMember(ctx, challenge.AccountId, challenge.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, unauthorized()
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if user.DeactivatedAt != 0 {
+		return repositories.User{}, userDeactivated()
+	}
+
+	return s.sessions.Create(ctx, user)
+}
+
+func (s *mfaService) newEnrollment(ctx context.Context, userId string) (MfaEnrollment, error) {
+
+	user, err := s.userRepo.Get(ctx, userId)
+	if err != nil {
+		return MfaEnrollment{}, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return MfaEnrollment{}, err
+	}
+
+	codes := make([]string, mfaRecoveryCodes)
+	hashes := make([]string, mfaRecoveryCodes)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return MfaEnrollment{}, err
+		}
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	if err = s.repo.SaveTotp(ctx, userId, secret, hashes); err != nil {
+		return MfaEnrollment{}, err
+	}
+
+	return MfaEnrollment{
+		Secret:        secret,
+		Uri:           totp.URI(s.issuer, user.Email, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// verify checks a code of the authenticator app, or a recovery code once the
+// app is confirmed. Codes can be used only once.
+func (s *mfaService) verify(ctx context.Context, enrollment repositories.Totp, code, recoveryCode string) error {
+
+	if recoveryCode != "" {
+		if enrollment.ConfirmedAt == 0 {
+			return invalidMfaCode()
+		}
+		err := s.repo.UseRecoveryCode(ctx, enrollment.UserId, hashToken(normalizeRecoveryCode(recoveryCode)))
+		if errors.Is(err, sql.ErrNoRows) {
+			return invalidMfaCode()
+		}
+		return err
+	}
+
+	step, ok, err := totp.Validate(enrollment.Secret, code, time.Now(), enrollment.LastStep)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return invalidMfaCode()
+	}
+
+	if enrollment.ConfirmedAt == 0 {
+		err = s.repo.ConfirmTotp(ctx, enrollment.UserId, step)
+	} else {
+		err = s.repo.UseTotpStep(ctx, enrollment.UserId, step)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return invalidMfaCode()
+	}
+
+	return err
+}
+
+func (s *mfaService) challenge(ctx context.Context, mfaToken string) (repositories.MfaChallenge, error) {
+
+	challenge, err := s.repo.FindChallenge(ctx, hashToken(mfaToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.MfaChallenge{}, unauthorized()
+		}
+		return repositories.MfaChallenge{}, err
+	}
+
+	if challenge.CompletedAt != 0 ||
+		challenge.ExpiresAt <= time.Now().Unix() ||
+		challenge.Attempts >= mfaChallengeMaxAttempts {
+		return repositories.MfaChallenge{}, unauthorized()
+	}
+
+	return challenge, nil
+}
+
+// newRecoveryCode returns a code like "k7d2q-xm4ta"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func invalidMfaCode() error {
+	return utils.NewUnauthorizedError("invalid code")
+}
+
+func userDeactivated() error {
+	return utils.NewForbiddenError("user is deactivated")
+}
+
+func mfaAlreadyEnabled() error {
+	return utils.NewConflictError("two-factor authentication is already enabled", nil)
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/services/oidc"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OidcLoginTtl is how long users have to log in at the identity provider
+const OidcLoginTtl = 10 * time.Minute
+
+// OidcProvisioning selects the account and role of users that log in with
+// the identity provider for the first time. Without an account such users
+// are turned away.
+type OidcProvisioning struct {
+	AccountId string
+	RoleId    string
+}
+
+type OidcService interface {
+	Login(ctx context.Context) (url, state string, err error)
+	Callback(ctx context.Context, code, state, browserState string) (repositories.User, error)
+}
+
+type oidcService struct {
+	txProvider   database.TxProvider
+	repo         repositories.OidcLoginRepo
+	provider     *oidc.Provider
+	userRepo     repositories.UserRepo
+	roleRepo     repositories.RoleRepo
+	authz        authz.Client
+	mfa          MfaService
+	provisioning OidcProvisioning
+}
+
+func NewOidcService(
+	txProvider database.TxProvider,
+	repo repositories.OidcLoginRepo,
+	provider *oidc.Provider,
+	userRepo repositories.UserRepo,
+	roleRepo repositories.RoleRepo,
+	authzClient authz.Client,
+	mfa MfaService,
+	provisioning OidcProvisioning) OidcService {
+	return &oidcService{
+		txProvider:   txProvider,
+		repo:         repo,
+		provider:     provider,
+		userRepo:     userRepo,
+		roleRepo:     roleRepo,
+		authz:        authzClient,
+		mfa:          mfa,
+		provisioning: provisioning,
+	}
+}
+
+// Login starts a login at the identity provider and returns the url to send
+// the user to, with the state that the browser keeps to complete the login
+func (s *oidcService) Login(ctx context.Context) (string, string, error) {
+	ctx, span := tracing.Start(ctx, "OidcService.Login")
+	defer span.End()
+
+	state, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := newOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = s.repo.Create(ctx, repositories.OidcLogin{
+		StateHash:    hashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(OidcLoginTtl).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	url, err := s.provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+	return url, state, nil
+}
+
+// Callback completes a login at the identity provider. The verified email
+// address of the ID token is mapped to a user, who is provisioned when there
+// is none yet and provisioning is configured. Users are then logged in as
+// with a password, so the account can still ask for a second factor. The
+// state must be the one the browser kept when it started the login, so that
+// nobody can make a browser complete a login of theirs.
+func (s *oidcService) Callback(ctx context.Context, code, state, browserState string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "OidcService.Callback")
+	defer span.End()
+
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return repositories.User{}, invalidOidcLogin()
+	}
+
+	login, err := s.repo.Consume(ctx, hashToken(state))
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, invalidOidcLogin()
+	}
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if login.ExpiresAt <= time.Now().Unix() {
+		return repositories.User{}, invalidOidcLogin()
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		logging.FromContext(ctx).Warn("code exchange failed", "error", err)
+		return repositories.User{}, utils.NewUnauthorizedError("login at the identity provider failed")
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" || !claims.EmailVerified {
+		return repositories.User{}, utils.NewForbiddenError(
+			"the identity provider has not verified the email address")
+	}
+
+	user, err := s.userRepo.FindOneByEmail(ctx, email)
+	if err != nil {
+		if user, err = s.provision(ctx, email, claims.Name); err != nil {
+			return repositories.User{}, err
+		}
+	} else if !user.EmailVerified {
+		// The identity provider vouches for the email address
+		if err = s.userRepo.MarkEmailVerified(ctx, user.Id, nil); err != nil {
+			return repositories.User{}, err
+		}
+		user.EmailVerified = true
+	}
+
+	return s.mfa.Login(ctx, user)
+}
+
+func (s *oidcService) provision(ctx context.Context, email, name string) (repositories.User, error) {
+
+	if s.provisioning.AccountId == "" {
+		return repositories.User{}, utils.NewForbiddenError("no user with this email address")
+	}
+
+	roleId := s.provisioning.RoleId
+	if roleId == "" {
+		roleId = RoleMember
+	}
+	if _, err := getAccountRole(ctx, s.roleRepo, s.provisioning.AccountId, roleId); err != nil {
+		return repositories.User{}, err
+	}
+
+	if name == "" {
+		name = email
+	}
+
+	// Provisioned users log in with the identity provider, nobody knows
+	// their password until they reset it
+	password, err := newOpaqueToken()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.userRepo.Save(ctx, s.provisioning.AccountId, email, password, name, roleId, tx)
+	if err == nil {
+		err = s.userRepo.MarkEmailVerified(ctx, user.Id, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return repositories.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.User{}, err
+	}
+	user.EmailVerified = true
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.AssignRole(ctx, roleId, user.Id, s.provisioning.AccountId); err != nil {
+		return repositories.User{}, err
+	}
+
+	return user, nil
+}
+
+func invalidOidcLogin() error {
+	return utils.NewValidationError("invalid or expired login", nil)
+}
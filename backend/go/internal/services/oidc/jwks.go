@@ -0,0 +1,194 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval limits how often the keys are fetched again for a
+// token signed with an unknown key, which happens after the provider rotated
+// its keys
+const jwksRefreshInterval = time.Minute
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// keySet caches the signing keys of the provider
+type keySet struct {
+	provider *Provider
+	uri      string
+
+	mu        sync.Mutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(provider *Provider, uri string) *keySet {
+	return &keySet{provider: provider, uri: uri}
+}
+
+// key returns the public key for a token with the kid header and algorithm.
+// Tokens without kid are accepted when the provider has a single key.
+func (ks *keySet) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.lookup(kid)
+	if !ok && time.Since(ks.fetchedAt) > jwksRefreshInterval {
+		if err := ks.fetch(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = ks.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", kid)
+	}
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", alg, kid)
+	}
+	if !algorithmFits(alg, key.key) {
+		return nil, fmt.Errorf("signing method %s does not fit key %s", alg, kid)
+	}
+
+	return key.key, nil
+}
+
+func (ks *keySet) lookup(kid string) (publicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = ks.provider.do(req, &jwks); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	ks.fetchedAt = time.Now()
+
+	keys := make(map[string]publicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Keys of unsupported types are of no use for verifying tokens
+			continue
+		}
+		keys[k.Kid] = publicKey{alg: k.Alg, key: key}
+	}
+	ks.keys = keys
+
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func algorithmFits(alg string, key crypto.PublicKey) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg, "ES")
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	default:
+		return false
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
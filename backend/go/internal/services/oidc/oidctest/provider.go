@@ -0,0 +1,211 @@
+// Package oidctest provides a stand-in OpenID Connect identity provider for
+// tests, which runs the authorization code flow with PKCE and signs ID tokens
+// with a key of its own.
+package oidctest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const keyId = "test-key"
+
+// User is who logs in at the provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider serves the discovery document, the keys and the token endpoint of
+// an identity provider. Users log in with Authorize, which hands out the code
+// that the token endpoint exchanges.
+type Provider struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+	// Audience overrides the audience of the ID tokens, which is the client
+	Audience string
+
+	key ed25519.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+type authorization struct {
+	user        User
+	nonce       string
+	challenge   string
+	redirectUri string
+}
+
+// NewProvider starts a provider for the client, which the caller closes
+func NewProvider(clientId, clientSecret string) *Provider {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.keys)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// Issuer is the issuer the provider is configured with
+func (p *Provider) Issuer() string {
+	return p.URL
+}
+
+// Authorize logs the user in at the url that a login was sent to, and
+// returns the url that the provider redirects the browser back to
+func (p *Provider) Authorize(authUrl string, user User) (string, error) {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if query.Get("client_id") != p.ClientId {
+		return "", fmt.Errorf("unknown client %s", query.Get("client_id"))
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		return "", fmt.Errorf("unsupported code challenge method %s", query.Get("code_challenge_method"))
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		user:        user,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectUri: query.Get("redirect_uri"),
+	}
+	p.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", err
+	}
+	redirectQuery := redirect.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirect.RawQuery = redirectQuery.Encode()
+	return redirect.String(), nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+		"jwks_uri":               p.URL + "/keys",
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, r *http.Request) {
+	public := p.key.Public().(ed25519.PublicKey)
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": keyId,
+			"use": "sig",
+			"alg": "EdDSA",
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}},
+	})
+}
+
+// token exchanges a code for an ID token, once, when the client presents
+// its secret and the verifier of the code challenge
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	clientId, clientSecret, _ := r.BasicAuth()
+	clientId, _ = url.QueryUnescape(clientId)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientId != p.ClientId || clientSecret != p.ClientSecret {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	code := r.PostForm.Get("code")
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || auth.redirectUri != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	audience := p.Audience
+	if audience == "" {
+		audience = p.ClientId
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            auth.user.Subject,
+		"aud":            audience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.user.Email,
+		"email_verified": auth.user.EmailVerified,
+		"name":           auth.user.Name,
+	})
+	token.Header["kid"] = keyId
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,254 @@
+package oidc
+
+import (
+	"cerberus-examples/internal/tracing"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config identifies the client at the identity provider.
+type Config struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+}
+
+// Claims are the claims of a verified ID token that logins are based on.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider runs the authorization code flow with PKCE against an OpenID
+// Connect identity provider. Its endpoints are discovered on first use, so
+// that the api starts while the provider is unreachable.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+func NewProvider(config Config) *Provider {
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the url at the provider where the user logs in. The
+// code challenge is derived from the verifier, which is presented when the
+// code is exchanged.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientId},
+		"redirect_uri":          {p.config.RedirectUrl},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the authorization code for the tokens of the user and
+// returns the verified claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectUrl},
+		"client_id":     {p.config.ClientId},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientId), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err = p.do(req, &tokens); err != nil {
+		return Claims{}, fmt.Errorf("token request: %w", err)
+	}
+	if tokens.IdToken == "" {
+		return Claims{}, fmt.Errorf("token response without id_token")
+	}
+
+	return p.Verify(ctx, tokens.IdToken, nonce)
+}
+
+// Verify checks the signature of an ID token against the keys of the
+// provider, and that it was issued by the provider to this client for the
+// login with the nonce.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keySet(d).key(ctx, kid, token.Method.Alg())
+	}, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}))
+	if err != nil {
+		return Claims{}, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(d.Issuer, true) {
+		return Claims{}, fmt.Errorf("unexpected issuer %v", claims["iss"])
+	}
+	if !claims.VerifyAudience(p.config.ClientId, true) {
+		return Claims{}, fmt.Errorf("unexpected audience %v", claims["aud"])
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientId {
+		return Claims{}, fmt.Errorf("unexpected authorized party %s", azp)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return Claims{}, fmt.Errorf("missing exp claim")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return Claims{}, fmt.Errorf("nonce mismatch")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return Claims{}, fmt.Errorf("missing sub claim")
+	}
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+
+	return Claims{
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: emailVerified(claims["email_verified"]),
+		Name:          name,
+	}, nil
+}
+
+// emailVerified accepts the boolean of the spec as well as the string that
+// some providers send instead
+func emailVerified(claim interface{}) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var d discovery
+	if err = p.do(req, &d); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %s does not match %s", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, fmt.Errorf("discovery: missing endpoints")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *Provider) keySet(d *discovery) *keySet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil {
+		p.keys = newKeySet(p, d.JwksUri)
+	}
+	return p.keys
+}
+
+func (p *Provider) do(req *http.Request, v interface{}) (err error) {
+	_, span := tracing.StartClient(req.Context(), "HTTP "+req.Method, req.Header,
+		"http.method", req.Method,
+		"http.url", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path,
+	)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	span.SetAttributes("http.status_code", res.StatusCode)
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", res.Status, body)
+	}
+
+	return json.Unmarshal(body, v)
+}
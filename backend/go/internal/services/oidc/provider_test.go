@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"cerberus-examples/internal/services/oidc/oidctest"
+	"context"
+	"net/url"
+	"testing"
+)
+
+const (
+	testClientId     = "client"
+	testClientSecret = "secret"
+	testRedirectUrl  = "http://localhost/auth/oidc/callback"
+)
+
+var testUser = oidctest.User{
+	Subject:       "subject",
+	Email:         "user@example.com",
+	EmailVerified: true,
+	Name:          "User",
+}
+
+func newTestProvider(t *testing.T) (*oidctest.Provider, *Provider) {
+	t.Helper()
+
+	standIn := oidctest.NewProvider(testClientId, testClientSecret)
+	t.Cleanup(standIn.Close)
+	return standIn, NewProvider(Config{
+		Issuer:       standIn.Issuer(),
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		RedirectUrl:  testRedirectUrl,
+	})
+}
+
+// login logs the user in at the stand-in provider for the url of a login, and
+// returns the code of the redirect back
+func login(t *testing.T, standIn *oidctest.Provider, authUrl string) string {
+	t.Helper()
+
+	redirect, err := standIn.Authorize(authUrl, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	standIn, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	authUrl, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := login(t, standIn, authUrl)
+
+	claims, err := provider.Exchange(ctx, code, "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Claims{Subject: testUser.Subject, Email: testUser.Email, EmailVerified: true, Name: testUser.Name}
+	if claims != expected {
+		t.Errorf("expected claims %+v, got %+v", expected, claims)
+	}
+
+	// Codes are exchanged once
+	if _, err = provider.Exchange(ctx, code, "verifier", "nonce"); err == nil {
+		t.Error("expected a code to be exchanged once")
+	}
+}
+
+func TestExchangeRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		audience string
+	}{
+		{name: "wrong code verifier", verifier: "other", nonce: "nonce"},
+		{name: "wrong nonce", verifier: "verifier", nonce: "other"},
+		{name: "other audience", verifier: "verifier", nonce: "nonce", audience: "other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			standIn, provider := newTestProvider(t)
+			standIn.Audience = test.audience
+			ctx := context.Background()
+
+			authUrl, err := provider.AuthCodeURL(ctx, "state", "nonce", "verifier")
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := login(t, standIn, authUrl)
+
+			if _, err = provider.Exchange(ctx, code, test.verifier, test.nonce); err == nil {
+				t.Error("expected the exchange to fail")
+			}
+		})
+	}
+}
+
+func TestDiscoveryOfOtherIssuer(t *testing.T) {
+	standIn, _ := newTestProvider(t)
+	provider := NewProvider(Config{
+		Issuer:      standIn.Issuer() + "/",
+		ClientId:    testClientId,
+		RedirectUrl: testRedirectUrl,
+	})
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("expected a provider that names another issuer to be refused")
+	}
+}
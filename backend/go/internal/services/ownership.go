@@ -0,0 +1,86 @@
+package services
+
+import (
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ownership checks that resources belong to the caller's account.
+// Resources of other accounts are reported as not found so that their
+// existence is not disclosed.
+type ownership struct {
+	repo repositories.OwnershipRepo
+}
+
+func (o ownership) account(ctx context.Context, accountId string) error {
+	callerAccountId, err := callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if accountId != callerAccountId {
+		return notFound("account")
+	}
+	return nil
+}
+
+func (o ownership) project(ctx context.Context, projectId string) error {
+	return o.check(ctx, "project", projectId, o.repo.ProjectAccount)
+}
+
+func (o ownership) sprint(ctx context.Context, sprintId string) error {
+	return o.check(ctx, "sprint", sprintId, o.repo.SprintAccount)
+}
+
+func (o ownership) story(ctx context.Context, storyId string) error {
+	return o.check(ctx, "story", storyId, o.repo.StoryAccount)
+}
+
+func (o ownership) user(ctx context.Context, userId string) error {
+	callerAccountId, err := callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = o.repo.AccountMember(ctx, callerAccountId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound("user")
+	}
+	return err
+}
+
+func (o ownership) check(ctx context.Context, resource, id string, resolve func(context.Context, string) (string, error)) error {
+	callerAccountId, err := callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	accountId, err := resolve(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound(resource)
+		}
+		return err
+	}
+
+	if accountId != callerAccountId {
+		return notFound(resource)
+	}
+
+	return nil
+}
+
+func callerAccount(ctx context.Context) (string, error) {
+	accountId, ok := ctx.Value("accountId").(string)
+	if !ok || accountId == "" {
+		return "", fmt.Errorf("no accountId")
+	}
+	return accountId, nil
+}
+
+func notFound(resource string) error {
+	return utils.NewNotFoundError(resource)
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/mail"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type PasswordResetService interface {
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, plainPassword string) error
+}
+
+type passwordResetService struct {
+	txProvider database.TxProvider
+	repo       repositories.PasswordResetRepo
+	userRepo   repositories.UserRepo
+	sessions   SessionService
+	mailer     mail.Mailer
+	appUrl     string
+	ttl        time.Duration
+}
+
+func NewPasswordResetService(
+	txProvider database.TxProvider,
+	repo repositories.PasswordResetRepo,
+	userRepo repositories.UserRepo,
+	sessions SessionService,
+	mailer mail.Mailer,
+	appUrl string,
+	ttl time.Duration) PasswordResetService {
+	return &passwordResetService{
+		txProvider: txProvider,
+		repo:       repo,
+		userRepo:   userRepo,
+		sessions:   sessions,
+		mailer:     mailer,
+		appUrl:     appUrl,
+		ttl:        ttl,
+	}
+}
+
+// ForgotPassword mails a reset link to the user with the given email. It
+// succeeds for unknown emails too, so that it can't be used to find accounts.
+func (s *passwordResetService) ForgotPassword(ctx context.Context, email string) error {
+	ctx, span := tracing.Start(ctx, "PasswordResetService.ForgotPassword")
+	defer span.End()
+
+	user, err := s.userRepo.FindOneByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.ttl)
+	if _, err = s.repo.Create(ctx, user.Id, hashToken(token), expiresAt.Unix()); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.appUrl, url.QueryEscape(token))
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. "+
+			"It expires at %s.\n\n%s\n\nIf you did not ask for this, you can ignore this mail.",
+			user.Name, expiresAt.UTC().Format(time.RFC1123), link),
+	})
+}
+
+// ResetPassword sets a new password with a reset token. The token can be used
+// once, and all sessions of the user are revoked afterwards.
+func (s *passwordResetService) ResetPassword(ctx context.Context, token, plainPassword string) (err error) {
+	ctx, span := tracing.Start(ctx, "PasswordResetService.ResetPassword")
+	defer span.End()
+
+	reset, err := s.repo.FindByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return invalidResetToken()
+	}
+	if err != nil {
+		return err
+	}
+	if reset.UsedAt != 0 || reset.ExpiresAt <= time.Now().Unix() {
+		return invalidResetToken()
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.Consume(ctx, reset, tx); err == nil {
+		err = s.userRepo.SetPassword(ctx, reset.UserId, plainPassword, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return invalidResetToken()
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	return s.sessions.RevokeAll(ctx, reset.UserId)
+}
+
+func invalidResetToken() error {
+	return utils.NewValidationError("invalid or expired reset token", nil)
+}
@@ -1,10 +1,14 @@
 package services
 
 import (
+	"cerberus-examples/internal/authz"
 	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
 	"context"
 	"fmt"
+	"strings"
 )
 
 type ProjectService interface {
@@ -12,35 +16,71 @@ type ProjectService interface {
 	FindAll(ctx context.Context, accountId string) ([]repositories.Project, error)
 	Get(ctx context.Context, projectId string) (repositories.Project, error)
 	Delete(ctx context.Context, projectId string) error
+	GetWorkflow(ctx context.Context, projectId string) (repositories.Workflow, error)
+	UpdateWorkflow(ctx context.Context, projectId string, states []repositories.WorkflowState, transitions []repositories.WorkflowTransition) (repositories.Workflow, error)
 }
 
 type projectService struct {
 	txProvider database.TxProvider
 	repo       repositories.ProjectRepo
+	memberRepo repositories.ProjectMemberRepo
+	accounts   repositories.AccountRepo
+	workflows  repositories.WorkflowRepo
+	ownership  ownership
+	authz      authz.Client
+	authorizer authorizer
 }
 
 func NewProjectService(
 	txProvider database.TxProvider,
-	repo repositories.ProjectRepo) ProjectService {
+	repo repositories.ProjectRepo,
+	memberRepo repositories.ProjectMemberRepo,
+	accountRepo repositories.AccountRepo,
+	workflowRepo repositories.WorkflowRepo,
+	ownershipRepo repositories.OwnershipRepo,
+	authzClient authz.Client) ProjectService {
 	return &projectService{
 		txProvider: txProvider,
 		repo:       repo,
+		memberRepo: memberRepo,
+		accounts:   accountRepo,
+		workflows:  workflowRepo,
+		ownership:  ownership{repo: ownershipRepo},
+		authz:      authzClient,
+		authorizer: authorizer{client: authzClient},
 	}
 }
 
 func (s *projectService) Create(ctx context.Context, accountId, name, description string) (repositories.Project, error) {
+	ctx, span := tracing.Start(ctx, "ProjectService.Create")
+	defer span.End()
 
 	userId := ctx.Value("userId")
 	if userId == nil {
 		return repositories.Project{}, fmt.Errorf("no userId")
 	}
 
+	if err := s.ownership.account(ctx, accountId); err != nil {
+		return repositories.Project{}, err
+	}
+
+	if err := s.authorizer.require(ctx, accountId, PermissionProjectWrite); err != nil {
+		return repositories.Project{}, err
+	}
+
+	// The workflow of the project starts out with the story statuses of the
+	// account
+	account, err := s.accounts.Get(ctx, accountId)
+	if err != nil {
+		return repositories.Project{}, err
+	}
+
 	tx, err := s.txProvider.GetTransaction()
 	if err != nil {
 		return repositories.Project{}, err
 	}
 
-	project, err := s.repo.Create(accountId, name, description, tx)
+	project, err := s.repo.Create(ctx, accountId, name, description, tx)
 	if err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
 			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
@@ -48,17 +88,140 @@ func (s *projectService) Create(ctx context.Context, accountId, name, descriptio
 		return repositories.Project{}, err
 	}
 
-	return project, tx.Commit()
+	// The creator becomes the owner of the project
+	_, err = s.memberRepo.Add(ctx, project.Id, userId.(string), RoleOwner, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return repositories.Project{}, err
+	}
+
+	states, transitions := defaultWorkflow(account.Settings.StoryStatuses)
+	_, err = s.workflows.Create(ctx, project.Id, states, transitions, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return repositories.Project{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.Project{}, err
+	}
+	metrics.ProjectsCreated.Inc()
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.CreateResource(ctx, project.Id, accountId, authz.ResourceProject); err != nil {
+		return repositories.Project{}, err
+	}
+	if err = s.authz.AssignRole(ctx, RoleOwner, userId.(string), project.Id); err != nil {
+		return repositories.Project{}, err
+	}
+
+	return project, nil
 }
 
 func (s *projectService) FindAll(ctx context.Context, accountId string) ([]repositories.Project, error) {
-	return s.repo.FindByAccount(accountId)
+	ctx, span := tracing.Start(ctx, "ProjectService.FindAll")
+	defer span.End()
+
+	if err := s.ownership.account(ctx, accountId); err != nil {
+		return []repositories.Project{}, err
+	}
+
+	permitted, err := s.authorizer.permitted(ctx, authz.ResourceProject, PermissionProjectRead)
+	if err != nil {
+		return []repositories.Project{}, err
+	}
+
+	projects, err := s.repo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return []repositories.Project{}, err
+	}
+
+	result := []repositories.Project{}
+	for _, project := range projects {
+		if permitted[project.Id] {
+			result = append(result, project)
+		}
+	}
+
+	return result, nil
 }
 
 func (s *projectService) Get(ctx context.Context, projectId string) (repositories.Project, error) {
-	return s.repo.Get(projectId)
+	ctx, span := tracing.Start(ctx, "ProjectService.Get")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return repositories.Project{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectRead); err != nil {
+		return repositories.Project{}, err
+	}
+	return s.repo.Get(ctx, projectId)
 }
 
 func (s *projectService) Delete(ctx context.Context, projectId string) error {
-	return s.repo.Delete(projectId)
+	ctx, span := tracing.Start(ctx, "ProjectService.Delete")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return err
+	}
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectDelete); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, projectId); err != nil {
+		return err
+	}
+	return s.authz.DeleteResource(ctx, projectId)
+}
+
+// GetWorkflow returns the states and transitions of the stories of the
+// project, which boards show as columns
+func (s *projectService) GetWorkflow(ctx context.Context, projectId string) (repositories.Workflow, error) {
+	ctx, span := tracing.Start(ctx, "ProjectService.GetWorkflow")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return repositories.Workflow{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectRead); err != nil {
+		return repositories.Workflow{}, err
+	}
+	return s.workflows.Get(ctx, projectId)
+}
+
+// UpdateWorkflow replaces the states and transitions of the stories of the
+// project. Stories in a state that the workflow no longer has may move to any
+// of its states.
+func (s *projectService) UpdateWorkflow(ctx context.Context, projectId string, states []repositories.WorkflowState, transitions []repositories.WorkflowTransition) (repositories.Workflow, error) {
+	ctx, span := tracing.Start(ctx, "ProjectService.UpdateWorkflow")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return repositories.Workflow{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectWrite); err != nil {
+		return repositories.Workflow{}, err
+	}
+
+	for i := range states {
+		states[i].Name = strings.TrimSpace(states[i].Name)
+	}
+	for i := range transitions {
+		transitions[i].From = strings.TrimSpace(transitions[i].From)
+		transitions[i].To = strings.TrimSpace(transitions[i].To)
+	}
+	if err := validateWorkflow(states, transitions); err != nil {
+		return repositories.Workflow{}, err
+	}
+
+	if _, err := s.workflows.Replace(ctx, projectId, states, transitions, nil); err != nil {
+		return repositories.Workflow{}, err
+	}
+	return s.workflows.Get(ctx, projectId)
 }
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type ProjectMemberService interface {
+	Add(ctx context.Context, projectId, userId, roleId string) (repositories.ProjectMember, error)
+	FindByProject(ctx context.Context, projectId string) ([]repositories.ProjectMember, error)
+	ChangeRole(ctx context.Context, projectId, userId, roleId string) (repositories.ProjectMember, error)
+	Remove(ctx context.Context, projectId, userId string) error
+}
+
+type projectMemberService struct {
+	repo       repositories.ProjectMemberRepo
+	roleRepo   repositories.RoleRepo
+	ownership  ownership
+	authz      authz.Client
+	authorizer authorizer
+}
+
+func NewProjectMemberService(
+	repo repositories.ProjectMemberRepo,
+	roleRepo repositories.RoleRepo,
+	ownershipRepo repositories.OwnershipRepo,
+	authzClient authz.Client) ProjectMemberService {
+	return &projectMemberService{
+		repo:       repo,
+		roleRepo:   roleRepo,
+		ownership:  ownership{repo: ownershipRepo},
+		authz:      authzClient,
+		authorizer: authorizer{client: authzClient},
+	}
+}
+
+func (s *projectMemberService) Add(ctx context.Context, projectId, userId, roleId string) (repositories.ProjectMember, error) {
+	ctx, span := tracing.Start(ctx, "ProjectMemberService.Add")
+	defer span.End()
+
+	if err := s.requireMembersPermission(ctx, projectId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if err := s.ownership.user(ctx, userId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if _, err := getProjectRole(ctx, s.roleRepo, roleId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	_, err := s.repo.Get(ctx, projectId, userId)
+	if err == nil {
+		return repositories.ProjectMember{}, utils.NewConflictError("user is already a member of the project", nil)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return repositories.ProjectMember{}, err
+	}
+
+	if _, err = s.repo.Add(ctx, projectId, userId, roleId, nil); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if err = s.authz.AssignRole(ctx, roleId, userId, projectId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	return s.repo.Get(ctx, projectId, userId)
+}
+
+func (s *projectMemberService) FindByProject(ctx context.Context, projectId string) ([]repositories.ProjectMember, error) {
+	ctx, span := tracing.Start(ctx, "ProjectMemberService.FindByProject")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return []repositories.ProjectMember{}, err
+	}
+
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectRead); err != nil {
+		return []repositories.ProjectMember{}, err
+	}
+
+	return s.repo.FindByProject(ctx, projectId)
+}
+
+func (s *projectMemberService) ChangeRole(ctx context.Context, projectId, userId, roleId string) (repositories.ProjectMember, error) {
+	ctx, span := tracing.Start(ctx, "ProjectMemberService.ChangeRole")
+	defer span.End()
+
+	if err := s.requireMembersPermission(ctx, projectId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if _, err := getProjectRole(ctx, s.roleRepo, roleId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	member, err := s.getMember(ctx, projectId, userId)
+	if err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if member.RoleId == roleId {
+		return member, nil
+	}
+
+	if err = s.keepOwner(ctx, projectId, member); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if err = s.repo.ChangeRole(ctx, projectId, userId, roleId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if err = s.authz.UnassignRole(ctx, member.RoleId, userId, projectId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	if err = s.authz.AssignRole(ctx, roleId, userId, projectId); err != nil {
+		return repositories.ProjectMember{}, err
+	}
+
+	return s.repo.Get(ctx, projectId, userId)
+}
+
+func (s *projectMemberService) Remove(ctx context.Context, projectId, userId string) error {
+	ctx, span := tracing.Start(ctx, "ProjectMemberService.Remove")
+	defer span.End()
+
+	if err := s.requireMembersPermission(ctx, projectId); err != nil {
+		return err
+	}
+
+	member, err := s.getMember(ctx, projectId, userId)
+	if err != nil {
+		return err
+	}
+
+	if err = s.keepOwner(ctx, projectId, member); err != nil {
+		return err
+	}
+
+	if err = s.repo.Remove(ctx, projectId, userId); err != nil {
+		return err
+	}
+
+	return s.authz.UnassignRole(ctx, member.RoleId, userId, projectId)
+}
+
+func (s *projectMemberService) requireMembersPermission(ctx context.Context, projectId string) error {
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return err
+	}
+	return s.authorizer.requireVisible(ctx, "project", projectId, PermissionProjectMembers)
+}
+
+func (s *projectMemberService) getMember(ctx context.Context, projectId, userId string) (repositories.ProjectMember, error) {
+	member, err := s.repo.Get(ctx, projectId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.ProjectMember{}, notFound("member")
+	}
+	return member, err
+}
+
+// keepOwner prevents the last owner of a project from being removed or demoted
+func (s *projectMemberService) keepOwner(ctx context.Context, projectId string, member repositories.ProjectMember) error {
+	if member.RoleId != RoleOwner {
+		return nil
+	}
+
+	owners, err := s.repo.CountByRole(ctx, projectId, RoleOwner)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return utils.NewConflictError("a project needs at least one owner", nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	PermissionProjectRead    = "project:read"
+	PermissionProjectWrite   = "project:write"
+	PermissionProjectDelete  = "project:delete"
+	PermissionProjectMembers = "project:members"
+	PermissionSprintRead     = "sprint:read"
+	PermissionSprintWrite    = "sprint:write"
+	PermissionStoryRead      = "story:read"
+	PermissionStoryWrite     = "story:write"
+	PermissionUserRead       = "user:read"
+	PermissionUserWrite      = "user:write"
+	PermissionRoleRead       = "role:read"
+	PermissionRoleWrite      = "role:write"
+	PermissionAccountWrite   = "account:write"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleViewer = "viewer"
+)
+
+// Built-in roles that are granted to members of a single project
+const (
+	RoleOwner       = "owner"
+	RoleMaintainer  = "maintainer"
+	RoleContributor = "contributor"
+	RoleReporter    = "reporter"
+)
+
+// Permissions lists every permission that can be granted to a role
+var Permissions = []string{
+	PermissionProjectRead,
+	PermissionProjectWrite,
+	PermissionProjectDelete,
+	PermissionProjectMembers,
+	PermissionSprintRead,
+	PermissionSprintWrite,
+	PermissionStoryRead,
+	PermissionStoryWrite,
+	PermissionUserRead,
+	PermissionUserWrite,
+	PermissionRoleRead,
+	PermissionRoleWrite,
+	PermissionAccountWrite,
+}
+
+type RoleService interface {
+	Create(ctx context.Context, name string, permissions []string) (repositories.Role, error)
+	FindAll(ctx context.Context) ([]repositories.Role, error)
+	Get(ctx context.Context, roleId string) (repositories.Role, error)
+	Delete(ctx context.Context, roleId string) error
+	HasPermission(ctx context.Context, userId, permission string) (bool, error)
+	Sync(ctx context.Context) error
+}
+
+type roleService struct {
+	txProvider database.TxProvider
+	repo       repositories.RoleRepo
+	authz      authz.Client
+}
+
+func NewRoleService(
+	txProvider database.TxProvider,
+	repo repositories.RoleRepo,
+	authzClient authz.Client) RoleService {
+	return &roleService{
+		txProvider: txProvider,
+		repo:       repo,
+		authz:      authzClient,
+	}
+}
+
+func (s *roleService) Create(ctx context.Context, name string, permissions []string) (repositories.Role, error) {
+	ctx, span := tracing.Start(ctx, "RoleService.Create")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return repositories.Role{}, fmt.Errorf("no accountId")
+	}
+
+	if name == "" {
+		return repositories.Role{}, utils.NewValidationError("missing role name", nil)
+	}
+
+	for _, permission := range permissions {
+		if !isPermission(permission) {
+			return repositories.Role{}, utils.NewValidationError("unknown permission",
+				map[string]interface{}{"permission": permission})
+		}
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return repositories.Role{}, err
+	}
+
+	role, err := s.repo.Create(ctx, accountId.(string), name, permissions, tx)
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return repositories.Role{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return repositories.Role{}, err
+	}
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.CreateRole(ctx, role.Id, role.Permissions); err != nil {
+		return repositories.Role{}, err
+	}
+
+	return role, nil
+}
+
+func (s *roleService) FindAll(ctx context.Context) ([]repositories.Role, error) {
+	ctx, span := tracing.Start(ctx, "RoleService.FindAll")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return []repositories.Role{}, fmt.Errorf("no accountId")
+	}
+
+	return s.repo.FindByAccount(ctx, accountId.(string))
+}
+
+func (s *roleService) Get(ctx context.Context, roleId string) (repositories.Role, error) {
+	ctx, span := tracing.Start(ctx, "RoleService.Get")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return repositories.Role{}, fmt.Errorf("no accountId")
+	}
+
+	return getAccountRole(ctx, s.repo, accountId.(string), roleId)
+}
+
+func (s *roleService) Delete(ctx context.Context, roleId string) error {
+	ctx, span := tracing.Start(ctx, "RoleService.Delete")
+	defer span.End()
+
+	role, err := s.Get(ctx, roleId)
+	if err != nil {
+		return err
+	}
+
+	if role.BuiltIn {
+		return utils.NewValidationError("built-in roles cannot be deleted", nil)
+	}
+
+	users, err := s.repo.CountUsers(ctx, roleId)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		return utils.NewConflictError("role is still assigned to users",
+			map[string]interface{}{"users": users})
+	}
+
+	if err := s.repo.Delete(ctx, roleId); err != nil {
+		return err
+	}
+
+	return s.authz.DeleteRole(ctx, roleId)
+}
+
+// HasPermission checks the permission against the role the user holds on the
+// caller's account, and against the scopes of the caller's api key
+func (s *roleService) HasPermission(ctx context.Context, userId, permission string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "RoleService.HasPermission")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return false, fmt.Errorf("no accountId")
+	}
+
+	if !scopeAllows(ctx, permission) {
+		return false, nil
+	}
+
+	return s.authz.HasPermission(ctx, userId, accountId.(string), permission)
+}
+
+// Sync pushes the permissions of all built-in and account roles to the
+// authorization client, so that it knows the roles shipped in migrations
+func (s *roleService) Sync(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "RoleService.Sync")
+	defer span.End()
+
+	roles, err := s.repo.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if err := s.authz.CreateRole(ctx, role.Id, role.Permissions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getAccountRole returns the role if it is built-in or defined by the account
+func getAccountRole(ctx context.Context, repo repositories.RoleRepo, accountId, roleId string) (repositories.Role, error) {
+	role, err := repo.Get(ctx, roleId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.Role{}, utils.NewNotFoundError("role")
+		}
+		return repositories.Role{}, err
+	}
+
+	if role.Scope != repositories.RoleScopeAccount || (!role.BuiltIn && role.AccountId != accountId) {
+		return repositories.Role{}, utils.NewNotFoundError("role")
+	}
+
+	return role, nil
+}
+
+// getProjectRole returns the role if it is one of the built-in project roles
+func getProjectRole(ctx context.Context, repo repositories.RoleRepo, roleId string) (repositories.Role, error) {
+	role, err := repo.Get(ctx, roleId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.Role{}, utils.NewNotFoundError("role")
+		}
+		return repositories.Role{}, err
+	}
+
+	if role.Scope != repositories.RoleScopeProject {
+		return repositories.Role{}, utils.NewNotFoundError("role")
+	}
+
+	return role, nil
+}
+
+func isPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,570 @@
+package services
+
+import (
+	"cerberus-examples/internal/authz"
+	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/scim"
+	"cerberus-examples/internal/tracing"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ScimService provisions the users of the caller's account from an identity
+// provider. SCIM groups are the account roles, so that adding a user to a
+// group gives the user that role. Users that are removed from a group fall
+// back to the member role.
+type ScimService interface {
+	FindUsers(ctx context.Context, filter string, startIndex, count int) (scim.ListResponse, error)
+	GetUser(ctx context.Context, userId string) (scim.User, error)
+	CreateUser(ctx context.Context, user scim.User) (scim.User, error)
+	ReplaceUser(ctx context.Context, userId string, user scim.User) (scim.User, error)
+	PatchUser(ctx context.Context, userId string, patch scim.PatchOp) (scim.User, error)
+	DeactivateUser(ctx context.Context, userId string) error
+	FindGroups(ctx context.Context, filter string, startIndex, count int, withMembers bool) (scim.ListResponse, error)
+	GetGroup(ctx context.Context, groupId string, withMembers bool) (scim.Group, error)
+	CreateGroup(ctx context.Context, group scim.Group) (scim.Group, error)
+	ReplaceGroup(ctx context.Context, groupId string, group scim.Group) (scim.Group, error)
+	PatchGroup(ctx context.Context, groupId string, patch scim.PatchOp) (scim.Group, error)
+	DeleteGroup(ctx context.Context, groupId string) error
+}
+
+type scimService struct {
+	txProvider database.TxProvider
+	userRepo   repositories.UserRepo
+	roleRepo   repositories.RoleRepo
+	authz      authz.Client
+	users      UserService
+	roles      RoleService
+	sessions   SessionService
+}
+
+func NewScimService(
+	txProvider database.TxProvider,
+	userRepo repositories.UserRepo,
+	roleRepo repositories.RoleRepo,
+	authzClient authz.Client,
+	users UserService,
+	roles RoleService,
+	sessions SessionService) ScimService {
+	return &scimService{
+		txProvider: txProvider,
+		userRepo:   userRepo,
+		roleRepo:   roleRepo,
+		authz:      authzClient,
+		users:      users,
+		roles:      roles,
+		sessions:   sessions,
+	}
+}
+
+func (s *scimService) FindUsers(ctx context.Context, filter string, startIndex, count int) (scim.ListResponse, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.FindUsers")
+	defer span.End()
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+
+	f, err := scim.ParseFilter(filter)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	if !f.Empty() && !f.Is("userName") && !f.Is("externalId") && !f.Is("id") && !f.Is("displayName") {
+		return scim.ListResponse{}, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidFilter,
+			"users can be filtered by userName, externalId, id and displayName")
+	}
+
+	users, err := s.userRepo.FindAll(ctx, accountId)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	roles, err := s.roleNames(ctx, accountId)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+
+	matches := []scim.User{}
+	for _, user := range users {
+		switch {
+		case f.Is("userName") && !strings.EqualFold(user.Email, f.Value),
+			f.Is("externalId") && user.ExternalId != f.Value,
+			f.Is("id") && user.Id != f.Value,
+			f.Is("displayName") && user.Name != f.Value:
+			continue
+		}
+		matches = append(matches, toScimUser(user, roles))
+	}
+
+	from, to := scim.Page(len(matches), startIndex, count)
+	return scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(matches),
+		StartIndex:   from + 1,
+		ItemsPerPage: to - from,
+		Resources:    matches[from:to],
+	}, nil
+}
+
+func (s *scimService) GetUser(ctx context.Context, userId string) (scim.User, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.GetUser")
+	defer span.End()
+
+	user, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	roles, err := s.roleNames(ctx, user.AccountId)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	return toScimUser(user, roles), nil
+}
+
+// CreateUser adds a user with the member role to the caller's account. The
+// identity provider vouches for the email address, and the user logs in with
+// it, so nobody knows the password until it is reset.
+func (s *scimService) CreateUser(ctx context.Context, user scim.User) (scim.User, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.CreateUser")
+	defer span.End()
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	email := strings.TrimSpace(user.UserName)
+	if email == "" {
+		return scim.User{}, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "missing userName")
+	}
+	if _, err = s.userRepo.FindOneByEmail(ctx, email); err == nil {
+		return scim.User{}, userNameTaken()
+	}
+
+	name := user.FullName()
+	if name == "" {
+		name = email
+	}
+
+	password, err := newOpaqueToken()
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	created, err := s.userRepo.Save(ctx, accountId, email, password, name, RoleMember, tx)
+	if err == nil {
+		err = s.userRepo.MarkEmailVerified(ctx, created.Id, tx)
+	}
+	if err == nil && user.ExternalId != "" {
+		err = s.userRepo.Update(ctx, created.Id, email, name, user.ExternalId, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return scim.User{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return scim.User{}, err
+	}
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.AssignRole(ctx, RoleMember, created.Id, accountId); err != nil {
+		return scim.User{}, err
+	}
+
+	if user.Active != nil && !*user.Active {
+		if err = s.userRepo.SetDeactivated(ctx, created.Id, true); err != nil {
+			return scim.User{}, err
+		}
+	}
+
+	return s.GetUser(ctx, created.Id)
+}
+
+func (s *scimService) ReplaceUser(ctx context.Context, userId string, user scim.User) (scim.User, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.ReplaceUser")
+	defer span.End()
+
+	current, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	return s.saveUser(ctx, current, user)
+}
+
+func (s *scimService) PatchUser(ctx context.Context, userId string, patch scim.PatchOp) (scim.User, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.PatchUser")
+	defer span.End()
+
+	current, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return scim.User{}, err
+	}
+
+	user := toScimUser(current, nil)
+	if err = scim.ApplyUserPatch(&user, patch); err != nil {
+		return scim.User{}, err
+	}
+
+	return s.saveUser(ctx, current, user)
+}
+
+// DeactivateUser deactivates rather than deletes a user, as stories and the
+// audit log refer to users. The user is logged out everywhere.
+func (s *scimService) DeactivateUser(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "ScimService.DeactivateUser")
+	defer span.End()
+
+	user, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if user.HomeAccountId != user.AccountId {
+		return managedByOtherAccount()
+	}
+
+	return s.setActive(ctx, user, false)
+}
+
+func (s *scimService) saveUser(ctx context.Context, current repositories.User, user scim.User) (scim.User, error) {
+
+	email := repositories.NormalizeEmail(user.UserName)
+	if email == "" {
+		return scim.User{}, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidValue, "missing userName")
+	}
+	if email != current.Email {
+		if existing, err := s.userRepo.FindOneByEmail(ctx, email); err == nil && existing.Id != current.Id {
+			return scim.User{}, userNameTaken()
+		}
+	}
+
+	name := user.FullName()
+	if name == "" {
+		name = email
+	}
+
+	// Users of several accounts are managed by the account that created them
+	if current.HomeAccountId != current.AccountId {
+		if email != current.Email || name != current.Name ||
+			(user.Active != nil && *user.Active != (current.DeactivatedAt == 0)) {
+			return scim.User{}, managedByOtherAccount()
+		}
+		return s.GetUser(ctx, current.Id)
+	}
+
+	if err := s.userRepo.Update(ctx, current.Id, email, name, user.ExternalId, nil); err != nil {
+		return scim.User{}, err
+	}
+	if email != current.Email {
+		if err := s.sessions.RevokeAll(ctx, current.Id); err != nil {
+			return scim.User{}, err
+		}
+	}
+
+	if user.Active != nil {
+		if err := s.setActive(ctx, current, *user.Active); err != nil {
+			return scim.User{}, err
+		}
+	}
+
+	return s.GetUser(ctx, current.Id)
+}
+
+func (s *scimService) setActive(ctx context.Context, user repositories.User, active bool) error {
+
+	if active == (user.DeactivatedAt == 0) {
+		return nil
+	}
+
+	if err := s.userRepo.SetDeactivated(ctx, user.Id, !active); err != nil {
+		return err
+	}
+
+	if !active {
+		return s.sessions.RevokeAll(ctx, user.Id)
+	}
+
+	return nil
+}
+
+func (s *scimService) FindGroups(ctx context.Context, filter string, startIndex, count int, withMembers bool) (scim.ListResponse, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.FindGroups")
+	defer span.End()
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+
+	f, err := scim.ParseFilter(filter)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	if !f.Empty() && !f.Is("displayName") && !f.Is("id") {
+		return scim.ListResponse{}, scim.NewError(http.StatusBadRequest, scim.ErrorInvalidFilter,
+			"groups can be filtered by displayName and id")
+	}
+
+	roles, err := s.roleRepo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return scim.ListResponse{}, err
+	}
+	var users []repositories.User
+	if withMembers {
+		if users, err = s.userRepo.FindAll(ctx, accountId); err != nil {
+			return scim.ListResponse{}, err
+		}
+	}
+
+	matches := []scim.Group{}
+	for _, role := range roles {
+		switch {
+		case f.Is("displayName") && !strings.EqualFold(role.Name, f.Value),
+			f.Is("id") && role.Id != f.Value:
+			continue
+		}
+		matches = append(matches, toScimGroup(role, users))
+	}
+
+	from, to := scim.Page(len(matches), startIndex, count)
+	return scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(matches),
+		StartIndex:   from + 1,
+		ItemsPerPage: to - from,
+		Resources:    matches[from:to],
+	}, nil
+}
+
+func (s *scimService) GetGroup(ctx context.Context, groupId string, withMembers bool) (scim.Group, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.GetGroup")
+	defer span.End()
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return scim.Group{}, err
+	}
+
+	role, err := getAccountRole(ctx, s.roleRepo, accountId, groupId)
+	if err != nil {
+		return scim.Group{}, err
+	}
+
+	var users []repositories.User
+	if withMembers {
+		if users, err = s.userRepo.FindAll(ctx, accountId); err != nil {
+			return scim.Group{}, err
+		}
+	}
+
+	return toScimGroup(role, users), nil
+}
+
+// CreateGroup creates an account role without permissions, which are then
+// granted to the role in the app
+func (s *scimService) CreateGroup(ctx context.Context, group scim.Group) (scim.Group, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.CreateGroup")
+	defer span.End()
+
+	role, err := s.roles.Create(ctx, strings.TrimSpace(group.DisplayName), []string{})
+	if err != nil {
+		return scim.Group{}, err
+	}
+
+	current := toScimGroup(role, nil)
+	if err = s.saveGroup(ctx, current, group); err != nil {
+		return scim.Group{}, err
+	}
+
+	return s.GetGroup(ctx, role.Id, true)
+}
+
+func (s *scimService) ReplaceGroup(ctx context.Context, groupId string, group scim.Group) (scim.Group, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.ReplaceGroup")
+	defer span.End()
+
+	current, err := s.GetGroup(ctx, groupId, true)
+	if err != nil {
+		return scim.Group{}, err
+	}
+
+	if err = s.saveGroup(ctx, current, group); err != nil {
+		return scim.Group{}, err
+	}
+
+	return s.GetGroup(ctx, groupId, true)
+}
+
+func (s *scimService) PatchGroup(ctx context.Context, groupId string, patch scim.PatchOp) (scim.Group, error) {
+	ctx, span := tracing.Start(ctx, "ScimService.PatchGroup")
+	defer span.End()
+
+	current, err := s.GetGroup(ctx, groupId, true)
+	if err != nil {
+		return scim.Group{}, err
+	}
+
+	group := current
+	group.Members = append([]scim.Reference{}, current.Members...)
+	if err = scim.ApplyGroupPatch(&group, patch); err != nil {
+		return scim.Group{}, err
+	}
+
+	if err = s.saveGroup(ctx, current, group); err != nil {
+		return scim.Group{}, err
+	}
+
+	return s.GetGroup(ctx, groupId, true)
+}
+
+func (s *scimService) DeleteGroup(ctx context.Context, groupId string) error {
+	ctx, span := tracing.Start(ctx, "ScimService.DeleteGroup")
+	defer span.End()
+
+	return s.roles.Delete(ctx, groupId)
+}
+
+// saveGroup renames the role of a group and gives the role to the users that
+// were added to the group. Users that were removed get the member role.
+func (s *scimService) saveGroup(ctx context.Context, current, group scim.Group) error {
+
+	added := memberIds(group.Members)
+	removed := memberIds(current.Members)
+	for id := range removed {
+		if added[id] {
+			delete(added, id)
+			delete(removed, id)
+		}
+	}
+
+	if len(removed) > 0 && current.Id == RoleMember {
+		return scim.NewError(http.StatusBadRequest, scim.ErrorMutability,
+			"users always have a role, add them to another group instead")
+	}
+
+	displayName := strings.TrimSpace(group.DisplayName)
+	if displayName != "" && displayName != current.DisplayName {
+		err := s.roleRepo.Rename(ctx, current.Id, displayName)
+		if errors.Is(err, sql.ErrNoRows) {
+			return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, "built-in groups cannot be renamed")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for userId := range added {
+		if err := s.users.ChangeRole(ctx, userId, current.Id); err != nil {
+			return err
+		}
+	}
+	for userId := range removed {
+		if err := s.users.ChangeRole(ctx, userId, RoleMember); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// accountUser returns a user of the caller's account
+func (s *scimService) accountUser(ctx context.Context, userId string) (repositories.User, error) {
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.userRepo.GetMember(ctx, accountId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, notFound("user")
+	}
+
+	return user, err
+}
+
+func (s *scimService) roleNames(ctx context.Context, accountId string) (map[string]string, error) {
+
+	roles, err := s.roleRepo.FindByAccount(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(roles))
+	for _, role := range roles {
+		names[role.Id] = role.Name
+	}
+
+	return names, nil
+}
+
+// toScimUser maps a user onto the SCIM user resource. The role of the user is
+// its only group, and named when the role names are given.
+func toScimUser(user repositories.User, roleNames map[string]string) scim.User {
+	active := user.DeactivatedAt == 0
+	scimUser := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		Id:          user.Id,
+		ExternalId:  user.ExternalId,
+		UserName:    user.Email,
+		Name:        &scim.Name{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta:        &scim.Meta{ResourceType: "User"},
+	}
+	if user.RoleId != "" {
+		scimUser.Groups = []scim.Reference{{Value: user.RoleId, Display: roleNames[user.RoleId]}}
+	}
+	return scimUser
+}
+
+// toScimGroup maps a role onto the SCIM group resource, with the users that
+// have the role as members
+func toScimGroup(role repositories.Role, users []repositories.User) scim.Group {
+	group := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		Id:          role.Id,
+		DisplayName: role.Name,
+		Members:     []scim.Reference{},
+		Meta:        &scim.Meta{ResourceType: "Group"},
+	}
+	for _, user := range users {
+		if user.RoleId == role.Id {
+			group.Members = append(group.Members, scim.Reference{Value: user.Id, Display: user.Name})
+		}
+	}
+	return group
+}
+
+func memberIds(members []scim.Reference) map[string]bool {
+	ids := make(map[string]bool, len(members))
+	for _, member := range members {
+		ids[member.Value] = true
+	}
+	return ids
+}
+
+func userNameTaken() error {
+	return scim.NewError(http.StatusConflict, scim.ErrorUniqueness, "a user with this userName exists")
+}
+
+func managedByOtherAccount() error {
+	return scim.NewError(http.StatusBadRequest, scim.ErrorMutability, "the user is managed by another account")
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"cerberus-examples/internal/logging"
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/services/jwtutils"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"time"
+)
+
+type SessionService interface {
+	Create(ctx context.Context, user repositories.User) (repositories.User, error)
+	Refresh(ctx context.Context, refreshToken string) (repositories.User, error)
+	SwitchAccount(ctx context.Context, refreshToken, accountId string) (repositories.User, error)
+	Impersonate(ctx context.Context, actor, user repositories.User, ttl time.Duration) (repositories.User, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	RevokeAll(ctx context.Context, userId string) error
+	RevokeAccount(ctx context.Context, accountId string) error
+	IsRevoked(ctx context.Context, accessTokenId string) (bool, error)
+}
+
+type sessionService struct {
+	repo            repositories.SessionRepo
+	userRepo        repositories.UserRepo
+	accountRepo     repositories.AccountRepo
+	mfaRepo         repositories.MfaRepo
+	keys            *jwtutils.KeySet
+	accessTokenTtl  time.Duration
+	refreshTokenTtl time.Duration
+}
+
+func NewSessionService(
+	repo repositories.SessionRepo,
+	userRepo repositories.UserRepo,
+	accountRepo repositories.AccountRepo,
+	mfaRepo repositories.MfaRepo,
+	keys *jwtutils.KeySet,
+	accessTokenTtl time.Duration,
+	refreshTokenTtl time.Duration) SessionService {
+	return &sessionService{
+		repo:            repo,
+		userRepo:        userRepo,
+		accountRepo:     accountRepo,
+		mfaRepo:         mfaRepo,
+		keys:            keys,
+		accessTokenTtl:  accessTokenTtl,
+		refreshTokenTtl: refreshTokenTtl,
+	}
+}
+
+// Create starts a new session for the user and returns the user with a
+// short-lived access token and a refresh token
+func (s *sessionService) Create(ctx context.Context, user repositories.User) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.Create")
+	defer span.End()
+
+	refreshToken, record, err := s.issue(user, uuid.New().String())
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	if err = s.repo.CreateRefreshToken(ctx, record, nil); err != nil {
+		return repositories.User{}, err
+	}
+
+	return refreshToken, nil
+}
+
+// Refresh exchanges a refresh token for new tokens. The presented refresh
+// token is rotated; presenting it again revokes the whole session.
+func (s *sessionService) Refresh(ctx context.Context, refreshToken string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.Refresh")
+	defer span.End()
+
+	return s.rotate(ctx, refreshToken, "")
+}
+
+// SwitchAccount exchanges a refresh token for new tokens scoped to another
+// account of the user, like Refresh does for the current one. Accounts that
+// require two-factor authentication can only be switched to by users who
+// have set it up.
+func (s *sessionService) SwitchAccount(ctx context.Context, refreshToken, accountId string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.SwitchAccount")
+	defer span.End()
+
+	return s.rotate(ctx, refreshToken, accountId)
+}
+
+// rotate replaces the refresh token with one for the account, or for the
+// current account of the session when accountId is empty
+func (s *sessionService) rotate(ctx context.Context, refreshToken, accountId string) (repositories.User, error) {
+
+	current, err := s.repo.FindRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return repositories.User{}, unauthorized()
+		}
+		return repositories.User{}, err
+	}
+
+	if current.RevokedAt != 0 {
+		if current.ReplacedBy != "" {
+			return repositories.User{}, s.reuseDetected(ctx, current)
+		}
+		return repositories.User{}, unauthorized()
+	}
+
+	if time.Now().Unix() >= current.ExpiresAt {
+		return repositories.User{}, unauthorized()
+	}
+
+	switching := accountId != "" && accountId != current.AccountId
+	if !switching {
+		accountId = current.AccountId
+	}
+
+	// The user can have left the account since the session started
+	user, err := s.userRepo.GetMember(ctx, accountId, current.UserId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			if switching {
+				return repositories.User{}, notFound("account")
+			}
+			return repositories.User{}, unauthorized()
+		}
+		return repositories.User{}, err
+	}
+	if user.DeactivatedAt != 0 {
+		return repositories.User{}, unauthorized()
+	}
+
+	if switching {
+		if err = s.checkMfa(ctx, user); err != nil {
+			return repositories.User{}, err
+		}
+	}
+
+	refreshed, next, err := s.issue(user, current.FamilyId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	err = s.repo.RotateRefreshToken(ctx, current.Id, next)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// rotated concurrently by another request presenting the same token
+			return repositories.User{}, s.reuseDetected(ctx, current)
+		}
+		return repositories.User{}, err
+	}
+
+	return refreshed, nil
+}
+
+// checkMfa refuses accounts that require two-factor authentication to users
+// who have not set it up, as they would have to at a login
+func (s *sessionService) checkMfa(ctx context.Context, user repositories.User) error {
+
+	account, err := s.accountRepo.Get(ctx, user.AccountId)
+	if err != nil {
+		return err
+	}
+	if !account.RequireMfa {
+		return nil
+	}
+
+	enrollment, err := s.mfaRepo.GetTotp(ctx, user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err != nil || enrollment.ConfirmedAt == 0 {
+		return utils.NewForbiddenError("the account requires two-factor authentication, set it up before switching")
+	}
+
+	return nil
+}
+
+// Impersonate returns the user with an access token that lets the actor act
+// as the user until it expires. The token names the actor in its act claim
+// (RFC 8693) and comes without a refresh token, so the session can't be
+// prolonged. The session is recorded with a refresh token that is revoked
+// from the start, so that revoking the sessions of the user or the account
+// revokes the access token too.
+func (s *sessionService) Impersonate(ctx context.Context, actor, user repositories.User, ttl time.Duration) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.Impersonate")
+	defer span.End()
+
+	claims := toClaims(user)
+	claims["act"] = map[string]interface{}{
+		"sub":   actor.Id,
+		"email": actor.Email,
+	}
+
+	accessToken, accessTokenId, err := jwtutils.Sign(user.Id, claims, s.keys, ttl)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	// The refresh token is never handed out
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	now := time.Now()
+	err = s.repo.CreateRefreshToken(ctx, repositories.RefreshToken{
+		Id:              uuid.New().String(),
+		FamilyId:        uuid.New().String(),
+		UserId:          user.Id,
+		AccountId:       user.AccountId,
+		TokenHash:       hashToken(refreshToken),
+		AccessTokenId:   accessTokenId,
+		AccessExpiresAt: now.Add(ttl).Unix(),
+		CreatedAt:       now.Unix(),
+		ExpiresAt:       now.Add(ttl).Unix(),
+		RevokedAt:       now.Unix(),
+	}, nil)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	return userWithTokens(user, accessToken, ""), nil
+}
+
+// Logout revokes the session of the refresh token and the presented access token
+func (s *sessionService) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	ctx, span := tracing.Start(ctx, "SessionService.Logout")
+	defer span.End()
+
+	if refreshToken != "" {
+		current, err := s.repo.FindRefreshToken(ctx, hashToken(refreshToken))
+		if err == nil {
+			err = s.repo.RevokeFamily(ctx, current.FamilyId)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	if accessToken != "" {
+		claims, err := jwtutils.ExtractToken(accessToken, s.keys, func(token *jwt.Token) interface{} {
+			return token.Claims
+		})
+		if err != nil {
+			// expired or invalid tokens cannot be used anyway
+			return nil
+		}
+		mapClaims := claims.(jwt.MapClaims)
+		tokenId, _ := mapClaims["jti"].(string)
+		expiresAt, _ := mapClaims["exp"].(float64)
+		if tokenId != "" {
+			return s.repo.RevokeAccessToken(ctx, tokenId, int64(expiresAt))
+		}
+	}
+
+	return nil
+}
+
+func (s *sessionService) RevokeAll(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "SessionService.RevokeAll")
+	defer span.End()
+
+	return s.repo.RevokeUser(ctx, userId)
+}
+
+// RevokeAccount logs everyone out of the account
+func (s *sessionService) RevokeAccount(ctx context.Context, accountId string) error {
+	ctx, span := tracing.Start(ctx, "SessionService.RevokeAccount")
+	defer span.End()
+
+	return s.repo.RevokeAccount(ctx, accountId)
+}
+
+func (s *sessionService) IsRevoked(ctx context.Context, accessTokenId string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.IsRevoked")
+	defer span.End()
+
+	return s.repo.IsAccessTokenRevoked(ctx, accessTokenId)
+}
+
+func (s *sessionService) issue(user repositories.User, familyId string) (repositories.User, repositories.RefreshToken, error) {
+
+	accessToken, accessTokenId, err := jwtutils.Sign(user.Id, toClaims(user), s.keys, s.accessTokenTtl)
+	if err != nil {
+		return repositories.User{}, repositories.RefreshToken{}, err
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return repositories.User{}, repositories.RefreshToken{}, err
+	}
+
+	now := time.Now()
+	record := repositories.RefreshToken{
+		Id:              uuid.New().String(),
+		FamilyId:        familyId,
+		UserId:          user.Id,
+		AccountId:       user.AccountId,
+		TokenHash:       hashToken(refreshToken),
+		AccessTokenId:   accessTokenId,
+		AccessExpiresAt: now.Add(s.accessTokenTtl).Unix(),
+		CreatedAt:       now.Unix(),
+		ExpiresAt:       now.Add(s.refreshTokenTtl).Unix(),
+	}
+
+	return userWithTokens(user, accessToken, refreshToken), record, nil
+}
+
+func (s *sessionService) reuseDetected(ctx context.Context, token repositories.RefreshToken) error {
+	logging.FromContext(ctx).Warn("refresh token reuse detected, revoking session", "familyId", token.FamilyId, "tokenUserId", token.UserId)
+	if err := s.repo.RevokeFamily(ctx, token.FamilyId); err != nil {
+		return err
+	}
+	return unauthorized()
+}
+
+func toClaims(user repositories.User) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":       user.Id,
+		"email":     user.Email,
+		"name":      user.Name,
+		"accountId": user.AccountId,
+	}
+}
+
+func userWithTokens(user repositories.User, token, refreshToken string) repositories.User {
+	user.Token = token
+	user.RefreshToken = refreshToken
+	return user
+}
+
+func unauthorized() error {
+	return utils.NewUnauthorizedError("unauthorized")
+}
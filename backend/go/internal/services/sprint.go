@@ -1,8 +1,11 @@
 package services
 
 import (
+	"cerberus-examples/internal/authz"
 	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
 	"context"
 	"fmt"
 )
@@ -18,30 +21,48 @@ type SprintService interface {
 type sprintService struct {
 	txProvider database.TxProvider
 	repo       repositories.SprintRepo
+	ownership  ownership
+	authz      authz.Client
+	authorizer authorizer
 }
 
 func NewSprintService(
 	txProvider database.TxProvider,
-	repo repositories.SprintRepo) SprintService {
+	repo repositories.SprintRepo,
+	ownershipRepo repositories.OwnershipRepo,
+	authzClient authz.Client) SprintService {
 	return &sprintService{
 		txProvider: txProvider,
 		repo:       repo,
+		ownership:  ownership{repo: ownershipRepo},
+		authz:      authzClient,
+		authorizer: authorizer{client: authzClient},
 	}
 }
 
 func (s *sprintService) Create(ctx context.Context, projectId, goal string) (repositories.Sprint, error) {
+	ctx, span := tracing.Start(ctx, "SprintService.Create")
+	defer span.End()
 
 	userId := ctx.Value("userId")
 	if userId == nil {
 		return repositories.Sprint{}, fmt.Errorf("no userId")
 	}
 
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return repositories.Sprint{}, err
+	}
+
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionSprintWrite); err != nil {
+		return repositories.Sprint{}, err
+	}
+
 	tx, err := s.txProvider.GetTransaction()
 	if err != nil {
 		return repositories.Sprint{}, err
 	}
 
-	sprint, err := s.repo.Create(projectId, goal, tx)
+	sprint, err := s.repo.Create(ctx, projectId, goal, tx)
 	if err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
 			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
@@ -49,29 +70,77 @@ func (s *sprintService) Create(ctx context.Context, projectId, goal string) (rep
 		return repositories.Sprint{}, err
 	}
 
-	return sprint, tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return repositories.Sprint{}, err
+	}
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.CreateResource(ctx, sprint.Id, projectId, authz.ResourceSprint); err != nil {
+		return repositories.Sprint{}, err
+	}
+
+	return sprint, nil
 }
 
 func (s *sprintService) FindByProject(ctx context.Context, projectId string) ([]repositories.Sprint, error) {
-	return s.repo.FindByProject(projectId)
+	ctx, span := tracing.Start(ctx, "SprintService.FindByProject")
+	defer span.End()
+
+	if err := s.ownership.project(ctx, projectId); err != nil {
+		return []repositories.Sprint{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "project", projectId, PermissionSprintRead); err != nil {
+		return []repositories.Sprint{}, err
+	}
+	return s.repo.FindByProject(ctx, projectId)
 }
 
 func (s *sprintService) Get(ctx context.Context, sprintId string) (repositories.Sprint, error) {
-	return s.repo.Get(sprintId, nil)
+	ctx, span := tracing.Start(ctx, "SprintService.Get")
+	defer span.End()
+
+	if err := s.ownership.sprint(ctx, sprintId); err != nil {
+		return repositories.Sprint{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "sprint", sprintId, PermissionSprintRead); err != nil {
+		return repositories.Sprint{}, err
+	}
+	return s.repo.Get(ctx, sprintId, nil)
 }
 
 func (s *sprintService) Start(ctx context.Context, sprintId string) (repositories.Sprint, error) {
-	_, err := s.repo.Start(sprintId)
+	ctx, span := tracing.Start(ctx, "SprintService.Start")
+	defer span.End()
+
+	if err := s.ownership.sprint(ctx, sprintId); err != nil {
+		return repositories.Sprint{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "sprint", sprintId, PermissionSprintWrite); err != nil {
+		return repositories.Sprint{}, err
+	}
+	_, err := s.repo.Start(ctx, sprintId)
 	if err != nil {
 		return repositories.Sprint{}, err
 	}
-	return s.repo.Get(sprintId, nil)
+	metrics.SprintsStarted.Inc()
+	return s.repo.Get(ctx, sprintId, nil)
 }
 
 func (s *sprintService) End(ctx context.Context, sprintId string) (repositories.Sprint, error) {
-	_, err := s.repo.End(sprintId)
+	ctx, span := tracing.Start(ctx, "SprintService.End")
+	defer span.End()
+
+	if err := s.ownership.sprint(ctx, sprintId); err != nil {
+		return repositories.Sprint{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "sprint", sprintId, PermissionSprintWrite); err != nil {
+		return repositories.Sprint{}, err
+	}
+	_, err := s.repo.End(ctx, sprintId)
 	if err != nil {
 		return repositories.Sprint{}, err
 	}
-	return s.repo.Get(sprintId, nil)
+	metrics.SprintsEnded.Inc()
+	return s.repo.Get(ctx, sprintId, nil)
 }
@@ -1,12 +1,31 @@
 package services
 
 import (
+	"cerberus-examples/internal/authz"
 	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 )
 
+// EstimationScale are the story points that a story can be estimated at
+var EstimationScale = []int{0, 1, 2, 3, 5, 8, 13, 20, 40, 100}
+
+// ValidEstimation tells whether the estimation is on the scale
+func ValidEstimation(estimation int) bool {
+	for _, points := range EstimationScale {
+		if points == estimation {
+			return true
+		}
+	}
+	return false
+}
+
 type StoryService interface {
 	Create(ctx context.Context, sprintId, description string) (repositories.Story, error)
 	FindBySprint(ctx context.Context, sprintId string) ([]repositories.Story, error)
@@ -19,30 +38,60 @@ type StoryService interface {
 type storyService struct {
 	txProvider database.TxProvider
 	repo       repositories.StoryRepo
+	workflows  repositories.WorkflowRepo
+	ownership  ownership
+	authz      authz.Client
+	authorizer authorizer
 }
 
 func NewStoryService(
 	txProvider database.TxProvider,
-	repo repositories.StoryRepo) StoryService {
+	repo repositories.StoryRepo,
+	workflowRepo repositories.WorkflowRepo,
+	ownershipRepo repositories.OwnershipRepo,
+	authzClient authz.Client) StoryService {
 	return &storyService{
 		txProvider: txProvider,
 		repo:       repo,
+		workflows:  workflowRepo,
+		ownership:  ownership{repo: ownershipRepo},
+		authz:      authzClient,
+		authorizer: authorizer{client: authzClient},
 	}
 }
 
 func (s *storyService) Create(ctx context.Context, sprintId, description string) (repositories.Story, error) {
+	ctx, span := tracing.Start(ctx, "StoryService.Create")
+	defer span.End()
 
 	userId := ctx.Value("userId")
 	if userId == nil {
 		return repositories.Story{}, fmt.Errorf("no userId")
 	}
 
+	if err := s.ownership.sprint(ctx, sprintId); err != nil {
+		return repositories.Story{}, err
+	}
+
+	if err := s.authorizer.requireVisible(ctx, "sprint", sprintId, PermissionStoryWrite); err != nil {
+		return repositories.Story{}, err
+	}
+
+	// New stories get the first state of the workflow of the project
+	workflow, err := s.workflows.GetBySprint(ctx, sprintId)
+	if err != nil {
+		return repositories.Story{}, err
+	}
+	if len(workflow.States) == 0 {
+		return repositories.Story{}, fmt.Errorf("project %s has no workflow", workflow.ProjectId)
+	}
+
 	tx, err := s.txProvider.GetTransaction()
 	if err != nil {
 		return repositories.Story{}, err
 	}
 
-	story, err := s.repo.Create(sprintId, description, tx)
+	story, err := s.repo.Create(ctx, sprintId, description, workflow.States[0].Name, tx)
 	if err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
 			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
@@ -50,37 +99,138 @@ func (s *storyService) Create(ctx context.Context, sprintId, description string)
 		return repositories.Story{}, err
 	}
 
-	return story, tx.Commit()
+	if err = tx.Commit(); err != nil {
+		return repositories.Story{}, err
+	}
+	metrics.StoriesCreated.Inc()
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	if err = s.authz.CreateResource(ctx, story.Id, sprintId, authz.ResourceStory); err != nil {
+		return repositories.Story{}, err
+	}
+
+	return story, nil
 }
 
 func (s *storyService) FindBySprint(ctx context.Context, sprintId string) ([]repositories.Story, error) {
-	return s.repo.FindBySprint(sprintId)
+	ctx, span := tracing.Start(ctx, "StoryService.FindBySprint")
+	defer span.End()
+
+	if err := s.ownership.sprint(ctx, sprintId); err != nil {
+		return []repositories.Story{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "sprint", sprintId, PermissionStoryRead); err != nil {
+		return []repositories.Story{}, err
+	}
+	return s.repo.FindBySprint(ctx, sprintId)
 }
 
 func (s *storyService) Get(ctx context.Context, storyId string) (repositories.Story, error) {
-	return s.repo.Get(storyId, nil)
+	ctx, span := tracing.Start(ctx, "StoryService.Get")
+	defer span.End()
+
+	if err := s.ownership.story(ctx, storyId); err != nil {
+		return repositories.Story{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "story", storyId, PermissionStoryRead); err != nil {
+		return repositories.Story{}, err
+	}
+	return s.repo.Get(ctx, storyId, nil)
 }
 
 func (s *storyService) Assign(ctx context.Context, storyId, userId string) (repositories.Story, error) {
-	_, err := s.repo.Assign(storyId, userId)
+	ctx, span := tracing.Start(ctx, "StoryService.Assign")
+	defer span.End()
+
+	if err := s.ownership.story(ctx, storyId); err != nil {
+		return repositories.Story{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "story", storyId, PermissionStoryWrite); err != nil {
+		return repositories.Story{}, err
+	}
+	// Without a user, the story is unassigned
+	if userId != "" {
+		if err := s.ownership.user(ctx, userId); err != nil {
+			return repositories.Story{}, err
+		}
+		assigneeIsMember, err := s.authz.HasPermission(ctx, userId, storyId, PermissionProjectRead)
+		if err != nil {
+			return repositories.Story{}, err
+		}
+		if !assigneeIsMember {
+			return repositories.Story{}, utils.NewValidationError("assignee is not a member of the project", nil)
+		}
+	}
+	_, err := s.repo.Assign(ctx, storyId, userId)
 	if err != nil {
 		return repositories.Story{}, err
 	}
-	return s.repo.Get(storyId, nil)
+	return s.repo.Get(ctx, storyId, nil)
 }
 
 func (s *storyService) Estimate(ctx context.Context, storyId string, estimation int) (repositories.Story, error) {
-	_, err := s.repo.Estimate(storyId, estimation)
+	ctx, span := tracing.Start(ctx, "StoryService.Estimate")
+	defer span.End()
+
+	if err := s.ownership.story(ctx, storyId); err != nil {
+		return repositories.Story{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "story", storyId, PermissionStoryWrite); err != nil {
+		return repositories.Story{}, err
+	}
+	if !ValidEstimation(estimation) {
+		return repositories.Story{}, utils.NewViolationsError([]utils.Violation{{
+			Field:   "estimation",
+			Rule:    "estimation",
+			Message: fmt.Sprintf("must be one of %v", EstimationScale),
+		}})
+	}
+	_, err := s.repo.Estimate(ctx, storyId, estimation)
 	if err != nil {
 		return repositories.Story{}, err
 	}
-	return s.repo.Get(storyId, nil)
+	return s.repo.Get(ctx, storyId, nil)
 }
 
 func (s *storyService) ChangeStatus(ctx context.Context, storyId, status string) (repositories.Story, error) {
-	_, err := s.repo.ChangeStatus(storyId, status)
+	ctx, span := tracing.Start(ctx, "StoryService.ChangeStatus")
+	defer span.End()
+
+	if err := s.ownership.story(ctx, storyId); err != nil {
+		return repositories.Story{}, err
+	}
+	if err := s.authorizer.requireVisible(ctx, "story", storyId, PermissionStoryWrite); err != nil {
+		return repositories.Story{}, err
+	}
+
+	// Stories move along the transitions of the workflow of their project
+	story, err := s.repo.Get(ctx, storyId, nil)
+	if err != nil {
+		return repositories.Story{}, err
+	}
+	workflow, err := s.workflows.GetBySprint(ctx, story.SprintId)
+	if err != nil {
+		return repositories.Story{}, err
+	}
+	if err = checkTransition(workflow, story.Status, status); err != nil {
+		return repositories.Story{}, err
+	}
+	if story.Status == status {
+		return story, nil
+	}
+
+	// The story only moves when it is still in the status that the transition
+	// was checked from
+	_, err = s.repo.ChangeStatus(ctx, storyId, story.Status, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.Story{}, utils.NewConflictError("the story changed status in the meantime", map[string]interface{}{
+			"from": story.Status,
+			"to":   status,
+		})
+	}
 	if err != nil {
 		return repositories.Story{}, err
 	}
-	return s.repo.Get(storyId, nil)
+	return s.repo.Get(ctx, storyId, nil)
 }
@@ -0,0 +1,147 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the test vectors of RFC 6238
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// TestCode checks the codes against the SHA1 test vectors of RFC 6238, which
+// have 8 digits, of which the codes are the last 6
+func TestCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(test.time, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("expected code %s at %d, got %s", test.code, test.time, code)
+		}
+	}
+}
+
+func TestCodeOfLowercaseSecret(t *testing.T) {
+	code, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "287082" {
+		t.Errorf("expected code 287082, got %s", code)
+	}
+}
+
+func TestCodeOfInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected an error for a secret that isn't base32")
+	}
+}
+
+// TestValidateSkew checks that codes are accepted from one period before to
+// one period after the current one, and not beyond
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name  string
+		step  int64
+		valid bool
+	}{
+		{"two periods early", current - 2, false},
+		{"one period early", current - 1, true},
+		{"current period", current, true},
+		{"one period late", current + 1, true},
+		{"two periods late", current + 2, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, test.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok, err := Validate(rfcSecret, code, now, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.valid {
+				t.Fatalf("expected valid %v, got %v", test.valid, ok)
+			}
+			if ok && step != test.step {
+				t.Errorf("expected step %d, got %d", test.step, step)
+			}
+		})
+	}
+}
+
+// TestValidateReplay checks that the codes of steps up to the last used one
+// are rejected
+func TestValidateReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	code, err := Code(rfcSecret, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := Validate(rfcSecret, code, now, current-1); !ok {
+		t.Error("expected the code of a later step than the last used one to be accepted")
+	}
+	if _, ok, _ := Validate(rfcSecret, code, now, current); ok {
+		t.Error("expected the code of the last used step to be rejected")
+	}
+
+	earlier, err := Code(rfcSecret, current-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := Validate(rfcSecret, earlier, now, current); ok {
+		t.Error("expected the code of a step before the last used one to be rejected")
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := Validate(rfcSecret, code[:3]+" "+code[3:], now, 0); !ok {
+		t.Error("expected a code with a space to be accepted")
+	}
+	for _, invalid := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok, _ := Validate(rfcSecret, invalid, now, 0); ok {
+			t.Errorf("expected code %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("expected a 160 bit secret, got %d bits", len(key)*8)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Error("expected secrets to differ")
+	}
+}
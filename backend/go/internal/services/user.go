@@ -1,58 +1,95 @@
 package services
 
 import (
+	"cerberus-examples/internal/authz"
 	"cerberus-examples/internal/database"
+	"cerberus-examples/internal/metrics"
 	"cerberus-examples/internal/repositories"
-	"cerberus-examples/internal/services/jwtutils"
+	"cerberus-examples/internal/tracing"
+	"cerberus-examples/internal/utils"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
+	"time"
 )
 
 type UserService interface {
 	Register(ctx context.Context, email, plainPassword, name string) (repositories.User, error)
 	Login(ctx context.Context, email string, password string) (repositories.User, error)
-	Add(ctx context.Context, email, plainPassword, name, roleId string) (repositories.User, error)
 	GetAll(ctx context.Context) ([]repositories.User, error)
+	ChangeRole(ctx context.Context, userId, roleId string) error
+	GetMe(ctx context.Context) (repositories.User, error)
+	UpdateMe(ctx context.Context, name, email string) (repositories.User, error)
+	ChangePassword(ctx context.Context, currentPassword, newPassword string) (repositories.User, error)
+	Update(ctx context.Context, userId, name, email string, active *bool) (repositories.User, error)
+	Deactivate(ctx context.Context, userId string) error
 }
 
 type userService struct {
-	txProvider  database.TxProvider
-	userRepo    repositories.UserRepo
-	accountRepo repositories.AccountRepo
-	jwtSecret   string
-	saltRounds  int
+	txProvider    database.TxProvider
+	userRepo      repositories.UserRepo
+	accountRepo   repositories.AccountRepo
+	roleRepo      repositories.RoleRepo
+	authz         authz.Client
+	mfa           MfaService
+	verifications EmailVerificationService
+	loginGuard    LoginGuardService
+	sessions      SessionService
+	saltRounds    int
 }
 
 func NewUserService(
 	txProvider database.TxProvider,
 	userRepo repositories.UserRepo,
 	accountRepo repositories.AccountRepo,
-	jwtSecret string,
+	roleRepo repositories.RoleRepo,
+	authzClient authz.Client,
+	mfa MfaService,
+	verifications EmailVerificationService,
+	loginGuard LoginGuardService,
+	sessions SessionService,
 	saltRounds int) UserService {
 	return &userService{
-		txProvider:  txProvider,
-		userRepo:    userRepo,
-		accountRepo: accountRepo,
-		jwtSecret:   jwtSecret,
-		saltRounds:  saltRounds,
+		txProvider:    txProvider,
+		userRepo:      userRepo,
+		accountRepo:   accountRepo,
+		roleRepo:      roleRepo,
+		authz:         authzClient,
+		mfa:           mfa,
+		verifications: verifications,
+		loginGuard:    loginGuard,
+		sessions:      sessions,
+		saltRounds:    saltRounds,
 	}
 }
 
 // Register should register a new user
 //
-// The properties also be used to generate a JWT `token` which should be included
-// with the returned user.
+// The user can log in once the email address is verified with the link that
+// is mailed to it, and owns the new account. Users of other accounts are
+// invited rather than registered again, as emails are unique across accounts.
 func (s *userService) Register(ctx context.Context, email, plainPassword, name string) (_ repositories.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Register")
+	defer span.End()
 
-	log.Println("Register", email, plainPassword, name)
+	if _, err = s.userRepo.FindOneByEmail(ctx, email); err == nil {
+		return repositories.User{}, utils.NewConflictError("a user with this email already exists", nil)
+	}
+
+	// The account is named after the user until it is renamed
+	slug, err := uniqueSlug(ctx, s.accountRepo, name)
+	if err != nil {
+		return repositories.User{}, err
+	}
 
 	tx, err := s.txProvider.GetTransaction()
 	if err != nil {
 		return repositories.User{}, err
 	}
 
-	account, err := s.accountRepo.Create(tx)
+	account, err := s.accountRepo.Create(ctx, name, slug, tx)
 	if err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
 			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
@@ -60,7 +97,10 @@ func (s *userService) Register(ctx context.Context, email, plainPassword, name s
 		return repositories.User{}, err
 	}
 
-	user, err := s.userRepo.Save(account.Id, email, plainPassword, name, tx)
+	user, err := s.userRepo.Save(ctx, account.Id, email, plainPassword, name, RoleAdmin, tx)
+	if err == nil {
+		err = s.accountRepo.SetOwner(ctx, account.Id, user.Id, tx)
+	}
 	if err != nil {
 		if rbe := tx.Rollback(); rbe != nil {
 			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
@@ -68,83 +108,324 @@ func (s *userService) Register(ctx context.Context, email, plainPassword, name s
 		return repositories.User{}, err
 	}
 
-	subject := user.Id
-	token, err := jwtutils.Sign(subject, toClaims(user), s.jwtSecret)
+	if err = tx.Commit(); err != nil {
+		return repositories.User{}, err
+	}
+
+	// The authorization client is called after committing, as it may use
+	// the same database or a remote service
+	err = s.authz.CreateResource(ctx, account.Id, "", authz.ResourceAccount)
 	if err != nil {
-		if rbe := tx.Rollback(); rbe != nil {
-			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
-		}
+		return repositories.User{}, err
+	}
+	if err = s.authz.AssignRole(ctx, RoleAdmin, user.Id, account.Id); err != nil {
 		return repositories.User{}, err
 	}
 
-	return userWithTokens(user, token), tx.Commit()
+	if err = s.verifications.Send(ctx, user); err != nil {
+		return repositories.User{}, err
+	}
+
+	metrics.UsersRegistered.Inc()
+	return user, nil
 }
 
-// Login finds a user and returns that user with a jwt token
+// Login finds a user and returns that user with a jwt token and a refresh token,
+// or with an mfa token when a second factor is needed
 func (s *userService) Login(ctx context.Context, email string, password string) (_ repositories.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Login")
+	defer span.End()
 
-	user, err := s.userRepo.FindOneByEmailAndPassword(email, password)
-	if err != nil {
+	start := time.Now()
+
+	if err = s.loginGuard.Check(ctx, email); err != nil {
+		metrics.ObserveLogin(metrics.LoginThrottled, start)
 		return repositories.User{}, err
 	}
 
-	subject := user.Id
-	token, err := jwtutils.Sign(subject, toClaims(user), s.jwtSecret)
+	user, err := s.userRepo.FindOneByEmailAndPassword(ctx, email, password)
+	if err != nil {
+		metrics.ObserveLogin(metrics.LoginFailed, start)
+		if ge := s.loginGuard.Failed(ctx, email); ge != nil {
+			return repositories.User{}, ge
+		}
+		return repositories.User{}, utils.NewUnauthorizedError(err.Error())
+	}
+	metrics.ObserveLogin(metrics.LoginSucceeded, start)
+
+	if !user.EmailVerified {
+		return repositories.User{}, utils.NewForbiddenError("email not verified")
+	}
+
+	user, err = s.mfa.Login(ctx, user)
 	if err != nil {
 		return repositories.User{}, err
 	}
 
-	return userWithTokens(user, token), nil
+	// The failures of a login with a second factor are cleared once its code
+	// is verified
+	if !user.MfaRequired {
+		if err = s.loginGuard.Succeeded(ctx, email); err != nil {
+			return repositories.User{}, err
+		}
+	}
+
+	return user, nil
 }
 
-func (s *userService) Add(ctx context.Context, email, plainPassword, name, roleId string) (_ repositories.User, err error) {
+func (s *userService) GetAll(ctx context.Context) (_ []repositories.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetAll")
+	defer span.End()
 
 	accountId := ctx.Value("accountId")
 	if accountId == nil {
-		return repositories.User{}, fmt.Errorf("no accountId")
+		return []repositories.User{}, fmt.Errorf("no accountId")
 	}
 
-	tx, err := s.txProvider.GetTransaction()
+	return s.userRepo.FindAll(ctx, accountId.(string))
+}
+
+// ChangeRole assigns another built-in or account role to a user of the caller's account
+func (s *userService) ChangeRole(ctx context.Context, userId, roleId string) error {
+	ctx, span := tracing.Start(ctx, "UserService.ChangeRole")
+	defer span.End()
+
+	accountId := ctx.Value("accountId")
+	if accountId == nil {
+		return fmt.Errorf("no accountId")
+	}
+
+	if _, err := getAccountRole(ctx, s.roleRepo, accountId.(string), roleId); err != nil {
+		return err
+	}
+
+	user, err := s.userRepo.GetMember(ctx, accountId.(string), userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return utils.NewNotFoundError("user")
+	}
 	if err != nil {
+		return err
+	}
+
+	if err = s.userRepo.SetRole(ctx, accountId.(string), userId, roleId); err != nil {
+		return err
+	}
+
+	if user.RoleId != "" {
+		if err = s.authz.UnassignRole(ctx, user.RoleId, userId, accountId.(string)); err != nil {
+			return err
+		}
+	}
+
+	return s.authz.AssignRole(ctx, roleId, userId, accountId.(string))
+}
+
+// GetMe returns the caller, with its role in the current account
+func (s *userService) GetMe(ctx context.Context) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetMe")
+	defer span.End()
+
+	userId := ctx.Value("userId")
+	if userId == nil {
+		return repositories.User{}, fmt.Errorf("no userId")
+	}
+
+	return s.accountUser(ctx, userId.(string))
+}
+
+// UpdateMe changes the caller's name and email address. Empty values are left
+// unchanged. A changed email address logs the caller out everywhere, and has
+// to be verified again before the next login.
+func (s *userService) UpdateMe(ctx context.Context, name, email string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateMe")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
 		return repositories.User{}, err
 	}
 
-	user, err := s.userRepo.Save(accountId.(string), email, plainPassword, name, tx)
+	user, err := s.GetMe(ctx)
 	if err != nil {
-		if rbe := tx.Rollback(); rbe != nil {
-			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		return repositories.User{}, err
+	}
+
+	if err = s.updateProfile(ctx, user, name, email); err != nil {
+		return repositories.User{}, err
+	}
+
+	return s.GetMe(ctx)
+}
+
+// ChangePassword sets a new password for the caller after checking the
+// current one. All sessions of the caller are revoked, and the caller gets a
+// new one.
+func (s *userService) ChangePassword(ctx context.Context, currentPassword, newPassword string) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.ChangePassword")
+	defer span.End()
+
+	if err := requireSession(ctx); err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.GetMe(ctx)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	if _, err = s.userRepo.FindOneByEmailAndPassword(ctx, user.Email, currentPassword); err != nil {
+		return repositories.User{}, utils.NewValidationError("incorrect current password", nil)
+	}
+
+	if err = s.userRepo.SetPassword(ctx, user.Id, newPassword, nil); err != nil {
+		return repositories.User{}, err
+	}
+
+	if err = s.sessions.RevokeAll(ctx, user.Id); err != nil {
+		return repositories.User{}, err
+	}
+
+	return s.sessions.Create(ctx, user)
+}
+
+// Update changes the name, email address and activation of a user of the
+// caller's account. Empty values and a nil active are left unchanged. Users
+// that were created by another account are managed by that account.
+func (s *userService) Update(ctx context.Context, userId, name, email string, active *bool) (repositories.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.Update")
+	defer span.End()
+
+	user, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return repositories.User{}, err
+	}
+	if user.HomeAccountId != user.AccountId {
+		return repositories.User{}, managedElsewhere()
+	}
+	// The email is what the user logs in and resets the password with
+	if email != "" && repositories.NormalizeEmail(email) != repositories.NormalizeEmail(user.Email) {
+		if err = requireSession(ctx); err != nil {
+			return repositories.User{}, err
 		}
+	}
+
+	if err = s.updateProfile(ctx, user, name, email); err != nil {
 		return repositories.User{}, err
 	}
 
-	return user, tx.Commit()
+	if active != nil {
+		if err = s.setActive(ctx, user, *active); err != nil {
+			return repositories.User{}, err
+		}
+	}
+
+	return s.userRepo.GetMember(ctx, user.AccountId, userId)
 }
 
-func (s *userService) GetAll(ctx context.Context) (_ []repositories.User, err error) {
+// Deactivate deactivates rather than deletes a user of the caller's account,
+// so that the stories assigned to the user keep their assignee. A deactivated
+// user can't log in and is logged out everywhere, which is why only the
+// account that created the user can deactivate it.
+func (s *userService) Deactivate(ctx context.Context, userId string) error {
+	ctx, span := tracing.Start(ctx, "UserService.Deactivate")
+	defer span.End()
 
-	accountId := ctx.Value("accountId")
-	if accountId == nil {
-		return []repositories.User{}, fmt.Errorf("no accountId")
+	user, err := s.accountUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if user.HomeAccountId != user.AccountId {
+		return managedElsewhere()
 	}
 
-	return s.userRepo.FindAll(accountId.(string))
+	return s.setActive(ctx, user, false)
 }
 
-func toClaims(user repositories.User) map[string]interface{} {
-	return map[string]interface{}{
-		"sub":       user.Id,
-		"email":     user.Email,
-		"name":      user.Name,
-		"accountId": user.AccountId,
+// accountUser returns a user of the caller's account
+func (s *userService) accountUser(ctx context.Context, userId string) (repositories.User, error) {
+
+	accountId, err := callerAccount(ctx)
+	if err != nil {
+		return repositories.User{}, err
+	}
+
+	user, err := s.userRepo.GetMember(ctx, accountId, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return repositories.User{}, notFound("user")
 	}
+	return user, err
 }
 
-func userWithTokens(user repositories.User, token string) repositories.User {
-	return repositories.User{
-		Token:     token,
-		Id:        user.Id,
-		AccountId: user.AccountId,
-		Email:     user.Email,
-		Name:      user.Name,
+func (s *userService) updateProfile(ctx context.Context, user repositories.User, name, email string) (err error) {
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = user.Name
+	}
+	email = repositories.NormalizeEmail(email)
+	if email == "" {
+		email = user.Email
+	}
+	emailChanged := email != user.Email
+
+	if emailChanged {
+		// FindOneByEmail fails for any error, which is taken as a free address
+		if existing, err := s.userRepo.FindOneByEmail(ctx, email); err == nil && existing.Id != user.Id {
+			return utils.NewConflictError("email address already in use", nil)
+		}
+	}
+
+	tx, err := s.txProvider.GetTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = s.userRepo.Update(ctx, user.Id, email, name, user.ExternalId, tx)
+	if err == nil && emailChanged {
+		err = s.userRepo.MarkEmailUnverified(ctx, user.Id, tx)
+	}
+	if err != nil {
+		if rbe := tx.Rollback(); rbe != nil {
+			err = fmt.Errorf("rollback error (%v) after %w", rbe, err)
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// Sessions that were started with the old address end with it
+	if emailChanged {
+		if err = s.sessions.RevokeAll(ctx, user.Id); err != nil {
+			return err
+		}
+		user.Name, user.Email = name, email
+		return s.verifications.Send(ctx, user)
+	}
+
+	return nil
+}
+
+func (s *userService) setActive(ctx context.Context, user repositories.User, active bool) error {
+
+	if active == (user.DeactivatedAt == 0) {
+		return nil
 	}
+	if !active && user.Id == ctx.Value("userId") {
+		return utils.NewValidationError("you cannot deactivate yourself", nil)
+	}
+
+	if err := s.userRepo.SetDeactivated(ctx, user.Id, !active); err != nil {
+		return err
+	}
+
+	if !active {
+		return s.sessions.RevokeAll(ctx, user.Id)
+	}
+
+	return nil
+}
+
+func managedElsewhere() error {
+	return utils.NewForbiddenError("the user is managed by another account")
 }
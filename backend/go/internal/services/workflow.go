@@ -0,0 +1,172 @@
+package services
+
+import (
+	"cerberus-examples/internal/repositories"
+	"cerberus-examples/internal/utils"
+	"fmt"
+)
+
+// defaultWorkflow returns the workflow of a new project, whose states are the
+// story statuses of the account. The first status is to do and the last one
+// is done, and stories move between neighbouring statuses both ways.
+func defaultWorkflow(statuses []string) ([]repositories.WorkflowState, []repositories.WorkflowTransition) {
+	states := make([]repositories.WorkflowState, 0, len(statuses))
+	for i, status := range statuses {
+		category := repositories.WorkflowCategoryInProgress
+		switch {
+		case i == 0:
+			category = repositories.WorkflowCategoryTodo
+		case i == len(statuses)-1:
+			category = repositories.WorkflowCategoryDone
+		}
+		states = append(states, repositories.WorkflowState{Name: status, Category: category})
+	}
+
+	var transitions []repositories.WorkflowTransition
+	for i := 1; i < len(statuses); i++ {
+		transitions = append(transitions,
+			repositories.WorkflowTransition{From: statuses[i-1], To: statuses[i]},
+			repositories.WorkflowTransition{From: statuses[i], To: statuses[i-1]},
+		)
+	}
+	return states, transitions
+}
+
+// workflowCategories are the categories that workflow states can have
+var workflowCategories = []string{
+	repositories.WorkflowCategoryTodo,
+	repositories.WorkflowCategoryInProgress,
+	repositories.WorkflowCategoryDone,
+}
+
+// validateWorkflow returns a validation error that lists what is wrong with
+// the states and transitions of a workflow: states need a name of their own
+// and a known category, and transitions are between two different states of
+// the workflow.
+func validateWorkflow(states []repositories.WorkflowState, transitions []repositories.WorkflowTransition) error {
+	var violations []utils.Violation
+	if len(states) == 0 {
+		violations = append(violations, utils.Violation{
+			Field:   "states",
+			Rule:    "required",
+			Message: "must have a state",
+		})
+	}
+
+	names := make([]string, 0, len(states))
+	for i, state := range states {
+		field := fmt.Sprintf("states[%d]", i)
+		switch {
+		case state.Name == "":
+			violations = append(violations, utils.Violation{
+				Field:   field + ".name",
+				Rule:    "required",
+				Message: "is required",
+			})
+		case contains(names, state.Name):
+			violations = append(violations, utils.Violation{
+				Field:   field + ".name",
+				Rule:    "unique",
+				Message: fmt.Sprintf("%s is the name of another state", state.Name),
+			})
+		}
+		if !contains(workflowCategories, state.Category) {
+			violations = append(violations, utils.Violation{
+				Field:   field + ".category",
+				Rule:    "oneof",
+				Message: fmt.Sprintf("must be one of %q", workflowCategories),
+			})
+		}
+		names = append(names, state.Name)
+	}
+
+	seen := map[repositories.WorkflowTransition]bool{}
+	for i, transition := range transitions {
+		field := fmt.Sprintf("transitions[%d]", i)
+		for _, end := range []struct{ field, name string }{{"from", transition.From}, {"to", transition.To}} {
+			if !contains(names, end.name) {
+				violations = append(violations, utils.Violation{
+					Field:   field + "." + end.field,
+					Rule:    "state",
+					Message: fmt.Sprintf("must be one of %q", names),
+				})
+			}
+		}
+		if transition.From == transition.To {
+			violations = append(violations, utils.Violation{
+				Field:   field,
+				Rule:    "transition",
+				Message: "must be between two different states",
+			})
+		}
+		if seen[transition] {
+			violations = append(violations, utils.Violation{
+				Field:   field,
+				Rule:    "unique",
+				Message: fmt.Sprintf("%s to %s is allowed already", transition.From, transition.To),
+			})
+		}
+		seen[transition] = true
+	}
+
+	if len(violations) > 0 {
+		return utils.NewViolationsError(violations)
+	}
+	return nil
+}
+
+// checkTransition returns a validation error for a status that is not a
+// state of the workflow, and a conflict for a transition that the workflow
+// doesn't allow. Stories in a state that the workflow doesn't have may move
+// to any state, so that they aren't stuck.
+func checkTransition(workflow repositories.Workflow, from, to string) error {
+	states := stateNames(workflow)
+	if !contains(states, to) {
+		return utils.NewViolationsError([]utils.Violation{{
+			Field:   "status",
+			Rule:    "status",
+			Message: fmt.Sprintf("must be one of %q", states),
+		}})
+	}
+	if from == to || !contains(states, from) {
+		return nil
+	}
+
+	allowed := nextStates(workflow, from)
+	if !contains(allowed, to) {
+		return utils.NewConflictError(fmt.Sprintf("stories can't move from %s to %s", from, to), map[string]interface{}{
+			"from":    from,
+			"to":      to,
+			"allowed": allowed,
+		})
+	}
+	return nil
+}
+
+func stateNames(workflow repositories.Workflow) []string {
+	names := make([]string, 0, len(workflow.States))
+	for _, state := range workflow.States {
+		names = append(names, state.Name)
+	}
+	return names
+}
+
+// nextStates returns the states that stories in the state may move to
+func nextStates(workflow repositories.Workflow, from string) []string {
+	next := []string{}
+	for _, transition := range workflow.Transitions {
+		if transition.From == from {
+			next = append(next, transition.To)
+		}
+	}
+	return next
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the exporters
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOtlp   = "otlp"
+)
+
+// NewExporter returns the exporter with the name. The endpoint is the base
+// url of an OTLP/HTTP collector, which receives spans on /v1/traces.
+func NewExporter(name, serviceName, endpoint string, w io.Writer) (Exporter, error) {
+	switch name {
+	case ExporterStdout:
+		return NewStdoutExporter(w), nil
+	case ExporterOtlp:
+		return NewOtlpExporter(serviceName, endpoint), nil
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", name)
+	}
+}
+
+type stdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter returns an exporter that writes spans as lines of JSON,
+// for local development
+func NewStdoutExporter(w io.Writer) Exporter {
+	return &stdoutExporter{w: w}
+}
+
+type stdoutSpan struct {
+	TraceId      string                 `json:"traceId"`
+	SpanId       string                 `json:"spanId"`
+	ParentSpanId string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	DurationMs   float64                `json:"durationMs"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+func (e *stdoutExporter) Export(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	encoder := json.NewEncoder(e.w)
+	for _, span := range spans {
+		s := stdoutSpan{
+			TraceId:    span.TraceId.String(),
+			SpanId:     span.SpanId.String(),
+			Name:       span.Name,
+			Kind:       span.Kind,
+			Start:      span.Start,
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.ParentSpanId.IsValid() {
+			s.ParentSpanId = span.ParentSpanId.String()
+		}
+		if err := encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *stdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+type otlpExporter struct {
+	serviceName string
+	url         string
+	client      *http.Client
+}
+
+// NewOtlpExporter returns an exporter that posts spans to an OpenTelemetry
+// collector with OTLP/HTTP, encoded as JSON
+func NewOtlpExporter(serviceName, endpoint string) Exporter {
+	return &otlpExporter{
+		serviceName: serviceName,
+		url:         strings.TrimRight(endpoint, "/") + "/v1/traces",
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// The OTLP messages, as far as they are used. Ids are hex encoded and
+// timestamps are strings in JSON.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceId           string          `json:"traceId"`
+		SpanId            string          `json:"spanId"`
+		ParentSpanId      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+var otlpKinds = map[string]int{KindInternal: 1, KindServer: 2, KindClient: 3}
+
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func (e *otlpExporter) Export(ctx context.Context, spans []SpanData) error {
+	scopeSpans := otlpScopeSpans{Scope: otlpScope{Name: "cerberus-examples"}}
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceId:           span.TraceId.String(),
+			SpanId:            span.SpanId.String(),
+			Name:              span.Name,
+			Kind:              otlpKinds[span.Kind],
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if span.ParentSpanId.IsValid() {
+			s.ParentSpanId = span.ParentSpanId.String()
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, s)
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes(map[string]interface{}{
+			"service.name": e.serviceName,
+		})},
+		ScopeSpans: []otlpScopeSpans{scopeSpans},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("collector answered %s", res.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) Shutdown(ctx context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case string:
+			v = map[string]interface{}{"stringValue": value}
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int:
+			v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+		}
+		result = append(result, otlpAttribute{Key: key, Value: v})
+	}
+	return result
+}
@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// collector stands in for an OpenTelemetry collector, and keeps the spans
+// that are posted to it
+type collector struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	requests []otlpRequest
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{t: t}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("unexpected content type %s", contentType)
+		}
+		var request otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		c.requests = append(c.requests, request)
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// spans returns the collected spans by name, with the service name of their
+// resource
+func (c *collector) spans() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	spans := map[string]otlpSpan{}
+	for _, request := range c.requests {
+		for _, resourceSpans := range request.ResourceSpans {
+			if name := attribute(resourceSpans.Resource.Attributes, "service.name"); name != "test" {
+				c.t.Errorf("expected the spans of service test, got %v", name)
+			}
+			for _, scopeSpans := range resourceSpans.ScopeSpans {
+				for _, span := range scopeSpans.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+func attribute(attributes []otlpAttribute, key string) interface{} {
+	for _, a := range attributes {
+		if a.Key == key {
+			for _, value := range a.Value {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// TestOtlpExport traces a request that a service answers with SQL
+// statements, and checks that the collector gets the spans of the request,
+// the service and the statements in one trace
+func TestOtlpExport(t *testing.T) {
+	collector := newCollector(t)
+	provider := NewProvider(NewOtlpExporter("test", collector.URL))
+	SetProvider(provider)
+	t.Cleanup(func() { SetProvider(nil) })
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// The caller of the request started the trace
+	const traceId, callerId = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-"+traceId+"-"+callerId+"-01")
+
+	ctx, request := StartServer(context.Background(), "GET /api/projects/:projectId", header,
+		"http.method", http.MethodGet)
+	serviceCtx, service := Start(ctx, "ProjectService.Get")
+	if _, err = Exec(serviceCtx, db, "create table project (id string)"); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Prepare(serviceCtx, db, "select count(*) from project where id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err = stmt.QueryRowContext(serviceCtx, "id").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	service.End()
+	request.SetAttributes("http.status_code", http.StatusOK)
+	request.End()
+
+	// Shutting down exports the spans that are queued
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := collector.spans()
+	requestSpan, ok := spans["GET /api/projects/:projectId"]
+	if !ok {
+		t.Fatalf("expected the span of the request, got %v", spans)
+	}
+	if requestSpan.TraceId != traceId || requestSpan.ParentSpanId != callerId {
+		t.Errorf("expected the request to continue trace %s of span %s, got %s of %s",
+			traceId, callerId, requestSpan.TraceId, requestSpan.ParentSpanId)
+	}
+	if requestSpan.Kind != otlpKinds[KindServer] {
+		t.Errorf("expected a server span, got kind %d", requestSpan.Kind)
+	}
+	if status := attribute(requestSpan.Attributes, "http.status_code"); status != "200" {
+		t.Errorf("expected status 200, got %v", status)
+	}
+
+	serviceSpan, ok := spans["ProjectService.Get"]
+	if !ok {
+		t.Fatalf("expected the span of the service, got %v", spans)
+	}
+	if serviceSpan.ParentSpanId != requestSpan.SpanId || serviceSpan.Kind != otlpKinds[KindInternal] {
+		t.Errorf("expected an internal span in the request, got %+v", serviceSpan)
+	}
+
+	for name, statement := range map[string]string{
+		"sql create": "create table project (id string)",
+		"sql select": "select count(*) from project where id = ?",
+	} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("expected the span %s, got %v", name, spans)
+			continue
+		}
+		if span.TraceId != traceId || span.ParentSpanId != serviceSpan.SpanId {
+			t.Errorf("expected span %s in the service, got %+v", name, span)
+		}
+		if got := attribute(span.Attributes, "db.statement"); got != statement {
+			t.Errorf("expected statement %q, got %v", statement, got)
+		}
+	}
+	if rows := attribute(spans["sql select"].Attributes, "db.rows"); rows != "1" {
+		t.Errorf("expected the query to count 1 row, got %v", rows)
+	}
+}
+
+func TestOtlpExportRefused(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := NewOtlpExporter("test", collector.URL)
+	if err := exporter.Export(context.Background(), []SpanData{{Name: "span"}}); err == nil {
+		t.Error("expected an error when the collector refuses the spans")
+	}
+}
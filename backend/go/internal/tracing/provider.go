@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"cerberus-examples/internal/logging"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize     = 2048
+	batchSize     = 256
+	batchInterval = 5 * time.Second
+)
+
+// Exporter sends ended spans to where they are looked at
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Provider hands the ended spans in batches to the exporter. Spans are
+// dropped when the exporter can't keep up.
+type Provider struct {
+	exporter Exporter
+	queue    chan SpanData
+	done     chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+}
+
+func NewProvider(exporter Exporter) *Provider {
+	p := &Provider{
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *Provider) enqueue(span SpanData) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- span:
+	default:
+		logging.Default().Debug("span dropped, the export queue is full", "span", span.Name)
+	}
+}
+
+func (p *Provider) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	for {
+		select {
+		case span, ok := <-p.queue:
+			if !ok {
+				p.export(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) == batchSize {
+				p.export(batch)
+				batch = make([]SpanData, 0, batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.export(batch)
+				batch = make([]SpanData, 0, batchSize)
+			}
+		}
+	}
+}
+
+func (p *Provider) export(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.exporter.Export(ctx, batch); err != nil {
+		logging.Default().Warn("exporting spans failed", "error", err, "spans", len(batch))
+	}
+}
+
+// Shutdown exports the spans that are still queued, and shuts the exporter
+// down. Spans that end afterwards are dropped.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.exporter.Shutdown(ctx)
+}
@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Preparer prepares statements, like sql.DB and sql.Tx do
+type Preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// Execer executes statements, like sql.DB and sql.Tx do
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Stmt is a prepared statement with a span that lasts until the statement is
+// closed. The span counts the rows that queries return and that updates
+// affect.
+type Stmt struct {
+	*sql.Stmt
+	span    *Span
+	queried bool
+	rows    int64
+}
+
+// Prepare prepares the statement, and starts a span for it
+func Prepare(ctx context.Context, db Preparer, query string) (*Stmt, error) {
+	ctx, span := startStatement(ctx, query)
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	return &Stmt{Stmt: stmt, span: span}, nil
+}
+
+// Exec executes a statement that is not prepared in a span of its own
+func Exec(ctx context.Context, db Execer, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startStatement(ctx, query)
+	defer span.End()
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		span.SetError(err)
+		return result, err
+	}
+	if affected, err := result.RowsAffected(); err == nil {
+		span.SetAttributes("db.rows_affected", affected)
+	}
+	return result, nil
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (sql.Result, error) {
+	result, err := s.Stmt.ExecContext(ctx, args...)
+	if err != nil {
+		s.span.SetError(err)
+		return result, err
+	}
+	if affected, err := result.RowsAffected(); err == nil {
+		s.span.SetAttributes("db.rows_affected", affected)
+	}
+	return result, nil
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	s.queried = true
+	rows, err := s.Stmt.QueryContext(ctx, args...)
+	if err != nil {
+		s.span.SetError(err)
+		return nil, err
+	}
+	return &Rows{Rows: rows, stmt: s}, nil
+}
+
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) *Row {
+	s.queried = true
+	return &Row{Row: s.Stmt.QueryRowContext(ctx, args...), stmt: s}
+}
+
+// Close closes the statement and ends its span
+func (s *Stmt) Close() error {
+	err := s.Stmt.Close()
+	if s.queried {
+		s.span.SetAttributes("db.rows", s.rows)
+	}
+	s.span.End()
+	return err
+}
+
+// Rows are the rows of a query, which are counted as they are read
+type Rows struct {
+	*sql.Rows
+	stmt *Stmt
+}
+
+func (r *Rows) Next() bool {
+	if !r.Rows.Next() {
+		if err := r.Rows.Err(); err != nil {
+			r.stmt.span.SetError(err)
+		}
+		return false
+	}
+	r.stmt.rows++
+	return true
+}
+
+// Row is the single row of a query
+type Row struct {
+	*sql.Row
+	stmt *Stmt
+}
+
+// Scan scans the row. A query that found no row is not an error of the span.
+func (r *Row) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	switch {
+	case err == nil:
+		r.stmt.rows++
+	case !errors.Is(err, sql.ErrNoRows):
+		r.stmt.span.SetError(err)
+	}
+	return err
+}
+
+func startStatement(ctx context.Context, query string) (context.Context, *Span) {
+	return Start(ctx, "sql "+operation(query),
+		"db.system", "sqlite",
+		"db.operation", operation(query),
+		"db.statement", query,
+	)
+}
+
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	return strings.ToLower(fields[0])
+}
@@ -0,0 +1,292 @@
+// Package tracing records spans of the work done for requests, in the style
+// of OpenTelemetry. Spans are taken from the context, continue the trace of
+// the caller given in a W3C traceparent header, and are handed in batches to
+// an exporter. Without a provider, no spans are recorded.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of spans
+const (
+	KindInternal = "internal"
+	KindServer   = "server"
+	KindClient   = "client"
+)
+
+// TraceparentHeader carries the trace of a request between services
+const TraceparentHeader = "traceparent"
+
+type TraceId [16]byte
+
+type SpanId [8]byte
+
+func (t TraceId) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (t TraceId) IsValid() bool {
+	return t != TraceId{}
+}
+
+func (s SpanId) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+func (s SpanId) IsValid() bool {
+	return s != SpanId{}
+}
+
+// SpanContext identifies a span within its trace. Spans of traces that are
+// not sampled are propagated but not exported.
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceId.IsValid() && sc.SpanId.IsValid()
+}
+
+// Span is an operation of a trace. The methods of a nil span do nothing, so
+// that code can be traced whether or not a provider is set.
+type Span struct {
+	provider    *Provider
+	context     SpanContext
+	parentId    SpanId
+	name        string
+	kind        string
+	start       time.Time
+	mu          sync.Mutex
+	attributes  map[string]interface{}
+	errorString string
+	ended       bool
+}
+
+// SpanData is an ended span, as it is exported
+type SpanData struct {
+	TraceId      TraceId
+	SpanId       SpanId
+	ParentSpanId SpanId
+	Name         string
+	Kind         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+var provider *Provider
+
+// SetProvider sets the provider of the spans started from now on. A nil
+// provider stops tracing.
+func SetProvider(p *Provider) {
+	provider = p
+}
+
+// FromContext returns the span of the context, if any
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value("span").(*Span)
+	return span
+}
+
+// ContextWithSpan returns a context with the span, which becomes the parent of
+// the spans started with it
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, "span", span)
+}
+
+// Start starts a span that is a child of the span of the context, and returns
+// a context with the new span. The arguments alternate between the keys and
+// values of attributes.
+func Start(ctx context.Context, name string, args ...interface{}) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return start(ctx, name, KindInternal, SpanContext{}, args)
+	}
+	return start(ctx, name, KindInternal, parent.context, args)
+}
+
+// StartServer starts a span for a request that the app answers. It continues
+// the trace of the caller when the headers of the request carry one.
+func StartServer(ctx context.Context, name string, header http.Header, args ...interface{}) (context.Context, *Span) {
+	parent, _ := Extract(header)
+	return start(ctx, name, KindServer, parent, args)
+}
+
+// StartClient starts a span for a request that the app makes, and adds its
+// trace to the headers of the request
+func StartClient(ctx context.Context, name string, header http.Header, args ...interface{}) (context.Context, *Span) {
+	ctx, span := Start(ctx, name, args...)
+	if span != nil {
+		span.kind = KindClient
+	}
+	Inject(ctx, header)
+	return ctx, span
+}
+
+func start(ctx context.Context, name, kind string, parent SpanContext, args []interface{}) (context.Context, *Span) {
+	p := provider
+	if p == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		provider:   p,
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+	if parent.IsValid() {
+		span.context = SpanContext{TraceId: parent.TraceId, Sampled: parent.Sampled}
+		span.parentId = parent.SpanId
+	} else {
+		span.context = SpanContext{TraceId: newTraceId(), Sampled: true}
+	}
+	span.context.SpanId = newSpanId()
+
+	if accountId, ok := ctx.Value("accountId").(string); ok && accountId != "" {
+		span.attributes["account.id"] = accountId
+	}
+	span.SetAttributes(args...)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetName replaces the name that the span was started with
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds attributes to the span. The arguments alternate between
+// keys and values.
+func (s *Span) SetAttributes(args ...interface{}) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		s.attributes[fmt.Sprint(args[i])] = args[i+1]
+	}
+}
+
+// SetError marks the span as failed with the error
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.errorString = err.Error()
+	}
+}
+
+// End ends the span, which is then exported if its trace is sampled. Spans
+// can be ended once.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+	if !s.context.Sampled {
+		return
+	}
+
+	s.provider.enqueue(SpanData{
+		TraceId:      s.context.TraceId,
+		SpanId:       s.context.SpanId,
+		ParentSpanId: s.parentId,
+		Name:         s.name,
+		Kind:         s.kind,
+		Start:        s.start,
+		End:          time.Now(),
+		Attributes:   s.attributes,
+		Error:        s.errorString,
+	})
+}
+
+// Extract returns the span context of the caller from a traceparent header,
+// which is "00-<trace id>-<parent id>-<flags>"
+func Extract(header http.Header) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header.Get(TraceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	var flags [1]byte
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceId[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanId[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+// Inject adds the trace of the span of the context to the headers of a
+// request that the app makes
+func Inject(ctx context.Context, header http.Header) {
+	sc := FromContext(ctx).Context()
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set(TraceparentHeader, "00-"+sc.TraceId.String()+"-"+sc.SpanId.String()+"-"+flags)
+}
+
+func newTraceId() (id TraceId) {
+	_, _ = rand.Read(id[:])
+	return
+}
+
+func newSpanId() (id SpanId) {
+	_, _ = rand.Read(id[:])
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 )
 
 // DeferredClose handles errors that happen with deferred calls
@@ -29,24 +30,124 @@ func PanicOnError(err error) {
 	}
 }
 
+// Codes of domain errors. Clients can rely on them, unlike on the messages.
+const (
+	CodeBadRequest      = "bad_request"
+	CodeValidation      = "validation_failed"
+	CodeUnauthorized    = "unauthorized"
+	CodeForbidden       = "forbidden"
+	CodeNotFound        = "not_found"
+	CodeConflict        = "conflict"
+	CodeTooManyRequests = "too_many_requests"
+	CodeInternal        = "internal_error"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnprocessableEntity: CodeValidation,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusTooManyRequests:     CodeTooManyRequests,
+}
+
+// DomainError is an error that the caller of the app made, with the status
+// code of the response and a stable code
 type DomainError struct {
 	statusCode int
+	code       string
 	message    string
 	details    map[string]interface{}
 }
 
+// NewDomainError returns an error with the code of its status code
 func NewDomainError(statusCode int, message string, details map[string]interface{}) error {
+	code, ok := statusCodes[statusCode]
+	if !ok {
+		code = CodeInternal
+		if statusCode < http.StatusInternalServerError {
+			code = CodeBadRequest
+		}
+	}
 	return &DomainError{
 		statusCode: statusCode,
+		code:       code,
 		message:    message,
 		details:    details,
 	}
 }
 
+// NewNotFoundError is returned for resources that don't exist, or that the
+// caller may not know about
+func NewNotFoundError(resource string) error {
+	return &DomainError{
+		statusCode: http.StatusNotFound,
+		code:       CodeNotFound,
+		message:    resource + " not found",
+		details:    map[string]interface{}{"resource": resource},
+	}
+}
+
+// NewConflictError is returned for requests that clash with the state of a
+// resource, like a value that must be unique
+func NewConflictError(message string, details map[string]interface{}) error {
+	return &DomainError{
+		statusCode: http.StatusConflict,
+		code:       CodeConflict,
+		message:    message,
+		details:    details,
+	}
+}
+
+// Violation is a rule that a field of a request breaks. The field is named as
+// in the request.
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// NewValidationError is returned for requests with missing or invalid values
+func NewValidationError(message string, details map[string]interface{}) error {
+	return &DomainError{
+		statusCode: http.StatusUnprocessableEntity,
+		code:       CodeValidation,
+		message:    message,
+		details:    details,
+	}
+}
+
+// NewViolationsError is returned for requests with fields that break rules,
+// which are all listed
+func NewViolationsError(violations []Violation) error {
+	return NewValidationError("the request is invalid", map[string]interface{}{"violations": violations})
+}
+
+// NewUnauthorizedError is returned for requests whose caller can't be
+// authenticated
+func NewUnauthorizedError(message string) error {
+	return &DomainError{
+		statusCode: http.StatusUnauthorized,
+		code:       CodeUnauthorized,
+		message:    message,
+	}
+}
+
+// NewForbiddenError is returned for requests that the caller is not allowed
+// to make
+func NewForbiddenError(message string) error {
+	return &DomainError{
+		statusCode: http.StatusForbidden,
+		code:       CodeForbidden,
+		message:    message,
+	}
+}
+
 func (d *DomainError) Error() string {
 	errorJson, _ := json.Marshal(map[string]interface{}{
 		"status":  "error",
-		"code":    d.statusCode,
+		"code":    d.code,
 		"message": d.message,
 		"details": d.details,
 	})
@@ -56,3 +157,16 @@ func (d *DomainError) Error() string {
 func (d *DomainError) StatusCode() int {
 	return d.statusCode
 }
+
+// Code is the stable code of the error, like not_found
+func (d *DomainError) Code() string {
+	return d.code
+}
+
+func (d *DomainError) Message() string {
+	return d.message
+}
+
+func (d *DomainError) Details() map[string]interface{} {
+	return d.details
+}
@@ -0,0 +1,3 @@
+ALTER TABLE user DROP COLUMN role_id;
+DROP TABLE IF EXISTS role_permission;
+DROP TABLE IF EXISTS role;
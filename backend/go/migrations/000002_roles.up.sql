@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS role (id string not null primary key, account_id string,
+    name string not null, built_in int not null default 0,
+    CONSTRAINT fk_account
+        FOREIGN KEY (account_id) REFERENCES account (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE TABLE IF NOT EXISTS role_permission (role_id string not null, permission string not null,
+    PRIMARY KEY (role_id, permission),
+    CONSTRAINT fk_role
+        FOREIGN KEY (role_id) REFERENCES role (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+
+INSERT INTO role (id, account_id, name, built_in) VALUES
+    ('admin', NULL, 'Admin', 1),
+    ('member', NULL, 'Member', 1),
+    ('viewer', NULL, 'Viewer', 1);
+
+INSERT INTO role_permission (role_id, permission) VALUES
+    ('admin', 'project:read'), ('admin', 'project:write'), ('admin', 'project:delete'),
+    ('admin', 'sprint:read'), ('admin', 'sprint:write'),
+    ('admin', 'story:read'), ('admin', 'story:write'),
+    ('admin', 'user:read'), ('admin', 'user:write'),
+    ('admin', 'role:read'), ('admin', 'role:write'),
+    ('member', 'project:read'), ('member', 'project:write'),
+    ('member', 'sprint:read'), ('member', 'sprint:write'),
+    ('member', 'story:read'), ('member', 'story:write'),
+    ('member', 'user:read'), ('member', 'role:read'),
+    ('viewer', 'project:read'), ('viewer', 'sprint:read'), ('viewer', 'story:read'),
+    ('viewer', 'user:read'), ('viewer', 'role:read');
+
+ALTER TABLE user ADD COLUMN role_id string;
+UPDATE user SET role_id = 'admin';
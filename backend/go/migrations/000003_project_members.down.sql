@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS project_member;
+
+INSERT OR IGNORE INTO role_permission (role_id, permission) VALUES
+    ('member', 'project:read'), ('member', 'sprint:read'), ('member', 'sprint:write'),
+    ('member', 'story:read'), ('member', 'story:write'),
+    ('viewer', 'project:read'), ('viewer', 'sprint:read'), ('viewer', 'story:read');
+DELETE FROM role_permission WHERE permission = 'project:members';
+DELETE FROM role WHERE scope = 'project';
+
+ALTER TABLE role DROP COLUMN scope;
@@ -0,0 +1,46 @@
+ALTER TABLE role ADD COLUMN scope string not null default 'account';
+
+INSERT INTO role (id, account_id, name, built_in, scope) VALUES
+    ('owner', NULL, 'Owner', 1, 'project'),
+    ('maintainer', NULL, 'Maintainer', 1, 'project'),
+    ('contributor', NULL, 'Contributor', 1, 'project'),
+    ('reporter', NULL, 'Reporter', 1, 'project');
+
+INSERT INTO role_permission (role_id, permission) VALUES
+    ('owner', 'project:read'), ('owner', 'project:write'), ('owner', 'project:delete'),
+    ('owner', 'project:members'),
+    ('owner', 'sprint:read'), ('owner', 'sprint:write'),
+    ('owner', 'story:read'), ('owner', 'story:write'),
+    ('maintainer', 'project:read'), ('maintainer', 'project:write'), ('maintainer', 'project:members'),
+    ('maintainer', 'sprint:read'), ('maintainer', 'sprint:write'),
+    ('maintainer', 'story:read'), ('maintainer', 'story:write'),
+    ('contributor', 'project:read'), ('contributor', 'sprint:read'),
+    ('contributor', 'story:read'), ('contributor', 'story:write'),
+    ('reporter', 'project:read'), ('reporter', 'sprint:read'), ('reporter', 'story:read'),
+    ('admin', 'project:members');
+
+-- Members and viewers only see the projects they are members of. Members can
+-- still create projects, of which they become the owner.
+DELETE FROM role_permission WHERE role_id IN ('member', 'viewer')
+    AND permission IN ('project:read', 'sprint:read', 'sprint:write', 'story:read', 'story:write');
+
+CREATE TABLE IF NOT EXISTS project_member (project_id string not null, user_id string not null,
+    role_id string not null, created_at sqlite3_int64 not null,
+    PRIMARY KEY (project_id, user_id),
+    CONSTRAINT fk_project
+        FOREIGN KEY (project_id) REFERENCES project (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_role
+        FOREIGN KEY (role_id) REFERENCES role (id)
+        ON UPDATE CASCADE);
+
+-- Keep existing members and viewers on the projects they could see before
+INSERT INTO project_member (project_id, user_id, role_id, created_at)
+    SELECT p.id, u.id, CASE u.role_id WHEN 'viewer' THEN 'reporter' ELSE 'contributor' END, strftime('%s', 'now')
+    FROM project p JOIN user u ON u.account_id = p.account_id
+    WHERE u.role_id IN ('member', 'viewer');
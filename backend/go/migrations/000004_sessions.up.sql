@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS refresh_token (id string not null primary key, family_id string not null,
+    user_id string not null, account_id string not null, token_hash string not null unique,
+    access_token_id string not null, access_expires_at sqlite3_int64 not null,
+    created_at sqlite3_int64 not null, expires_at sqlite3_int64 not null,
+    revoked_at sqlite3_int64, replaced_by string,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS refresh_token_family ON refresh_token (family_id);
+CREATE INDEX IF NOT EXISTS refresh_token_user ON refresh_token (user_id);
+CREATE TABLE IF NOT EXISTS revoked_token (id string not null primary key, expires_at sqlite3_int64 not null);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS password_reset (id string not null primary key, user_id string not null,
+    token_hash string not null unique, created_at sqlite3_int64 not null,
+    expires_at sqlite3_int64 not null, used_at sqlite3_int64,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS password_reset_user ON password_reset (user_id);
@@ -0,0 +1,18 @@
+ALTER TABLE user ADD COLUMN email_verified_at sqlite3_int64;
+UPDATE user SET email_verified_at = strftime('%s', 'now');
+CREATE TABLE IF NOT EXISTS email_verification (id string not null primary key, user_id string not null,
+    token_hash string not null unique, created_at sqlite3_int64 not null,
+    expires_at sqlite3_int64 not null, used_at sqlite3_int64,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE TABLE IF NOT EXISTS invite (id string not null primary key, account_id string not null,
+    email string not null, name string not null, role_id string not null, invited_by string not null,
+    token_hash string not null unique, created_at sqlite3_int64 not null,
+    expires_at sqlite3_int64 not null, accepted_at sqlite3_int64, revoked_at sqlite3_int64,
+    CONSTRAINT fk_account
+        FOREIGN KEY (account_id) REFERENCES account (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS invite_account ON invite (account_id);
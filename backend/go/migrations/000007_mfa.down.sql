@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS mfa_challenge;
+DROP TABLE IF EXISTS mfa_recovery_code;
+DROP TABLE IF EXISTS mfa_totp;
+DELETE FROM role_permission WHERE permission = 'account:write';
+ALTER TABLE account DROP COLUMN require_mfa;
@@ -0,0 +1,22 @@
+ALTER TABLE account ADD COLUMN require_mfa integer not null default 0;
+INSERT INTO role_permission (role_id, permission) VALUES ('admin', 'account:write');
+CREATE TABLE IF NOT EXISTS mfa_totp (user_id string not null primary key, secret string not null,
+    created_at sqlite3_int64 not null, confirmed_at sqlite3_int64, last_step sqlite3_int64 not null default 0,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE TABLE IF NOT EXISTS mfa_recovery_code (id string not null primary key, user_id string not null,
+    code_hash string not null, used_at sqlite3_int64,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS mfa_recovery_code_user ON mfa_recovery_code (user_id);
+CREATE TABLE IF NOT EXISTS mfa_challenge (id string not null primary key, user_id string not null,
+    token_hash string not null unique, created_at sqlite3_int64 not null, expires_at sqlite3_int64 not null,
+    attempts integer not null default 0, completed_at sqlite3_int64,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
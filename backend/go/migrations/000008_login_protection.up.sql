@@ -0,0 +1,13 @@
+-- Failures are recorded once for the email and once for the ip of a login,
+-- so that clearing the failures of one leaves those of the other
+CREATE TABLE IF NOT EXISTS login_failure (id string not null primary key, subject_type string not null,
+    subject string not null, created_at sqlite3_int64 not null);
+CREATE INDEX IF NOT EXISTS login_failure_subject ON login_failure (subject_type, subject, created_at);
+CREATE INDEX IF NOT EXISTS login_failure_created_at ON login_failure (created_at);
+CREATE TABLE IF NOT EXISTS login_lockout (subject_type string not null, subject string not null,
+    locked_until sqlite3_int64 not null, created_at sqlite3_int64 not null,
+    PRIMARY KEY (subject_type, subject));
+CREATE TABLE IF NOT EXISTS audit_log (id string not null primary key, account_id string,
+    actor_id string, action string not null, target string not null, details string not null,
+    ip string not null, created_at sqlite3_int64 not null);
+CREATE INDEX IF NOT EXISTS audit_log_account ON audit_log (account_id, created_at);
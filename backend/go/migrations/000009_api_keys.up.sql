@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_key (id string not null primary key, account_id string not null,
+    user_id string not null, name string not null, service integer not null default 0,
+    prefix string not null, token_hash string not null unique, scopes string not null,
+    created_by string not null, created_at sqlite3_int64 not null, expires_at sqlite3_int64,
+    last_used_at sqlite3_int64, revoked_at sqlite3_int64,
+    CONSTRAINT fk_account
+        FOREIGN KEY (account_id) REFERENCES account (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE INDEX IF NOT EXISTS api_key_account ON api_key (account_id);
+CREATE INDEX IF NOT EXISTS api_key_user ON api_key (user_id);
@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS oidc_login (id string not null primary key, state_hash string not null unique,
+    nonce string not null, code_verifier string not null, created_at sqlite3_int64 not null,
+    expires_at sqlite3_int64 not null, used_at sqlite3_int64);
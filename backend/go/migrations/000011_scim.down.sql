@@ -0,0 +1,2 @@
+ALTER TABLE user DROP COLUMN deactivated_at;
+ALTER TABLE user DROP COLUMN external_id;
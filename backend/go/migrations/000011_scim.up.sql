@@ -0,0 +1,2 @@
+ALTER TABLE user ADD COLUMN external_id string;
+ALTER TABLE user ADD COLUMN deactivated_at sqlite3_int64;
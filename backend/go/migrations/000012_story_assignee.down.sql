@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS story_old (id string not null primary key, sprint_id string not null,
+    estimation int not null, description string not null,
+    status string not null, user_id string,
+    CONSTRAINT fk_sprint
+        FOREIGN KEY (sprint_id) REFERENCES sprint (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_user
+    FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+INSERT INTO story_old (id, sprint_id, estimation, description, status, user_id)
+    SELECT id, sprint_id, estimation, description, status, user_id FROM story;
+DROP TABLE story;
+ALTER TABLE story_old RENAME TO story;
@@ -0,0 +1,5 @@
+ALTER TABLE mfa_challenge DROP COLUMN account_id;
+DROP INDEX IF EXISTS user_email;
+UPDATE user SET role_id = (SELECT m.role_id FROM account_member m
+    WHERE m.account_id = user.account_id AND m.user_id = user.id);
+DROP TABLE IF EXISTS account_member;
@@ -0,0 +1,48 @@
+-- Users can belong to several accounts, with a role in each. user.account_id
+-- is the account that created the user and manages its profile; user.role_id
+-- is no longer used.
+CREATE TABLE IF NOT EXISTS account_member (account_id string not null, user_id string not null,
+    role_id string not null, created_at sqlite3_int64 not null,
+    PRIMARY KEY (account_id, user_id),
+    CONSTRAINT fk_account
+        FOREIGN KEY (account_id) REFERENCES account (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_user
+        FOREIGN KEY (user_id) REFERENCES user (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_role
+        FOREIGN KEY (role_id) REFERENCES role (id)
+        ON UPDATE CASCADE);
+CREATE INDEX IF NOT EXISTS account_member_user ON account_member (user_id);
+
+INSERT INTO account_member (account_id, user_id, role_id, created_at)
+    SELECT account_id, id, coalesce(role_id, 'member'), strftime('%s', 'now') FROM user;
+
+-- Emails identify users across accounts, whatever their case. Users of
+-- several accounts with the same email are merged into the oldest of them,
+-- which takes over their memberships and stories.
+CREATE TEMP TABLE user_duplicate AS
+    SELECT d.id AS id, (SELECT k.id FROM user k WHERE lower(trim(k.email)) = lower(trim(d.email))
+        ORDER BY k.rowid LIMIT 1) AS kept_id
+    FROM user d;
+DELETE FROM user_duplicate WHERE id = kept_id;
+INSERT OR IGNORE INTO account_member (account_id, user_id, role_id, created_at)
+    SELECT m.account_id, d.kept_id, m.role_id, m.created_at
+    FROM account_member m JOIN user_duplicate d ON d.id = m.user_id;
+INSERT OR IGNORE INTO project_member (project_id, user_id, role_id, created_at)
+    SELECT m.project_id, d.kept_id, m.role_id, m.created_at
+    FROM project_member m JOIN user_duplicate d ON d.id = m.user_id;
+UPDATE story SET user_id = (SELECT d.kept_id FROM user_duplicate d WHERE d.id = story.user_id)
+    WHERE user_id IN (SELECT id FROM user_duplicate);
+DELETE FROM account_member WHERE user_id IN (SELECT id FROM user_duplicate);
+DELETE FROM project_member WHERE user_id IN (SELECT id FROM user_duplicate);
+DELETE FROM user WHERE id IN (SELECT id FROM user_duplicate);
+DROP TABLE user_duplicate;
+UPDATE user SET email = lower(trim(email));
+UPDATE invite SET email = lower(trim(email));
+CREATE UNIQUE INDEX IF NOT EXISTS user_email ON user (email COLLATE NOCASE);
+
+-- A second factor can be asked for when logging in to any of the accounts
+ALTER TABLE mfa_challenge ADD COLUMN account_id string;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS account_slug;
+ALTER TABLE account DROP COLUMN sprint_length_days;
+ALTER TABLE account DROP COLUMN story_statuses;
+ALTER TABLE account DROP COLUMN created_at;
+ALTER TABLE account DROP COLUMN owner_id;
+ALTER TABLE account DROP COLUMN slug;
+ALTER TABLE account DROP COLUMN name;
@@ -0,0 +1,23 @@
+-- Accounts have a name, a unique slug and an owner. The owner is not a
+-- foreign key, as it is checked by the services and would keep the column
+-- from being dropped again.
+ALTER TABLE account ADD COLUMN name string not null default '';
+ALTER TABLE account ADD COLUMN slug string;
+ALTER TABLE account ADD COLUMN owner_id string;
+ALTER TABLE account ADD COLUMN created_at sqlite3_int64 not null default 0;
+
+-- Settings of the account: the statuses that stories move through, and the
+-- planned length of a sprint
+ALTER TABLE account ADD COLUMN story_statuses string not null default '["todo","in progress","done"]';
+ALTER TABLE account ADD COLUMN sprint_length_days integer not null default 14;
+
+-- Existing accounts are owned by their oldest admin that they created, and
+-- are named after it
+UPDATE account SET owner_id = (SELECT m.user_id FROM account_member m
+        JOIN user u ON u.id = m.user_id
+        WHERE m.account_id = account.id AND m.role_id = 'admin'
+        ORDER BY u.account_id = m.account_id DESC, m.created_at ASC LIMIT 1),
+    created_at = strftime('%s', 'now');
+UPDATE account SET name = coalesce((SELECT u.name FROM user u WHERE u.id = account.owner_id), ''),
+    slug = 'account-' || substr(id, 1, 8);
+CREATE UNIQUE INDEX IF NOT EXISTS account_slug ON account (slug);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS workflow_transition;
+DROP INDEX IF EXISTS workflow_state_name;
+DROP TABLE IF EXISTS workflow_state;
@@ -0,0 +1,35 @@
+-- The workflow of a project: the states that its stories move through, in
+-- the order of the columns of the board, and the transitions between them.
+-- Stories refer to their state by name.
+CREATE TABLE IF NOT EXISTS workflow_state (id string not null primary key, project_id string not null,
+    name string not null, category string not null, position int not null,
+    CONSTRAINT fk_project
+        FOREIGN KEY (project_id) REFERENCES project (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+CREATE UNIQUE INDEX IF NOT EXISTS workflow_state_name ON workflow_state (project_id, name);
+
+CREATE TABLE IF NOT EXISTS workflow_transition (from_state_id string not null, to_state_id string not null,
+    PRIMARY KEY (from_state_id, to_state_id),
+    CONSTRAINT fk_from_state
+        FOREIGN KEY (from_state_id) REFERENCES workflow_state (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT fk_to_state
+        FOREIGN KEY (to_state_id) REFERENCES workflow_state (id)
+        ON UPDATE CASCADE
+        ON DELETE CASCADE);
+
+-- Existing projects get the default workflow of the story statuses of their
+-- account: the first status is to do, the last one is done, and stories move
+-- between neighbouring statuses
+INSERT INTO workflow_state (id, project_id, name, category, position)
+    SELECT lower(hex(randomblob(16))), p.id, s.value,
+        CASE WHEN s.key = 0 THEN 'todo'
+            WHEN s.key = json_array_length(a.story_statuses) - 1 THEN 'done'
+            ELSE 'in-progress' END,
+        s.key
+    FROM project p JOIN account a ON a.id = p.account_id, json_each(a.story_statuses) s;
+INSERT INTO workflow_transition (from_state_id, to_state_id)
+    SELECT f.id, t.id FROM workflow_state f
+    JOIN workflow_state t ON t.project_id = f.project_id AND abs(t.position - f.position) = 1;
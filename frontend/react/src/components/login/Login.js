@@ -13,6 +13,7 @@ export default function Login() {
     const {post, loading} = useFetch("/")
     const [email, setEmail] = useState()
     const [password, setPassword] = useState()
+    const [challenge, setChallenge] = useState(null)
 
     function handleLogin(e) {
         e.preventDefault()
@@ -24,6 +25,19 @@ export default function Login() {
             password: password
         }, {"Authorization": basicAuth})
             .then(r => {
+                // A login that requires a second factor answers with a token
+                // for the code step instead of an access token
+                if (r.mfaRequired) {
+                    if (!r.mfaEnrollmentRequired) {
+                        setChallenge({mfaToken: r.mfaToken})
+                        return
+                    }
+                    return post("auth/mfa/enroll", {mfaToken: r.mfaToken})
+                        .then(enrollment => setChallenge({mfaToken: r.mfaToken, enrollment: enrollment}))
+                }
+                if (!r.token) {
+                    return Promise.reject(r)
+                }
                 auth.login(r)
                 navigate("/")
             })
@@ -42,6 +56,10 @@ export default function Login() {
         return <Loader/>
     }
 
+    if (challenge) {
+        return <MfaLogin challenge={challenge} onCancel={() => setChallenge(null)}/>
+    }
+
     return <>
         <form onSubmit={handleLogin}>
             <Input required placeholder="Email" onChange={handleEmailChanged}/>
@@ -50,4 +68,71 @@ export default function Login() {
         </form>
         <Link to="/register">Register</Link>
     </>
-}
\ No newline at end of file
+}
+
+// MfaLogin completes a login with a code of the authenticator app, which is
+// set up first when the account requires one and the user has none yet
+function MfaLogin(props) {
+    const {challenge, onCancel} = props
+    const auth = useContext(AuthContext)
+    const navigate = useNavigate()
+    const {post, loading} = useFetch("/")
+    const [code, setCode] = useState()
+    const [recovery, setRecovery] = useState(false)
+    const {enrollment} = challenge
+
+    function handleVerify(e) {
+        e.preventDefault()
+
+        const body = recovery
+            ? {mfaToken: challenge.mfaToken, recoveryCode: code}
+            : {mfaToken: challenge.mfaToken, code: code}
+
+        post("auth/mfa/verify", body)
+            .then(r => {
+                auth.login(r)
+                navigate("/")
+            })
+            .catch(e => console.error(e))
+    }
+
+    function handleCodeChanged(e) {
+        setCode(e.target.value)
+    }
+
+    function handleRecoveryClicked(e) {
+        e.preventDefault()
+        setRecovery(!recovery)
+    }
+
+    if (loading) {
+        return <Loader/>
+    }
+
+    return <>
+        {
+            enrollment && <div>
+                <p>Your account requires two-factor authentication. Add this key to your authenticator app:</p>
+                <p><code>{enrollment.secret}</code></p>
+                <p><a href={enrollment.uri}>Open in authenticator app</a></p>
+                <p>Keep these recovery codes to log in without the app:</p>
+                <ul>
+                    {
+                        enrollment.recoveryCodes.map(recoveryCode => <li key={recoveryCode}><code>{recoveryCode}</code></li>)
+                    }
+                </ul>
+            </div>
+        }
+        <form onSubmit={handleVerify}>
+            <Input required placeholder={recovery ? "Recovery code" : "Code"} autoComplete="one-time-code"
+                   onChange={handleCodeChanged}/>
+            <Btn type="submit">Verify</Btn>
+        </form>
+        {
+            !enrollment && <Link to="" onClick={handleRecoveryClicked}>
+                {recovery ? "Use a code of the authenticator app" : "Use a recovery code"}
+            </Link>
+        }
+        <Link to="" onClick={onCancel}>Cancel</Link>
+    </>
+}
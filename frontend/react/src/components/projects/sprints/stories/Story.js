@@ -1,4 +1,5 @@
-import {useEffect, useState} from "react";
+import {useContext, useEffect, useState} from "react";
+import {ProjectContext} from "../../ProjectContext";
 import useFetch from "../../../../hooks/useFetch";
 import Loader from "../../../../uikit/Loader";
 import {Form, Tab, Tabs} from "react-bootstrap";
@@ -19,8 +20,10 @@ export default function Story(props) {
 
 
 function Dashboard(props) {
+    const projectCtx = useContext(ProjectContext)
     const {get, post, loading} = useFetch("/api/")
     const [users, setUsers] = useState([])
+    const [states, setStates] = useState([])
     const [estimate, setEstimate] = useState(0)
     const [status, setStatus] = useState("")
     const [assignee, setAssignee] = useState("")
@@ -30,6 +33,9 @@ function Dashboard(props) {
         get("users")
             .then(d => setUsers(d))
             .catch(e => console.error(e))
+        get("projects/"+projectCtx.project.id+"/workflow")
+            .then(d => setStates(d.states))
+            .catch(e => console.error(e))
     }, [])
 
     useEffect(() => {
@@ -96,9 +102,13 @@ function Dashboard(props) {
             <Form.Group className="mb-3">
                 <Form.Label>Status</Form.Label>
                 <Form.Select value={status} onChange={handleStatusChange}>
-                    <option value="todo">todo</option>
-                    <option value="busy">busy</option>
-                    <option value="done">done</option>
+                    {
+                        states.map(state => {
+                            return (
+                                <option key={state.id} value={state.name}>{state.name}</option>
+                            )
+                        })
+                    }
                 </Form.Select>
             </Form.Group>
             <Form.Group className="mb-3">
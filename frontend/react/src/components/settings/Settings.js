@@ -81,26 +81,20 @@ function AddUser() {
     const authCtx = useContext(AuthContext)
     const { post, loading } = useFetch('/api/')
     const [email, setEmail] = useState("")
-    const [password, setPassword] = useState("")
     const [name, setName] = useState("")
 
     function handleEmailChanged(e) {
         setEmail(e.target.value)
     }
 
-    function handlePasswordChanged(e) {
-        setPassword(e.target.value)
-    }
-
     function handleNameChanged(e) {
         setName(e.target.value)
     }
 
     function handleFormSubmit(e) {
         e.preventDefault()
-        post('users', {
+        post('invites', {
             email: email,
-            password: password,
             name: name
         })
             .then((r) => {
@@ -123,14 +117,6 @@ function AddUser() {
                     onChange={handleEmailChanged}
                 />
             </Form.Group>
-            <Form.Group className='mb-3'>
-                <Form.Label>Password</Form.Label>
-                <Form.Control
-                    type='text'
-                    placeholder='Enter password'
-                    onChange={handlePasswordChanged}
-                />
-            </Form.Group>
             <Form.Group className='mb-3'>
                 <Form.Label>Name</Form.Label>
                 <Form.Control
@@ -140,9 +126,9 @@ function AddUser() {
                 />
             </Form.Group>
 
-            <Button disabled={email === "" || password === "" || name === ""}
+            <Button disabled={email === "" || name === ""}
                     variant='primary' type='submit'>
-                Add
+                Invite
             </Button>
         </Form>
     </>
@@ -1,4 +1,4 @@
-import {createContext, useContext, useEffect, useState} from "react";
+import {createContext, useContext, useEffect, useRef} from "react";
 import {useNavigate} from "react-router-dom";
 import useSessionStorageState from 'use-session-storage-state';
 
@@ -6,19 +6,76 @@ const AuthContext = createContext(null)
 
 function AuthProvider(props) {
     const [user, setUser] = useSessionStorageState(`acme-user`, {defaultValue: null});
+    // The latest user and the refresh in flight, which requests that fail at
+    // the same time share, as a refresh token is used once
+    const current = useRef(user)
+    const refreshing = useRef(null)
+
+    const store = (user) => {
+        current.current = user
+        setUser(user)
+    }
 
     const logout = () => {
-        setUser(null)
+        const user = current.current
+        store(null)
+        if (!user) {
+            return
+        }
+        fetch("/auth/logout", {
+            method: "post",
+            headers: {
+                "Content-Type": "application/json",
+                "Authorization": "Bearer " + user.token,
+            },
+            body: JSON.stringify({refreshToken: user.refreshToken})
+        })
+            .catch(e => console.error(e))
     }
 
     const login = (user) => {
-        setUser(user)
+        store(user)
+    }
+
+    // refresh exchanges the refresh token for a new access token once the
+    // given one expired, and logs the user out when the session ended
+    const refresh = (token) => {
+        const user = current.current
+        if (!user || !user.refreshToken) {
+            return Promise.reject(new Error("not logged in"))
+        }
+        if (user.token !== token) {
+            return Promise.resolve(user)
+        }
+        if (!refreshing.current) {
+            refreshing.current = fetch("/auth/refresh", {
+                method: "post",
+                headers: {"Content-Type": "application/json"},
+                body: JSON.stringify({refreshToken: user.refreshToken})
+            })
+                .then(response => response.json())
+                .then(data => {
+                    if (!data || !data.data || !data.data.token) {
+                        // The session was revoked or expired
+                        store(null)
+                        return Promise.reject(data)
+                    }
+                    const refreshed = {...user, ...data.data}
+                    store(refreshed)
+                    return refreshed
+                })
+                .finally(() => {
+                    refreshing.current = null
+                })
+        }
+        return refreshing.current
     }
 
     const value = {
         user: user,
         login: login,
         logout: logout,
+        refresh: refresh,
     }
 
     return (
@@ -9,24 +9,31 @@ export default function useFetch(baseUrl) {
         "Content-Type": "application/json",
     }
 
-    let hdrs = defaultHeaders
-    if (authCtx.user) {
-        hdrs = {
-            ...hdrs,
-            "Authorization": "Bearer " + authCtx.user.token,
-        }
+    function authorization(user) {
+        return user ? {"Authorization": "Bearer " + user.token} : {}
     }
 
-    function get(url, headers) {
-        hdrs = { ...hdrs, ...headers }
+    // send makes the request, and when the access token of the user expired,
+    // refreshes it and makes the request again
+    function send(method, url, body, headers) {
+        const user = authCtx.user
+        const request = (user) => fetch(baseUrl + url, {
+            method: method,
+            headers: {...defaultHeaders, ...authorization(user), ...headers},
+            body: body === undefined ? undefined : JSON.stringify(body)
+        })
 
         return new Promise((resolve, reject) => {
             setLoading(true);
 
-            fetch(baseUrl + url, {
-                method: "get",
-                headers: hdrs
-            })
+            request(user)
+                .then(response => {
+                    if (response.status !== 401 || !user || (headers && headers.Authorization)) {
+                        return response
+                    }
+                    return authCtx.refresh(user.token)
+                        .then(request, () => response)
+                })
                 .then(response => response.json())
                 .then(data => {
                     setLoading(false);
@@ -42,79 +49,21 @@ export default function useFetch(baseUrl) {
         });
     }
 
-    function post(url, body, headers) {
-        hdrs = { ...hdrs, ...headers }
+    function get(url, headers) {
+        return send("get", url, undefined, headers)
+    }
 
-        return new Promise((resolve, reject) => {
-            setLoading(true);
-            fetch(baseUrl + url, {
-                method: "post",
-                headers: hdrs,
-                body: JSON.stringify(body)
-            })
-                .then(response => response.json())
-                .then(data => {
-                    setLoading(false);
-                    if (!data || !data.data) {
-                        return reject(data);
-                    }
-                    resolve(data.data);
-                })
-                .catch(error => {
-                    setLoading(false);
-                    reject(error);
-                });
-        });
+    function post(url, body, headers) {
+        return send("post", url, body, headers)
     }
 
     function put(url, body, headers) {
-        hdrs = { ...hdrs, ...headers }
-
-        return new Promise((resolve, reject) => {
-            setLoading(true);
-            fetch(baseUrl + url, {
-                method: "put",
-                headers: hdrs,
-                body: JSON.stringify(body)
-            })
-                .then(response => response.json())
-                .then(data => {
-                    setLoading(false);
-                    if (!data || !data.data) {
-                        return reject(data);
-                    }
-                    resolve(data.data);
-                })
-                .catch(error => {
-                    setLoading(false);
-                    reject(error);
-                });
-        });
+        return send("put", url, body, headers)
     }
 
     function del(url, headers) {
-        hdrs = { ...hdrs, ...headers }
-
-        return new Promise((resolve, reject) => {
-            setLoading(true);
-            fetch(baseUrl + url, {
-                method: "delete",
-                headers: hdrs
-            })
-                .then(response => response.json())
-                .then(data => {
-                    setLoading(false);
-                    if (!data || !data.data) {
-                        return reject(data);
-                    }
-                    resolve(data.data);
-                })
-                .catch(error => {
-                    setLoading(false);
-                    reject(error);
-                });
-        });
+        return send("delete", url, undefined, headers)
     }
 
     return { get, post, put, del, loading };
-};
\ No newline at end of file
+};